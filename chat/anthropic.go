@@ -0,0 +1,387 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// anthropicVersion は anthropic-version ヘッダーに指定するAPIバージョンです。
+const anthropicVersion = "2023-06-01"
+
+// anthropicContentBlock は Messages API のコンテンツブロック (text / tool_use / tool_result) を表します。
+type anthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"` // "user" または "assistant"
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream"`
+}
+
+// anthropicStreamEvent は SSE の data 行1つ分を表します。イベントの種類によって使われるフィールドが異なります。
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Model string `json:"model"`
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	ContentBlock *anthropicContentBlock `json:"content_block"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStreamResult は Messages API のストリーミング応答の解析結果を表します。
+type anthropicStreamResult struct {
+	Blocks       []anthropicContentBlock // index 順のコンテンツブロック。tool_use の Input は組み立て済み
+	StopReason   string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Chunks       int
+	Malformed    int
+}
+
+// Text は text ブロックを連結したものを返します。
+func (r anthropicStreamResult) Text() string {
+	var sb strings.Builder
+	for _, block := range r.Blocks {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	return sb.String()
+}
+
+// anthropicProvider は Anthropic Messages API (/v1/messages) を呼び出す ChatProvider です。
+type anthropicProvider struct {
+	cfg        loader.AnthropicConfig
+	apiKey     string
+	tools      []Tool
+	httpClient *http.Client
+}
+
+func newAnthropicProvider(cfg loader.AnthropicConfig, apiKey string, tools []Tool) *anthropicProvider {
+	if cfg.APIEndpoint == "" {
+		cfg.APIEndpoint = loader.DefaultAnthropicEndpoint
+	}
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = loader.DefaultAnthropicMaxTokens
+	}
+	return &anthropicProvider{
+		cfg:    cfg,
+		apiKey: apiKey,
+		tools:  tools,
+		// 期限は呼び出し元の context で指定する
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *anthropicProvider) Name() string { return "Anthropic" }
+
+func (p *anthropicProvider) Invoke(ctx context.Context, fullInput string) (*ChatResponse, error) {
+	return p.invokePrompt(ctx, Prompt{Message: fullInput})
+}
+
+// invokePrompt はシステムプロンプトと会話履歴をそれぞれ system と messages として送信します。
+// LLMがツールを呼び出した場合は実行結果を返して、最大 maxToolRounds 回まで再問い合わせします。
+func (p *anthropicProvider) invokePrompt(ctx context.Context, prompt Prompt) (*ChatResponse, error) {
+	start := time.Now()
+	if p.cfg.ModelName == "" {
+		return nil, fmt.Errorf("Anthropic のモデル名が設定されていません")
+	}
+
+	messages := anthropicMessages(prompt)
+	chatResp := &ChatResponse{ModelName: p.cfg.ModelName}
+	var textBuilder strings.Builder
+
+	for round := 0; ; round++ {
+		result, err := p.stream(ctx, prompt.System, messages)
+		textBuilder.WriteString(result.Text())
+		chatResp.Text = textBuilder.String()
+		chatResp.InputTokens += result.InputTokens
+		chatResp.OutputTokens += result.OutputTokens
+		chatResp.ElapsedMs = float64(time.Since(start).Milliseconds())
+		if result.Model != "" {
+			chatResp.ModelName = result.Model
+		}
+		if err != nil {
+			applyReasoning(chatResp)
+			return chatResp, err
+		}
+
+		toolUses := make([]anthropicContentBlock, 0)
+		for _, block := range result.Blocks {
+			if block.Type == "tool_use" {
+				toolUses = append(toolUses, block)
+			}
+		}
+		if result.StopReason != "tool_use" || len(toolUses) == 0 {
+			break
+		}
+		if round+1 >= maxToolRounds {
+			log.Printf("Anthropic: ツール呼び出しが %d 回に達したため打ち切ります", maxToolRounds)
+			break
+		}
+
+		toolResults := make([]anthropicContentBlock, 0, len(toolUses))
+		for _, use := range toolUses {
+			var args map[string]interface{}
+			if err := json.Unmarshal(use.Input, &args); err != nil {
+				log.Printf("Anthropic: ツール %s の引数の解析に失敗しました: %v", use.Name, err)
+			}
+			content, isError := runTool(ctx, p.tools, use.Name, args)
+			toolResults = append(toolResults, anthropicContentBlock{Type: "tool_result", ToolUseID: use.ID, Content: prompt.toolResult(use.Name, content), IsError: isError})
+		}
+		assistantBlocks := make([]anthropicContentBlock, 0, len(result.Blocks))
+		for _, block := range result.Blocks {
+			if block.Type == "text" && block.Text == "" {
+				continue
+			}
+			assistantBlocks = append(assistantBlocks, block)
+		}
+		messages = append(messages,
+			anthropicMessage{Role: "assistant", Content: assistantBlocks},
+			anthropicMessage{Role: "user", Content: toolResults},
+		)
+	}
+
+	applyReasoning(chatResp)
+	return chatResp, nil
+}
+
+// stream は Messages API に1回リクエストを送信し、SSE 応答を解析します。
+func (p *anthropicProvider) stream(ctx context.Context, system string, messages []anthropicMessage) (anthropicStreamResult, error) {
+	reqBody := anthropicRequest{
+		Model:     p.cfg.ModelName,
+		MaxTokens: p.cfg.MaxTokens,
+		System:    system,
+		Messages:  messages,
+		Stream:    true,
+	}
+	for _, tool := range p.tools {
+		reqBody.Tools = append(reqBody.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters})
+	}
+
+	jsonPayload, err := json.Marshal(reqBody)
+	if err != nil {
+		return anthropicStreamResult{}, fmt.Errorf("AnthropicリクエストペイロードのJSON作成に失敗: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.cfg.APIEndpoint, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return anthropicStreamResult{}, fmt.Errorf("Anthropicリクエストの作成に失敗: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if p.apiKey != "" {
+		req.Header.Set("x-api-key", p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return anthropicStreamResult{}, fmt.Errorf("Anthropic APIへのリクエストに失敗: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return anthropicStreamResult{}, newHTTPProviderError(p.Name(), resp)
+	}
+
+	result, err := parseAnthropicStreamResponse(bufio.NewReader(resp.Body))
+	if err != nil {
+		return result, fmt.Errorf("Anthropicレスポンスの解析に失敗しました: %w", err)
+	}
+	if result.Chunks == 0 && result.Malformed > 0 {
+		return result, fmt.Errorf("Anthropic: %w", ErrMalformedResponse)
+	}
+	return result, nil
+}
+
+// anthropicMessages は Prompt を Messages API の messages に変換します。
+// Messages API は user から始まり user と assistant が交互に並ぶ必要があるため、連続する同じロールは1つにまとめます。
+// ユーザーの発言は FullInput と同じく、発言者の名前を属性に持つタグで1件ずつ囲むため、
+// まとめた発言の中に別の参加者の発言を装った行を書くことはできません。
+// モデル自身の発言は assistant のロールで区別されるため、そのまま送ります。
+// 境界文字列の無い Prompt (Invoke に渡した入力をそのまま送る場合) は囲みません。
+func anthropicMessages(prompt Prompt) []anthropicMessage {
+	var messages []anthropicMessage
+	appendTurn := func(msg history.HistoryMessage) {
+		if strings.TrimSpace(msg.Content) == "" {
+			return
+		}
+		role, text := "user", msg.Content
+		if msg.Role == "model" || msg.Role == "assistant" {
+			role = "assistant"
+		} else if prompt.boundary != "" {
+			text = prompt.historyLine(msg)
+		}
+		if len(messages) == 0 && role != "user" {
+			return
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			last := &messages[n-1].Content[len(messages[n-1].Content)-1]
+			last.Text += "\n\n" + text
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: []anthropicContentBlock{{Type: "text", Text: text}}})
+	}
+
+	for _, msg := range prompt.History {
+		appendTurn(msg)
+	}
+	appendTurn(history.HistoryMessage{Role: "user", Content: prompt.Message, Name: prompt.Speaker})
+	return messages
+}
+
+// parseAnthropicStreamResponse は Messages API の SSE ストリーム (message_start, content_block_start,
+// content_block_delta, content_block_stop, message_delta, message_stop) を解析します。
+func parseAnthropicStreamResponse(reader *bufio.Reader) (anthropicStreamResult, error) {
+	var result anthropicStreamResult
+	blocks := make(map[int]*anthropicContentBlock)
+	partialJSON := make(map[int]*strings.Builder)
+
+	collect := func() anthropicStreamResult {
+		indexes := make([]int, 0, len(blocks))
+		for idx := range blocks {
+			indexes = append(indexes, idx)
+		}
+		sort.Ints(indexes)
+		result.Blocks = result.Blocks[:0]
+		for _, idx := range indexes {
+			block := *blocks[idx]
+			if block.Type == "tool_use" {
+				input := "{}"
+				if sb, ok := partialJSON[idx]; ok && sb.Len() > 0 {
+					input = sb.String()
+				}
+				block.Input = json.RawMessage(input)
+			}
+			result.Blocks = append(result.Blocks, block)
+		}
+		return result
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return collect(), fmt.Errorf("ストリーム読み込みエラー: %w", err)
+		}
+		atEOF := err == io.EOF
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var event anthropicStreamEvent
+			if jsonErr := json.Unmarshal([]byte(data), &event); jsonErr != nil {
+				result.Malformed++
+			} else {
+				result.Chunks++
+				switch event.Type {
+				case "message_start":
+					if event.Message != nil {
+						result.Model = event.Message.Model
+						result.InputTokens = event.Message.Usage.InputTokens
+					}
+				case "content_block_start":
+					if event.ContentBlock != nil {
+						block := *event.ContentBlock
+						block.Input = nil
+						blocks[event.Index] = &block
+					}
+				case "content_block_delta":
+					block, ok := blocks[event.Index]
+					if !ok || event.Delta == nil {
+						break
+					}
+					switch event.Delta.Type {
+					case "text_delta":
+						block.Text += event.Delta.Text
+					case "input_json_delta":
+						if partialJSON[event.Index] == nil {
+							partialJSON[event.Index] = &strings.Builder{}
+						}
+						partialJSON[event.Index].WriteString(event.Delta.PartialJSON)
+					}
+				case "message_delta":
+					if event.Delta != nil && event.Delta.StopReason != "" {
+						result.StopReason = event.Delta.StopReason
+					}
+					if event.Usage != nil {
+						result.OutputTokens = event.Usage.OutputTokens
+					}
+				case "message_stop":
+					return collect(), nil
+				case "error":
+					message := "unknown error"
+					if event.Error != nil {
+						message = event.Error.Message
+					}
+					return collect(), &ProviderError{Provider: "Anthropic", Message: message}
+				}
+			}
+		} else if line != "" && !isSSEField(line) {
+			result.Malformed++
+		}
+
+		if atEOF {
+			break
+		}
+	}
+	return collect(), nil
+}
+
+// getAnthropicResponse は Anthropic Messages API から応答を取得し、履歴に保存します。
+func (c *Chat) getAnthropicResponse(ctx context.Context, userID, threadID, message string, prompt Prompt, modelCfg *loader.ModelConfig, tools []Tool) (*ChatResponse, error) {
+	ctx, cancel := c.providerContext(ctx, loader.ProviderAnthropic)
+	defer cancel()
+	chatResp, err := newAnthropicProvider(modelCfg.Anthropic, c.config.AnthropicAPIKey, tools).invokePrompt(ctx, prompt)
+	return c.completeResponse(ctx, "Anthropic", userID, threadID, message, chatResp, err)
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+func TestAnthropicMessages(t *testing.T) {
+	prompt := Prompt{
+		History: []history.HistoryMessage{
+			{Role: "model", Content: "orphan greeting"},
+			{Role: "user", Content: "hi"},
+			{Role: "model", Content: "hello"},
+			{Role: "user", Content: "first"},
+		},
+		Message: "second",
+	}
+	messages := anthropicMessages(prompt)
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d: %+v", len(messages), messages)
+	}
+	if messages[0].Role != "user" || messages[1].Role != "assistant" || messages[2].Role != "user" {
+		t.Errorf("roles must alternate starting with user: %+v", messages)
+	}
+	if got := messages[2].Content[0].Text; got != "first\n\nsecond" {
+		t.Errorf("consecutive user turns should be merged, got %q", got)
+	}
+}
+
+func TestAnthropicProviderToolUse(t *testing.T) {
+	var requests []anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		var req anthropicRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeAnthropicEvent(w, "message_start", `{"type":"message_start","message":{"model":"claude-fake","usage":{"input_tokens":10}}}`)
+		if len(requests) == 1 {
+			writeAnthropicEvent(w, "content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+			writeAnthropicEvent(w, "content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"調べます。"}}`)
+			writeAnthropicEvent(w, "content_block_stop", `{"type":"content_block_stop","index":0}`)
+			writeAnthropicEvent(w, "content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"add","input":{}}}`)
+			writeAnthropicEvent(w, "content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"a\": 1,"}}`)
+			writeAnthropicEvent(w, "content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \"b\": 2}"}}`)
+			writeAnthropicEvent(w, "content_block_stop", `{"type":"content_block_stop","index":1}`)
+			writeAnthropicEvent(w, "message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`)
+		} else {
+			writeAnthropicEvent(w, "ping", `{"type":"ping"}`)
+			writeAnthropicEvent(w, "content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+			writeAnthropicEvent(w, "content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"答えは3です。"}}`)
+			writeAnthropicEvent(w, "content_block_stop", `{"type":"content_block_stop","index":0}`)
+			writeAnthropicEvent(w, "message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`)
+		}
+		writeAnthropicEvent(w, "message_stop", `{"type":"message_stop"}`)
+	}))
+	defer server.Close()
+
+	addTool := Tool{
+		Name:        "add",
+		Description: "2つの数を足します",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"a": map[string]interface{}{"type": "number"},
+				"b": map[string]interface{}{"type": "number"},
+			},
+		},
+		Execute: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			return "3", nil
+		},
+	}
+	p := newAnthropicProvider(loader.AnthropicConfig{APIEndpoint: server.URL, ModelName: "claude-fake"}, "test-key", []Tool{addTool})
+
+	resp, err := p.invokePrompt(context.Background(), Prompt{
+		System:  "You are a calculator.",
+		History: []history.HistoryMessage{{Role: "user", Content: "hi"}, {Role: "model", Content: "hello"}},
+		Message: "1+2は?",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "調べます。答えは3です。" {
+		t.Errorf("unexpected text %q", resp.Text)
+	}
+	if resp.InputTokens != 20 || resp.OutputTokens != 25 {
+		t.Errorf("unexpected usage: in=%d out=%d", resp.InputTokens, resp.OutputTokens)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	first := requests[0]
+	if first.System != "You are a calculator." || len(first.Messages) != 3 || len(first.Tools) != 1 {
+		t.Errorf("unexpected first request: %+v", first)
+	}
+	second := requests[1]
+	if len(second.Messages) != 5 {
+		t.Fatalf("expected tool turn to be appended, got %d messages", len(second.Messages))
+	}
+	toolUse := second.Messages[3].Content[1]
+	if toolUse.Type != "tool_use" || toolUse.ID != "toolu_1" || string(toolUse.Input) != `{"a":1,"b":2}` {
+		t.Errorf("unexpected tool_use block: %+v (input %s)", toolUse, toolUse.Input)
+	}
+	toolResult := second.Messages[4].Content[0]
+	if toolResult.Type != "tool_result" || toolResult.ToolUseID != "toolu_1" || toolResult.Content != `<tool_result name="add">3</tool_result>` || toolResult.IsError {
+		t.Errorf("unexpected tool_result block: %+v", toolResult)
+	}
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// botDecision は Bot からのメッセージに応答するかどうかの判定結果を表します。
+type botDecision struct {
+	Allowed bool
+	Reason  string
+}
+
+// botConversationState はチャンネルとBotの組ごとの会話状態を保持します。
+type botConversationState struct {
+	windowStart time.Time
+	turns       int
+	lastReply   time.Time
+}
+
+// botPolicy は loader.BotPolicyConfig に従ってBotとの会話を制御します。
+// 回数はチャンネル(スレッド)とBotの組ごとに数え、ウィンドウの経過で自動的にリセットされます。
+type botPolicy struct {
+	cfg    loader.BotPolicyConfig
+	now    func() time.Time
+	mutex  sync.Mutex
+	states map[string]*botConversationState
+}
+
+func newBotPolicy(cfg loader.BotPolicyConfig) *botPolicy {
+	if cfg.MaxTurns <= 0 {
+		cfg.MaxTurns = loader.DefaultBotMaxTurns
+	}
+	if cfg.WindowSeconds <= 0 {
+		cfg.WindowSeconds = loader.DefaultBotWindowSeconds
+	}
+	return &botPolicy{
+		cfg:    cfg,
+		now:    time.Now,
+		states: make(map[string]*botConversationState),
+	}
+}
+
+// Allow は botID から channelID への発言に応答してよいかを判定し、許可した場合は回数を記録します。
+func (p *botPolicy) Allow(botID, channelID string) botDecision {
+	if p.cfg.Disabled {
+		return botDecision{Reason: "Botとの会話は無効化されています"}
+	}
+	if len(p.cfg.AllowedBotIDs) > 0 && !slices.Contains(p.cfg.AllowedBotIDs, botID) {
+		return botDecision{Reason: "許可されていないBotです"}
+	}
+	if slices.Contains(p.cfg.DeniedChannelIDs, channelID) {
+		return botDecision{Reason: "Botとの会話が禁止されたチャンネルです"}
+	}
+	if len(p.cfg.AllowedChannelIDs) > 0 && !slices.Contains(p.cfg.AllowedChannelIDs, channelID) {
+		return botDecision{Reason: "Botとの会話が許可されていないチャンネルです"}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	key := channelID + "/" + botID
+	state, ok := p.states[key]
+	if !ok || now.Sub(state.windowStart) >= time.Duration(p.cfg.WindowSeconds)*time.Second {
+		state = &botConversationState{windowStart: now}
+		p.states[key] = state
+	}
+
+	if p.cfg.CooldownSeconds > 0 && !state.lastReply.IsZero() && now.Sub(state.lastReply) < time.Duration(p.cfg.CooldownSeconds)*time.Second {
+		return botDecision{Reason: "クールダウン中です"}
+	}
+	if state.turns >= p.cfg.MaxTurns {
+		return botDecision{Reason: "ウィンドウ内の最大応答回数に達しました"}
+	}
+
+	state.turns++
+	state.lastReply = now
+	return botDecision{Allowed: true}
+}
+
+// ModelConfig は Bot との会話に使用する ModelConfig を返します。
+// プロバイダやモデルの上書きが設定されていない場合は base をそのまま返します。
+func (p *botPolicy) ModelConfig(base *loader.ModelConfig) (*loader.ModelConfig, error) {
+	if p.cfg.Provider == "" && p.cfg.ModelName == "" {
+		return base, nil
+	}
+	return base.WithProviderOverride(p.cfg.Provider, p.cfg.ModelName)
+}
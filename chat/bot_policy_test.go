@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+func newTestBotPolicy(cfg loader.BotPolicyConfig, now *time.Time) *botPolicy {
+	p := newBotPolicy(cfg)
+	p.now = func() time.Time { return *now }
+	return p
+}
+
+func TestBotPolicyAllow(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("max turns per window with automatic reset", func(t *testing.T) {
+		now := base
+		p := newTestBotPolicy(loader.BotPolicyConfig{MaxTurns: 2, WindowSeconds: 60}, &now)
+		for i := 0; i < 2; i++ {
+			if d := p.Allow("bot1", "ch1"); !d.Allowed {
+				t.Fatalf("turn %d should be allowed: %s", i+1, d.Reason)
+			}
+		}
+		if d := p.Allow("bot1", "ch1"); d.Allowed {
+			t.Fatal("third turn should be denied")
+		}
+		if d := p.Allow("bot1", "ch2"); !d.Allowed {
+			t.Error("other channel should have its own counter")
+		}
+
+		now = base.Add(61 * time.Second)
+		if d := p.Allow("bot1", "ch1"); !d.Allowed {
+			t.Errorf("counter should reset after the window: %s", d.Reason)
+		}
+	})
+
+	t.Run("defaults apply when unset", func(t *testing.T) {
+		now := base
+		p := newTestBotPolicy(loader.BotPolicyConfig{}, &now)
+		for i := 0; i < loader.DefaultBotMaxTurns; i++ {
+			p.Allow("bot1", "ch1")
+		}
+		if d := p.Allow("bot1", "ch1"); d.Allowed {
+			t.Error("expected default max turns to be enforced")
+		}
+	})
+
+	t.Run("cooldown between replies", func(t *testing.T) {
+		now := base
+		p := newTestBotPolicy(loader.BotPolicyConfig{MaxTurns: 10, CooldownSeconds: 30}, &now)
+		p.Allow("bot1", "ch1")
+		now = base.Add(10 * time.Second)
+		if d := p.Allow("bot1", "ch1"); d.Allowed {
+			t.Error("expected reply within cooldown to be denied")
+		}
+		now = base.Add(31 * time.Second)
+		if d := p.Allow("bot1", "ch1"); !d.Allowed {
+			t.Errorf("expected reply after cooldown to be allowed: %s", d.Reason)
+		}
+	})
+
+	t.Run("allowed bots and channel lists", func(t *testing.T) {
+		now := base
+		p := newTestBotPolicy(loader.BotPolicyConfig{
+			AllowedBotIDs:     []string{"bot1"},
+			AllowedChannelIDs: []string{"ch1", "ch2"},
+			DeniedChannelIDs:  []string{"ch2"},
+		}, &now)
+		if d := p.Allow("bot2", "ch1"); d.Allowed {
+			t.Error("bot2 is not in the allow list")
+		}
+		if d := p.Allow("bot1", "ch2"); d.Allowed {
+			t.Error("ch2 is denied")
+		}
+		if d := p.Allow("bot1", "ch3"); d.Allowed {
+			t.Error("ch3 is not in the allow list")
+		}
+		if d := p.Allow("bot1", "ch1"); !d.Allowed {
+			t.Errorf("bot1 in ch1 should be allowed: %s", d.Reason)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		now := base
+		p := newTestBotPolicy(loader.BotPolicyConfig{Disabled: true}, &now)
+		if d := p.Allow("bot1", "ch1"); d.Allowed {
+			t.Error("expected all bots to be denied when disabled")
+		}
+	})
+}
+
+func TestBotPolicyModelConfig(t *testing.T) {
+	base := &loader.ModelConfig{
+		ModelName: "gemini-test",
+		Ollama:    loader.OllamaConfig{APIEndpoint: "http://localhost:11434/api/generate", ModelName: "gemma"},
+	}
+
+	t.Run("no override returns base", func(t *testing.T) {
+		p := newBotPolicy(loader.BotPolicyConfig{})
+		got, err := p.ModelConfig(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != base {
+			t.Error("expected base config to be returned unchanged")
+		}
+	})
+
+	t.Run("force ollama with model", func(t *testing.T) {
+		p := newBotPolicy(loader.BotPolicyConfig{Provider: loader.ProviderOllama, ModelName: "llama3"})
+		got, err := p.ModelConfig(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got.Ollama.Enabled || got.Ollama.ModelName != "llama3" {
+			t.Errorf("expected Ollama llama3 to be forced, got enabled=%v model=%q", got.Ollama.Enabled, got.Ollama.ModelName)
+		}
+		if base.Ollama.Enabled || base.Ollama.ModelName != "gemma" {
+			t.Error("base config must not be modified")
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		p := newBotPolicy(loader.BotPolicyConfig{Provider: "unknown"})
+		if _, err := p.ModelConfig(base); err == nil {
+			t.Error("expected error for unknown provider")
+		}
+	})
+}
@@ -2,34 +2,51 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/eraiza0816/llm-discord/config"
 	"github.com/eraiza0816/llm-discord/history"
 	"github.com/eraiza0816/llm-discord/loader"
 
+	generativelanguage "cloud.google.com/go/ai/generativelanguage/apiv1beta"
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 var errorLogger *log.Logger
 
 type Service interface {
-	GetResponse(ctx context.Context, userID, threadID, username, message, timestamp, prompt string, isBot bool) (string, float64, string, error)
+	GetResponse(ctx context.Context, params ChatParams) (*ChatResponse, error)
+	GetStructured(ctx context.Context, prompt string, schema map[string]interface{}) (json.RawMessage, error)
+	// GetSummary は会話の要約を返します。要約がまだ無い場合は ok が false です。
+	GetSummary(userID, threadID string) (summary history.Summary, ok bool, err error)
+	// RegenerateSummary は保存されている会話履歴の全体から要約を作り直します。
+	RegenerateSummary(ctx context.Context, userID, threadID string) (history.Summary, error)
+	// PreviewSystemPrompt は custom をカスタムプロンプトとして使った場合の、展開後のシステムプロンプトを返します。
+	PreviewSystemPrompt(params ChatParams, custom string) (string, error)
+	// CheckCustomPrompt は LLM で custom がシステムプロンプトとして不適切でないかを確認します。
+	CheckCustomPrompt(ctx context.Context, custom string) (allowed bool, reason string, err error)
 	Close()
 }
 
 type Chat struct {
 	genaiClient *genai.Client
-	genaiModel  *genai.GenerativeModel
+	restClient  *generativelanguage.GenerativeClient // Google 検索によるグラウンディングと関数呼び出し用 (非ストリーミング)
 	historyMgr  history.HistoryManager
 	modelConfig *loader.ModelConfig
 	config      *config.Config
+	botPolicy   *botPolicy
+	webFetcher  *webFetcher // web_fetch が無効な場合は nil
+	summarizing sync.Map    // 要約を更新中の会話 ("userID/threadID")
+	remembering sync.Map    // 記憶を抽出中のユーザー (userID)
 }
 
 func NewChat(cfg *config.Config, historyMgr history.HistoryManager) (Service, error) {
@@ -42,42 +59,146 @@ func NewChat(cfg *config.Config, historyMgr history.HistoryManager) (Service, er
 	}
 
 	initialModelCfg := cfg.Model
-	initialGeminiModelName := initialModelCfg.ModelName
 
 	genaiClient, err := genai.NewClient(context.Background(), option.WithAPIKey(cfg.GeminiAPIKey))
 	if err != nil {
 		return nil, fmt.Errorf("Geminiクライアントの作成に失敗: %w", err)
 	}
-	genaiModel := genaiClient.GenerativeModel(initialGeminiModelName)
+
+	restClient, err := generativelanguage.NewGenerativeRESTClient(context.Background(), option.WithAPIKey(cfg.GeminiAPIKey))
+	if err != nil {
+		genaiClient.Close()
+		return nil, fmt.Errorf("Gemini (REST) クライアントの作成に失敗: %w", err)
+	}
+
+	var fetcher *webFetcher
+	if initialModelCfg.WebFetch.Enabled {
+		// DuckDB の履歴を使う場合は、取得したページも同じデータベースにキャッシュする
+		cache, _ := historyMgr.(history.URLCache)
+		fetcher = newWebFetcher(initialModelCfg.WebFetch, cache)
+	}
 
 	return &Chat{
 		genaiClient: genaiClient,
-		genaiModel:  genaiModel,
+		restClient:  restClient,
 		historyMgr:  historyMgr,
 		modelConfig: initialModelCfg,
 		config:      cfg,
+		botPolicy:   newBotPolicy(initialModelCfg.BotPolicy),
+		webFetcher:  fetcher,
 	}, nil
 }
 
-func (c *Chat) GetResponse(ctx context.Context, userID, threadID, username, message, timestamp, defaultSystemPrompt string, isBot bool) (string, float64, string, error) {
-	if isBot {
-		count, err := c.historyMgr.GetBotConversationCount(threadID, userID)
+func (c *Chat) GetResponse(ctx context.Context, params ChatParams) (*ChatResponse, error) {
+	userID, threadID, message := params.UserID, params.ThreadID, params.Message
+	modelCfg := c.modelConfig
+	if params.IsBot {
+		decision := c.botPolicy.Allow(userID, threadID)
+		if !decision.Allowed {
+			log.Printf("Botとの会話ポリシーにより応答を中断します。UserID: %s, ThreadID: %s, 理由: %s", userID, threadID, decision.Reason)
+			return &ChatResponse{}, nil
+		}
+		botModelCfg, err := c.botPolicy.ModelConfig(modelCfg)
 		if err != nil {
-			errorLogger.Printf("Failed to get bot conversation count: %v", err)
+			errorLogger.Printf("Failed to apply bot policy model override: %v", err)
+		} else if botModelCfg != modelCfg {
+			log.Printf("Botとの対話のため、bot_policy のモデル設定を使用します。UserID: %s, Provider: %s, Model: %s", userID, c.botPolicy.cfg.Provider, c.botPolicy.cfg.ModelName)
+			modelCfg = botModelCfg
 		}
-		if count >= 3 {
-			log.Printf("Botとの会話が3回に達したため、応答を中断します。UserID: %s, ThreadID: %s", userID, threadID)
-			return "", 0, "", nil
+	}
+
+	if refusal := screenInjection(modelCfg, params); refusal != nil {
+		return refusal, nil
+	}
+	ctx = withParentChannel(ctx, params.ParentChannelID)
+
+	// 共有スレッドでは参加者全員の発言を1つの履歴 (と要約) にまとめ、発言者の名前を付けて残す
+	speaker := ""
+	if params.SharedThread {
+		speaker = sharedSpeaker(params)
+		userID = history.SharedUserID
+		ctx = withSpeaker(ctx, speaker)
+	}
+
+	persona := c.resolvePersona(modelCfg, params)
+	// bot_policy でモデルを上書きしている場合は、ペルソナのモデルの指定よりそちらを優先する
+	if persona != nil && (persona.Provider != "" || persona.ModelName != "") && modelCfg == c.modelConfig {
+		personaModelCfg, err := modelCfg.WithProviderOverride(persona.Provider, persona.ModelName)
+		if err != nil {
+			errorLogger.Printf("Failed to apply persona %s model override: %v", persona.id, err)
+		} else {
+			modelCfg = personaModelCfg
 		}
 	}
 
-	modelCfg := c.modelConfig
-	if isBot && modelCfg.Ollama.Enabled {
-		log.Printf("Botとの対話のため、Ollamaモデルを強制的に使用します。UserID: %s", userID)
+	// fetch_url はURLが示された場合のみ渡す。ツールがあると Gemini は関数呼び出し用の問い合わせに切り替わるため
+	tools := params.Tools
+	if c.webFetcher != nil && !params.IsBot && mentionsURL(message) {
+		tools = append(slices.Clip(tools), c.webFetcher.Tool())
+	}
+	toolsUnavailable := len(tools) > 0 && !c.toolsSupported(ctx, modelCfg, threadID)
+	if toolsUnavailable {
+		log.Printf("有効なプロバイダではツール (%s) を使えないため、ツール無しで応答します。UserID: %s, ThreadID: %s", strings.Join(toolNames(tools), ", "), userID, threadID)
+		tools = nil
+	}
+
+	provider, modelName := modelCfg.ActiveModel()
+	currentSystemPrompt, timestamp := renderSystemPrompt(modelCfg, params, modelName, persona, c.customPrompt(params))
+	var prompt Prompt
+	var summaryStore history.SummaryStore
+	var overflow []history.HistoryMessage
+	if len(params.ReplyChain) > 0 {
+		// 返信先の会話を続けるため、保存されている履歴ではなく Discord 上の返信の連鎖を使う
+		prompt = buildPrompt(currentSystemPrompt, message, nil, userID, threadID, timestamp)
+		prompt.History = params.ReplyChain
+	} else if summaryStore = c.summaryStore(modelCfg); summaryStore != nil {
+		// 要約に加える古い履歴も、プロンプト用の履歴と一緒に1回で読み込む
+		prompt = buildPrompt(currentSystemPrompt, message, nil, userID, threadID, timestamp)
+		var err error
+		if prompt.History, overflow, err = summaryStore.GetWithOverflow(userID, threadID); err != nil {
+			log.Printf("ユーザー %s のスレッド %s の履歴取得に失敗しました: %v", userID, threadID, err)
+		}
+	} else {
+		prompt = buildPrompt(currentSystemPrompt, message, c.historyMgr, userID, threadID, timestamp)
+	}
+	recent := prompt.History
+	prompt.Speaker = speaker
+	if persona != nil {
+		prompt = withPersonaExamples(prompt, persona.Examples)
+	}
+	memoryStore := c.memoryStore(modelCfg, params)
+	if memoryStore != nil {
+		prompt = withMemories(prompt, c.relevantMemories(modelCfg, memoryStore, params.UserID, message))
+	}
+	prompt = withChannelContext(prompt, params.ChannelContext)
+	if toolsUnavailable {
+		prompt.System += "\n" + toolsUnavailableNotice
+	}
+	var summary history.Summary
+	if summaryStore != nil {
+		var err error
+		if summary, _, err = summaryStore.GetSummary(userID, threadID); err != nil {
+			errorLogger.Printf("ユーザー %s のスレッド %s の要約の取得に失敗しました: %v", userID, threadID, err)
+		}
 	}
+	prompt = fitPromptToBudget(ctx, withSummary(prompt, summary.Text), modelCfg.Context.Budget(provider, modelName), c.tokenCounter(provider, modelName))
+	if summaryStore != nil {
+		c.scheduleSummaryUpdate(userID, threadID, modelCfg, summaryStore, summary, overflow, recent, len(prompt.History))
+	}
+
+	resp, err := c.invokeProvider(ctx, userID, threadID, message, prompt, modelCfg, tools)
+	if resp != nil && persona != nil {
+		resp.PersonaName, resp.PersonaIcon = persona.Name(persona.id), persona.Icon
+	}
+	if err == nil && resp != nil && resp.Text != "" && memoryStore != nil {
+		c.scheduleMemoryExtraction(params.UserID, message, modelCfg, memoryStore)
+	}
+	return resp, err
+}
 
-	currentSystemPrompt := modelCfg.GetPromptByUser(username)
-	fullInput := buildFullInput(currentSystemPrompt, message, c.historyMgr, userID, threadID, timestamp)
+// invokeProvider は modelCfg で有効なプロバイダに prompt を渡して応答を生成します。
+func (c *Chat) invokeProvider(ctx context.Context, userID, threadID, message string, prompt Prompt, modelCfg *loader.ModelConfig, tools []Tool) (*ChatResponse, error) {
+	fullInput := prompt.FullInput()
 
 	if modelCfg.Ollama.Enabled {
 		return c.invokeOllama(ctx, userID, threadID, message, fullInput, modelCfg)
@@ -85,59 +206,104 @@ func (c *Chat) GetResponse(ctx context.Context, userID, threadID, username, mess
 	if modelCfg.OpenAI.Enabled {
 		return c.invokeOpenAI(ctx, userID, threadID, message, fullInput, modelCfg)
 	}
+	if modelCfg.Anthropic.Enabled {
+		return c.invokeAnthropic(ctx, userID, threadID, message, prompt, modelCfg, tools)
+	}
+	if modelCfg.GeminiTools.GroundingEnabled(threadID, parentChannelFrom(ctx)) && c.restClient != nil {
+		return c.invokeGeminiGrounded(ctx, userID, threadID, message, fullInput, modelCfg)
+	}
+	if len(tools) > 0 {
+		return c.invokeGeminiWithTools(ctx, userID, threadID, message, prompt, modelCfg, tools)
+	}
 	return c.invokeGemini(ctx, userID, threadID, message, fullInput, modelCfg)
 }
 
-func (c *Chat) invokeOllama(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (string, float64, string, error) {
+// toolsSupported は、このリクエストで有効なプロバイダにツール (関数呼び出し) を渡せるかを返します。
+// Ollama と OpenAI 互換 API の呼び出しは関数呼び出しに対応しておらず、
+// Gemini の Google 検索によるグラウンディングとの併用や、REST クライアントが無い場合も使えません。
+func (c *Chat) toolsSupported(ctx context.Context, modelCfg *loader.ModelConfig, threadID string) bool {
+	switch {
+	case modelCfg.Ollama.Enabled, modelCfg.OpenAI.Enabled:
+		return false
+	case modelCfg.Anthropic.Enabled:
+		return true
+	default:
+		return c.restClient != nil && !modelCfg.GeminiTools.GroundingEnabled(threadID, parentChannelFrom(ctx))
+	}
+}
+
+func (c *Chat) invokeOllama(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (*ChatResponse, error) {
 	log.Printf("Using Ollama (%s) for user %s in thread %s", modelCfg.Ollama.ModelName, userID, threadID)
-	responseText, elapsed, err := c.getOllamaResponse(ctx, userID, threadID, message, fullInput, modelCfg.Ollama)
+	resp, err := c.getOllamaResponse(ctx, userID, threadID, message, fullInput, modelCfg.Ollama)
 	if err != nil {
 		errorLogger.Printf("Ollama API call failed for user %s in thread %s: %v", userID, threadID, err)
-		return "", elapsed, modelCfg.Ollama.ModelName, fmt.Errorf("Ollama APIからのエラー: %w", err)
+		return resp, fmt.Errorf("Ollama APIからのエラー: %w", err)
 	}
-	return responseText, elapsed, modelCfg.Ollama.ModelName, nil
+	return resp, nil
 }
 
-func (c *Chat) invokeOpenAI(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (string, float64, string, error) {
+func (c *Chat) invokeOpenAI(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (*ChatResponse, error) {
 	log.Printf("Using OpenAI compatible API (%s) for user %s in thread %s", modelCfg.OpenAI.ModelName, userID, threadID)
-	responseText, elapsed, err := c.getOpenAIResponse(ctx, userID, threadID, message, fullInput, modelCfg.OpenAI)
+	resp, err := c.getOpenAIResponse(ctx, userID, threadID, message, fullInput, modelCfg.OpenAI)
 	if err != nil {
 		errorLogger.Printf("OpenAI API call failed for user %s in thread %s: %v", userID, threadID, err)
-		return "", elapsed, modelCfg.OpenAI.ModelName, fmt.Errorf("OpenAI APIからのエラー: %w", err)
+		return resp, fmt.Errorf("OpenAI APIからのエラー: %w", err)
+	}
+	return resp, nil
+}
+
+func (c *Chat) invokeAnthropic(ctx context.Context, userID, threadID, message string, prompt Prompt, modelCfg *loader.ModelConfig, tools []Tool) (*ChatResponse, error) {
+	log.Printf("Using Anthropic (%s) for user %s in thread %s", modelCfg.Anthropic.ModelName, userID, threadID)
+	resp, err := c.getAnthropicResponse(ctx, userID, threadID, message, prompt, modelCfg, tools)
+	if err != nil {
+		errorLogger.Printf("Anthropic API call failed for user %s in thread %s: %v", userID, threadID, err)
+		return resp, fmt.Errorf("Anthropic APIからのエラー: %w", err)
 	}
-	return responseText, elapsed, modelCfg.OpenAI.ModelName, nil
+	return resp, nil
 }
 
-func (c *Chat) invokeGemini(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (string, float64, string, error) {
+func (c *Chat) invokeGemini(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (*ChatResponse, error) {
 	log.Printf("Using Gemini (%s) for user %s", modelCfg.ModelName, userID)
-	c.genaiModel = c.genaiClient.GenerativeModel(modelCfg.ModelName)
+	// 同時に処理している他の要求とツールやモデルが混ざらないよう、モデルは要求ごとに作成する
+	provider := newGeminiProvider(c.genaiClient, modelCfg.ModelName)
+	if modelCfg.GeminiTools.CodeExecutionEnabled(threadID, parentChannelFrom(ctx)) {
+		provider.model.Tools = []*genai.Tool{{CodeExecution: &genai.CodeExecution{}}}
+	}
 
 	start := time.Now()
-	resp, err := c.genaiModel.GenerateContent(ctx, genai.Text(fullInput))
+	genCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+	resp, err := provider.generate(genCtx, genai.Text(fullInput))
+	cancel()
 	elapsed := float64(time.Since(start).Milliseconds())
 
 	if err != nil {
 		return c.handleGeminiError(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, err)
 	}
 
-	return c.processGeminiResponse(ctx, userID, threadID, message, modelCfg, resp, start, elapsed)
+	return c.processGeminiResponse(ctx, userID, threadID, message, modelCfg, provider.model, resp, start, elapsed)
 }
 
-func (c *Chat) handleGeminiError(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig, elapsed float64, err error) (string, float64, string, error) {
+func (c *Chat) handleGeminiError(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig, elapsed float64, err error) (*ChatResponse, error) {
 	errorLogger.Printf("Initial Gemini API call failed for model %s: %v", modelCfg.ModelName, err)
 
-	gapiErr, isQuotaExceeded := err.(*googleapi.Error)
-	if !isQuotaExceeded || gapiErr.Code != 429 {
+	var blockedErr *genai.BlockedError
+	if errors.As(err, &blockedErr) {
+		return c.handleGeminiBlocked(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, newContentBlockedError(blockedErr, modelCfg.ModelName))
+	}
+
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || !providerErr.IsRateLimited() {
 		errorLogger.Printf("Gemini API error: input=%q err=%v", fullInput, err)
-		return "", elapsed, modelCfg.ModelName, fmt.Errorf("Gemini APIからのエラー: %w", err)
+		return &ChatResponse{ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, fmt.Errorf("Gemini APIからのエラー: %w", err)
 	}
 
 	log.Printf("Quota exceeded for model %s. Attempting fallback...", modelCfg.ModelName)
 
 	if modelCfg.SecondaryModelName != "" {
-		secResp, secElapsed, secErr := c.invokeSecondaryModel(ctx, fullInput, modelCfg)
+		secondary := newGeminiProvider(c.genaiClient, modelCfg.SecondaryModelName)
+		secResp, secElapsed, secErr := c.invokeSecondaryModel(ctx, secondary, fullInput)
 		if secErr == nil {
-			return c.processGeminiResponse(ctx, userID, threadID, message, modelCfg, secResp, time.Now(), secElapsed)
+			return c.processGeminiResponse(ctx, userID, threadID, message, modelCfg, secondary.model, secResp, time.Now(), secElapsed)
 		}
 		elapsed = secElapsed
 		err = secErr
@@ -147,55 +313,79 @@ func (c *Chat) handleGeminiError(ctx context.Context, userID, threadID, message,
 
 	if modelCfg.Ollama.Enabled {
 		log.Printf("Falling back to Ollama (%s) for user %s in thread %s", modelCfg.Ollama.ModelName, userID, threadID)
-		responseText, ollamaElapsed, ollamaErr := c.getOllamaResponse(ctx, userID, threadID, message, fullInput, modelCfg.Ollama)
+		ollamaResp, ollamaErr := c.getOllamaResponse(ctx, userID, threadID, message, fullInput, modelCfg.Ollama)
 		if ollamaErr != nil {
 			errorLogger.Printf("Ollama fallback failed for user %s in thread %s: %v", userID, threadID, ollamaErr)
-			return "", elapsed, modelCfg.ModelName, fmt.Errorf("Gemini APIクォータ超過後、Ollamaフォールバックも失敗: (Gemini: %w), (Ollama: %v)", err, ollamaErr)
+			return &ChatResponse{ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, fmt.Errorf("Gemini APIクォータ超過後、Ollamaフォールバックも失敗: (Gemini: %w), (Ollama: %v)", err, ollamaErr)
 		}
 		log.Printf("Successfully generated content with Ollama fallback: %s for user %s in thread %s", modelCfg.Ollama.ModelName, userID, threadID)
-		return responseText, ollamaElapsed, modelCfg.Ollama.ModelName, nil
+		return ollamaResp, nil
 	}
 
 	log.Println("Ollama is not enabled, cannot fallback.")
-	return "", elapsed, modelCfg.ModelName, fmt.Errorf("Gemini APIクォータ超過、フォールバック先なし: %w", err)
+	return &ChatResponse{ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, fmt.Errorf("Gemini APIクォータ超過、フォールバック先なし: %w", err)
 }
 
-func (c *Chat) invokeSecondaryModel(ctx context.Context, fullInput string, modelCfg *loader.ModelConfig) (*genai.GenerateContentResponse, float64, error) {
-	log.Printf("Attempting retry with secondary model: %s", modelCfg.SecondaryModelName)
-	secondaryModel := c.genaiClient.GenerativeModel(modelCfg.SecondaryModelName)
+// handleGeminiBlocked はブロックされた理由と安全性評価を記録し、block_fallback に設定があればそのモデルで再試行します。
+func (c *Chat) handleGeminiBlocked(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig, elapsed float64, blocked *ContentBlockedError) (*ChatResponse, error) {
+	errorLogger.Printf("Gemini response blocked for model %s (%s): safety ratings: %s", blocked.Model, blocked.Kind, formatSafetyRatings(blocked.Ratings))
+
+	fallback := modelCfg.BlockFallback.Model(blocked.Kind)
+	if fallback == "" || fallback == modelCfg.ModelName {
+		return &ChatResponse{ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, fmt.Errorf("Gemini APIからのエラー: %w", blocked)
+	}
+
+	log.Printf("Retrying blocked response (%s) with fallback model %s for user %s in thread %s", blocked.Kind, fallback, userID, threadID)
+	// 再試行は1回のみとし、フォールバック先でもブロックされた場合はその理由を返す
+	fallbackCfg := *modelCfg
+	fallbackCfg.ModelName = fallback
+	fallbackCfg.BlockFallback = loader.BlockFallbackConfig{}
+	return c.invokeGemini(ctx, userID, threadID, message, fullInput, &fallbackCfg)
+}
+
+func (c *Chat) invokeSecondaryModel(ctx context.Context, secondaryModel *geminiProvider, fullInput string) (*genai.GenerateContentResponse, float64, error) {
+	log.Printf("Attempting retry with secondary model: %s", secondaryModel.modelName)
 
 	startSecondary := time.Now()
-	resp, err := secondaryModel.GenerateContent(ctx, genai.Text(fullInput))
+	genCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+	defer cancel()
+	resp, err := secondaryModel.generate(genCtx, genai.Text(fullInput))
 	elapsed := float64(time.Since(startSecondary).Milliseconds())
 
 	if err == nil {
-		log.Printf("Successfully generated content with secondary model: %s", modelCfg.SecondaryModelName)
+		log.Printf("Successfully generated content with secondary model: %s", secondaryModel.modelName)
 	} else {
-		errorLogger.Printf("Secondary Gemini API call failed for model %s: %v", modelCfg.SecondaryModelName, err)
+		errorLogger.Printf("Secondary Gemini API call failed for model %s: %v", secondaryModel.modelName, err)
 	}
 	return resp, elapsed, err
 }
 
-func (c *Chat) processGeminiResponse(ctx context.Context, userID, threadID, message string, modelCfg *loader.ModelConfig, resp *genai.GenerateContentResponse, start time.Time, elapsed float64) (string, float64, string, error) {
+// processGeminiResponse は resp を応答にします。model は関数呼び出しの結果を渡して再問い合わせする際に使います。
+func (c *Chat) processGeminiResponse(ctx context.Context, userID, threadID, message string, modelCfg *loader.ModelConfig, model *genai.GenerativeModel, resp *genai.GenerateContentResponse, start time.Time, elapsed float64) (*ChatResponse, error) {
 	if resp.Candidates == nil || len(resp.Candidates) == 0 {
 		errorLogger.Println("Gemini response candidates are empty.")
-		return "応答を取得できませんでした。", elapsed, modelCfg.ModelName, nil
+		return &ChatResponse{Text: "応答を取得できませんでした。", ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, nil
 	}
 
 	candidate := resp.Candidates[0]
 	if candidate.Content == nil || len(candidate.Content.Parts) == 0 {
 		errorLogger.Println("Gemini response candidate content or parts are empty.")
-		return "応答を取得できませんでした。", elapsed, modelCfg.ModelName, nil
+		return &ChatResponse{Text: "応答を取得できませんでした。", ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, nil
 	}
 
 	var functionCallProcessed bool
 	var llmIntroText strings.Builder
 	var toolResult string
+	var codeRenderer codeExecutionRenderer
 
 	for i, part := range candidate.Content.Parts {
 		switch v := part.(type) {
 		case genai.Text:
 			llmIntroText.WriteString(string(v))
+		case *genai.ExecutableCode:
+			codeRenderer.renderCode(&llmIntroText, v)
+		case *genai.CodeExecutionResult:
+			codeRenderer.renderResult(&llmIntroText, v)
 		case genai.FunctionCall:
 			functionCallProcessed = true
 			errorLogger.Printf("Unknown function call: %s", v.Name)
@@ -206,7 +396,7 @@ func (c *Chat) processGeminiResponse(ctx context.Context, userID, threadID, mess
 	}
 
 	if functionCallProcessed {
-		return c.handleFunctionCall(ctx, userID, threadID, message, modelCfg, candidate, toolResult, llmIntroText, start, elapsed)
+		return c.handleFunctionCall(ctx, userID, threadID, message, modelCfg, model, candidate, toolResult, llmIntroText, start, elapsed)
 	}
 
 	responseText := llmIntroText.String()
@@ -215,14 +405,14 @@ func (c *Chat) processGeminiResponse(ctx context.Context, userID, threadID, mess
 	}
 
 	if responseText != "" {
-		c.historyMgr.Add(userID, threadID, message, responseText)
+		c.historyMgr.AddMessages(userID, threadID, userHistoryMessage(ctx, message), history.HistoryMessage{Role: "model", Content: responseText})
 	} else {
 		errorLogger.Printf("Skipping history add for user %s in thread %s because responseText is empty.", userID, threadID)
 	}
-	return responseText, elapsed, modelCfg.ModelName, nil
+	return &ChatResponse{Text: responseText, ElapsedMs: elapsed, ModelName: modelCfg.ModelName, Files: codeRenderer.files}, nil
 }
 
-func (c *Chat) handleFunctionCall(ctx context.Context, userID, threadID, message string, modelCfg *loader.ModelConfig, candidate *genai.Candidate, toolResult string, llmIntroText strings.Builder, start time.Time, elapsed float64) (string, float64, string, error) {
+func (c *Chat) handleFunctionCall(ctx context.Context, userID, threadID, message string, modelCfg *loader.ModelConfig, model *genai.GenerativeModel, candidate *genai.Candidate, toolResult string, llmIntroText strings.Builder, start time.Time, elapsed float64) (*ChatResponse, error) {
 	var calledFuncName string
 	for _, part := range candidate.Content.Parts {
 		if fc, ok := part.(genai.FunctionCall); ok {
@@ -233,7 +423,7 @@ func (c *Chat) handleFunctionCall(ctx context.Context, userID, threadID, message
 
 	if calledFuncName == "" {
 		errorLogger.Printf("Could not determine called function name from candidate parts.")
-		return "関数呼び出し名の取得に失敗しました。", elapsed, modelCfg.ModelName, fmt.Errorf("関数呼び出し名の取得に失敗")
+		return &ChatResponse{Text: "関数呼び出し名の取得に失敗しました。", ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, fmt.Errorf("関数呼び出し名の取得に失敗")
 	}
 
 	var partsForNextTurn []genai.Part
@@ -270,12 +460,14 @@ func (c *Chat) handleFunctionCall(ctx context.Context, userID, threadID, message
 		},
 	})
 
-	secondResp, err := c.genaiModel.GenerateContent(ctx, partsForNextTurn...)
+	genCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+	defer cancel()
+	secondResp, err := model.GenerateContent(genCtx, partsForNextTurn...)
 	elapsed += float64(time.Since(start).Milliseconds())
 
 	if err != nil {
 		errorLogger.Printf("Error in second GenerateContent call after function execution: %v", err)
-		return fmt.Sprintf("ツールの実行結果: %s (LLMによる最終応答生成に失敗: %v)", toolResult, err), elapsed, modelCfg.ModelName, nil
+		return &ChatResponse{Text: fmt.Sprintf("ツールの実行結果: %s (LLMによる最終応答生成に失敗: %v)", toolResult, err), ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, nil
 	}
 
 	finalResponseText := getResponseText(secondResp)
@@ -287,15 +479,18 @@ func (c *Chat) handleFunctionCall(ctx context.Context, userID, threadID, message
 	}
 
 	if finalResponseText != "" {
-		c.historyMgr.Add(userID, threadID, message, finalResponseText)
+		c.historyMgr.AddMessages(userID, threadID, userHistoryMessage(ctx, message), history.HistoryMessage{Role: "model", Content: finalResponseText})
 	} else {
 		errorLogger.Printf("Skipping history add for user %s in thread %s because finalResponseText is empty after function call.", userID, threadID)
 	}
-	return finalResponseText, elapsed, modelCfg.ModelName, nil
+	return &ChatResponse{Text: finalResponseText, ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, nil
 }
 
 func (c *Chat) Close() {
 	c.genaiClient.Close()
+	if c.restClient != nil {
+		c.restClient.Close()
+	}
 }
 
 func GetErrorLogger() *log.Logger {
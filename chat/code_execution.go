@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// maxInlineCodeOutput は本文に表示するコード実行結果の最大文字数です。超えた分はファイルとして添付します。
+const maxInlineCodeOutput = 800
+
+// codeExecutionRenderer は Gemini のコード実行ツールが返した ExecutableCode / CodeExecutionResult を、
+// 本文中のコードブロックと添付ファイルに変換します。
+type codeExecutionRenderer struct {
+	files []ResponseFile
+}
+
+// writeBlock は block を独立した段落として text に追記します。
+func writeBlock(text *strings.Builder, block string) {
+	if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+		text.WriteString("\n")
+	}
+	text.WriteString(block)
+	text.WriteString("\n")
+}
+
+// renderCode は実行されたコードをシンタックスハイライト付きのコードブロックとして追記します。
+func (r *codeExecutionRenderer) renderCode(text *strings.Builder, code *genai.ExecutableCode) {
+	language := ""
+	if code.Language == genai.ExecutableCodePython {
+		language = "python"
+	}
+	writeBlock(text, fmt.Sprintf("```%s\n%s\n```", language, escapeCodeFence(strings.TrimRight(code.Code, "\n"))))
+}
+
+// renderResult は実行結果を出力ブロックとして追記します。長い出力は切り詰め、全文を添付ファイルにします。
+func (r *codeExecutionRenderer) renderResult(text *strings.Builder, result *genai.CodeExecutionResult) {
+	heading := "**実行結果**"
+	switch result.Outcome {
+	case genai.CodeExecutionResultOutcomeFailed:
+		heading = "**実行エラー**"
+	case genai.CodeExecutionResultOutcomeDeadlineExceeded:
+		heading = "**実行がタイムアウトしました**"
+	}
+
+	output := strings.TrimRight(result.Output, "\n")
+	if output == "" {
+		writeBlock(text, heading+" (出力なし)")
+		return
+	}
+	if runes := []rune(output); len(runes) > maxInlineCodeOutput {
+		name := fmt.Sprintf("output_%d.txt", len(r.files)+1)
+		r.files = append(r.files, ResponseFile{Name: name, Content: result.Output})
+		heading += fmt.Sprintf(" (全文は %s を参照)", name)
+		output = string(runes[:maxInlineCodeOutput]) + "\n..."
+	}
+	writeBlock(text, fmt.Sprintf("%s\n```\n%s\n```", heading, escapeCodeFence(output)))
+}
+
+// escapeCodeFence はコード中の ``` でコードブロックが閉じないよう、ゼロ幅スペースを挟みます。
+func escapeCodeFence(code string) string {
+	return strings.ReplaceAll(code, "```", "`\u200b``")
+}
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func TestGeminiCodeExecution(t *testing.T) {
+	longOutput := strings.Repeat("1\n", maxInlineCodeOutput)
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		parts, _ := json.Marshal([]map[string]interface{}{
+			{"text": "計算します。"},
+			{"executableCode": map[string]interface{}{"language": 1, "code": "print(1 + 1)\n"}},
+			{"codeExecutionResult": map[string]interface{}{"outcome": 1, "output": "2\n"}},
+			{"executableCode": map[string]interface{}{"language": 1, "code": "for _ in range(800): print(1)"}},
+			{"codeExecutionResult": map[string]interface{}{"outcome": 1, "output": longOutput}},
+			{"text": "以上です。"},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":`+string(parts)+`},"finishReason":1}]}`)
+	}))
+	defer server.Close()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("genai.NewClient failed: %v", err)
+	}
+	defer client.Close()
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	errorLogger = log.New(io.Discard, "", 0)
+	c := &Chat{
+		genaiClient: client,
+		historyMgr:  historyMgr,
+		modelConfig: &loader.ModelConfig{
+			ModelName:   "gemini-fake",
+			GeminiTools: loader.GeminiToolsConfig{CodeExecutionChannelIDs: []string{"lab"}},
+		},
+	}
+
+	resp, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "lab", Message: "1+1は？"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tools, _ := request["tools"].([]interface{})
+	if len(tools) != 1 || tools[0].(map[string]interface{})["codeExecution"] == nil {
+		t.Errorf("expected code_execution tool in request, got %v", request["tools"])
+	}
+
+	wantPrefix := "計算します。\n```python\nprint(1 + 1)\n```\n**実行結果**\n```\n2\n```\n```python\n"
+	if !strings.HasPrefix(resp.Text, wantPrefix) {
+		t.Errorf("unexpected text:\n%s", resp.Text)
+	}
+	if !strings.Contains(resp.Text, "**実行結果** (全文は output_1.txt を参照)") || !strings.HasSuffix(resp.Text, "以上です。") {
+		t.Errorf("long output should be truncated with a file reference:\n%s", resp.Text)
+	}
+	if len(resp.Files) != 1 || resp.Files[0].Name != "output_1.txt" || resp.Files[0].Content != longOutput {
+		t.Errorf("unexpected files: %+v", resp.Files)
+	}
+
+	// 設定したチャンネルの中のスレッドでも使い、他のチャンネルでは使わない
+	for _, tc := range []struct {
+		params ChatParams
+		want   bool
+	}{
+		{ChatParams{UserID: "u1", ThreadID: "thread1", ParentChannelID: "lab", Message: "1+1は？"}, true},
+		{ChatParams{UserID: "u1", ThreadID: "other", Message: "1+1は？"}, false},
+	} {
+		request = nil
+		if _, err := c.GetResponse(context.Background(), tc.params); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tools, _ := request["tools"].([]interface{})
+		if got := len(tools) == 1 && tools[0].(map[string]interface{})["codeExecution"] != nil; got != tc.want {
+			t.Errorf("code execution for %+v: got %v, want %v (tools %v)", tc.params, got, tc.want, request["tools"])
+		}
+	}
+}
+
+func TestCodeExecutionRendererEscapesFences(t *testing.T) {
+	var text strings.Builder
+	var r codeExecutionRenderer
+	r.renderCode(&text, &genai.ExecutableCode{Language: genai.ExecutableCodePython, Code: "print('```')"})
+	r.renderResult(&text, &genai.CodeExecutionResult{Outcome: genai.CodeExecutionResultOutcomeFailed})
+
+	if strings.Count(text.String(), "```") != 2 {
+		t.Errorf("code fence inside code should be escaped:\n%s", text.String())
+	}
+	if !strings.Contains(text.String(), "**実行エラー** (出力なし)") {
+		t.Errorf("unexpected result rendering:\n%s", text.String())
+	}
+}
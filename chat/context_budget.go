@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"log"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// maxBudgetPasses は実測したトークン数で履歴の削り方を補正する最大回数です。
+const maxBudgetPasses = 3
+
+// tokenCounter はテキストのトークン数を数えます。
+type tokenCounter func(ctx context.Context, text string) (int, error)
+
+// countEstimatedTokens は estimateTokens による見積もりを tokenCounter として使います。
+func countEstimatedTokens(_ context.Context, text string) (int, error) {
+	return estimateTokens(text), nil
+}
+
+// tokenCounter は provider の modelName でトークン数を数える関数を返します。
+// Gemini は CountTokens API で実測し、それ以外のプロバイダはローカルの見積もりを使います。
+func (c *Chat) tokenCounter(provider, modelName string) tokenCounter {
+	if provider != loader.ProviderGemini || c.genaiClient == nil {
+		return countEstimatedTokens
+	}
+	model := c.genaiClient.GenerativeModel(modelName)
+	return func(ctx context.Context, text string) (int, error) {
+		countCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+		defer cancel()
+		resp, err := model.CountTokens(countCtx, genai.Text(text))
+		if err != nil {
+			return 0, err
+		}
+		return int(resp.TotalTokens), nil
+	}
+}
+
+// fitPromptToBudget は prompt が budget トークンに収まるよう、古い会話履歴から1ターンずつ削ります。
+// システムプロンプトと今回のメッセージは上限を超えていても削りません。
+func fitPromptToBudget(ctx context.Context, prompt Prompt, budget int, count tokenCounter) Prompt {
+	if budget <= 0 || len(prompt.History) == 0 {
+		return prompt
+	}
+	// 見積もりは日本語を多めに数えるため、上限の半分にも届かない場合は API で数えずにそのまま使う
+	if estimateTokens(prompt.FullInput()) < budget/2 {
+		return prompt
+	}
+
+	original := len(prompt.History)
+	for pass := 0; pass < maxBudgetPasses; pass++ {
+		fullInput := prompt.FullInput()
+		total, err := count(ctx, fullInput)
+		if err != nil {
+			log.Printf("トークン数の取得に失敗したため、見積もりを使用します: %v", err)
+			count = countEstimatedTokens
+			total = estimateTokens(fullInput)
+		}
+		if total <= budget || len(prompt.History) == 0 {
+			break
+		}
+		// 履歴を1ターンずつ数え直すと API の呼び出しが増えるため、実測値と見積もりの比で見積もりを補正して削る位置を決める
+		ratio := float64(total) / float64(max(estimateTokens(fullInput), 1))
+		prompt.History = trimOldestTurns(prompt, func(tokens int) bool { return float64(tokens)*ratio <= float64(budget) })
+	}
+	if dropped := original - len(prompt.History); dropped > 0 {
+		log.Printf("プロンプトを %d トークンに収めるため、古い履歴を %d 件省きました (残り %d 件)", budget, dropped, len(prompt.History))
+	}
+	return prompt
+}
+
+// trimOldestTurns は見積もったトークン数が fits を満たすまで、prompt.History の古いターンを削った履歴を返します。
+// 履歴がモデルの応答から始まらないよう、ユーザーの発言の位置で区切ります。
+func trimOldestTurns(prompt Prompt, fits func(tokens int) bool) []history.HistoryMessage {
+	base := prompt
+	base.History = nil
+	tokens := estimateTokens(base.FullInput()) + estimateTokens("Chat history:\n\n")
+	lineTokens := make([]int, len(prompt.History))
+	for i, msg := range prompt.History {
+		lineTokens[i] = estimateTokens(prompt.historyLine(msg) + "\n")
+		tokens += lineTokens[i]
+	}
+
+	start := 0
+	for start < len(prompt.History) && !fits(tokens) {
+		tokens -= lineTokens[start]
+		start++
+		for start < len(prompt.History) && prompt.History[start].Role != "user" {
+			tokens -= lineTokens[start]
+			start++
+		}
+	}
+	return prompt.History[start:]
+}
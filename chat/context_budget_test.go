@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func budgetTestPrompt(turns int) Prompt {
+	p := Prompt{System: "system prompt", Message: "current message"}
+	for i := 0; i < turns; i++ {
+		p.History = append(p.History,
+			history.HistoryMessage{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("x", 400))},
+			history.HistoryMessage{Role: "model", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("y", 400))},
+		)
+	}
+	return p
+}
+
+func TestFitPromptToBudget(t *testing.T) {
+	t.Run("Prompt within budget is unchanged", func(t *testing.T) {
+		p := budgetTestPrompt(3)
+		got := fitPromptToBudget(context.Background(), p, 10000, countEstimatedTokens)
+		if len(got.History) != 6 {
+			t.Errorf("expected full history, got %d messages", len(got.History))
+		}
+	})
+
+	t.Run("Oldest turns are dropped first", func(t *testing.T) {
+		p := budgetTestPrompt(10)
+		got := fitPromptToBudget(context.Background(), p, 1000, countEstimatedTokens)
+		if estimateTokens(got.FullInput()) > 1000 {
+			t.Errorf("prompt exceeds budget: %d tokens", estimateTokens(got.FullInput()))
+		}
+		if len(got.History) == 0 || len(got.History) == 20 {
+			t.Fatalf("expected some history to be dropped, got %d messages", len(got.History))
+		}
+		if got.History[0].Role != "user" || got.History[len(got.History)-1].Content != p.History[19].Content {
+			t.Errorf("expected the newest turns to be kept, got %+v", got.History[0])
+		}
+		if got.System != p.System || got.Message != p.Message {
+			t.Errorf("system prompt and message must be kept")
+		}
+	})
+
+	t.Run("Measured counts calibrate the estimate", func(t *testing.T) {
+		p := budgetTestPrompt(10)
+		double := func(ctx context.Context, text string) (int, error) { return estimateTokens(text) * 2, nil }
+		got := fitPromptToBudget(context.Background(), p, 1000, double)
+		if n, _ := double(context.Background(), got.FullInput()); n > 1000 {
+			t.Errorf("prompt exceeds budget: %d tokens", n)
+		}
+		estimated := fitPromptToBudget(context.Background(), p, 1000, countEstimatedTokens)
+		if len(got.History) >= len(estimated.History) {
+			t.Errorf("expected fewer turns when the model counts more tokens, got %d vs %d", len(got.History), len(estimated.History))
+		}
+	})
+
+	t.Run("Counter errors fall back to the estimate", func(t *testing.T) {
+		p := budgetTestPrompt(10)
+		failing := func(ctx context.Context, text string) (int, error) { return 0, errors.New("quota exceeded") }
+		got := fitPromptToBudget(context.Background(), p, 1000, failing)
+		if estimateTokens(got.FullInput()) > 1000 || len(got.History) == 0 {
+			t.Errorf("unexpected history length %d", len(got.History))
+		}
+	})
+
+	t.Run("Oversized message keeps system prompt and message", func(t *testing.T) {
+		p := budgetTestPrompt(2)
+		p.Message = strings.Repeat("長", 500)
+		got := fitPromptToBudget(context.Background(), p, 100, countEstimatedTokens)
+		if len(got.History) != 0 || got.Message != p.Message || got.System != p.System {
+			t.Errorf("expected only system prompt and message, got %d history messages", len(got.History))
+		}
+	})
+}
+
+func TestGeminiTokenCounter(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"totalTokens": 4321}`)
+	}))
+	defer server.Close()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("genai.NewClient failed: %v", err)
+	}
+	defer client.Close()
+	c := &Chat{genaiClient: client, modelConfig: &loader.ModelConfig{ModelName: "gemini-fake"}}
+
+	n, err := c.tokenCounter(loader.ProviderGemini, "gemini-fake")(context.Background(), "こんにちは")
+	if err != nil || n != 4321 {
+		t.Fatalf("unexpected count %d, err %v", n, err)
+	}
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], "gemini-fake:countTokens") {
+		t.Errorf("unexpected requests: %v", paths)
+	}
+	if n, _ := c.tokenCounter(loader.ProviderOllama, "llama3")(context.Background(), "abcd"); n != 1 || len(paths) != 1 {
+		t.Errorf("non-Gemini providers should use the local estimate, got %d", n)
+	}
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+)
+
+// geminiProvider は Gemini の GenerateContent を呼び出す ChatProvider です。
+type geminiProvider struct {
+	model     *genai.GenerativeModel
+	modelName string
+}
+
+func newGeminiProvider(client *genai.Client, modelName string) *geminiProvider {
+	return &geminiProvider{model: client.GenerativeModel(modelName), modelName: modelName}
+}
+
+func (p *geminiProvider) Name() string { return "Gemini" }
+
+func (p *geminiProvider) Invoke(ctx context.Context, fullInput string) (*ChatResponse, error) {
+	start := time.Now()
+	resp, err := p.generate(ctx, genai.Text(fullInput))
+	chatResp := &ChatResponse{ElapsedMs: float64(time.Since(start).Milliseconds()), ModelName: p.modelName}
+	if err != nil {
+		return chatResp, err
+	}
+	chatResp.Text = getResponseText(resp)
+	return chatResp, nil
+}
+
+// generate は GenerateContent を呼び出し、APIエラーを ProviderError に変換します。
+func (p *geminiProvider) generate(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := p.model.GenerateContent(ctx, parts...)
+	if err != nil {
+		return nil, wrapGeminiError(err)
+	}
+	return resp, nil
+}
+
+// wrapGeminiError は *googleapi.Error を ProviderError に変換します。それ以外のエラーはそのまま返します。
+func wrapGeminiError(err error) error {
+	var gapiErr *googleapi.Error
+	if !errors.As(err, &gapiErr) {
+		return err
+	}
+	message := gapiErr.Message
+	if message == "" {
+		message = gapiErr.Body
+	}
+	providerErr := &ProviderError{
+		Provider:   "Gemini",
+		StatusCode: gapiErr.Code,
+		Message:    message,
+		Err:        err,
+	}
+	if gapiErr.Header != nil {
+		providerErr.RetryAfter = parseRetryAfter(gapiErr.Header.Get("Retry-After"), time.Now())
+	}
+	return providerErr
+}
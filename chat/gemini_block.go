@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ContentBlockedError は Gemini が安全性フィルタなどにより応答をブロックしたことを表します。
+// Kind は loader.BlockKindSafety / BlockKindRecitation / BlockKindPrompt のいずれかです。
+type ContentBlockedError struct {
+	Kind       string
+	Model      string
+	Categories []genai.HarmCategory // ブロックの原因となったカテゴリ
+	Ratings    []*genai.SafetyRating
+	Err        *genai.BlockedError
+}
+
+func (e *ContentBlockedError) Error() string {
+	return fmt.Sprintf("Gemini (%s) の応答がブロックされました (%s): %v", e.Model, e.Kind, e.Err)
+}
+
+func (e *ContentBlockedError) Unwrap() error { return e.Err }
+
+// UserMessage はブロックされた理由をユーザー向けに説明する文を返します。
+func (e *ContentBlockedError) UserMessage() string {
+	var msg string
+	switch e.Kind {
+	case loader.BlockKindRecitation:
+		return "既存のコンテンツをそのまま引用している可能性があるため、応答が停止されました。表現を変えて質問してみてください。"
+	case loader.BlockKindPrompt:
+		msg = "入力内容が安全性フィルタによりブロックされました"
+		if e.Err.PromptFeedback != nil && e.Err.PromptFeedback.BlockReason == genai.BlockReasonOther {
+			msg = "入力内容が安全性以外の理由でブロックされました"
+		}
+	default:
+		msg = "応答が安全性フィルタによりブロックされました"
+	}
+	if len(e.Categories) > 0 {
+		names := make([]string, 0, len(e.Categories))
+		for _, category := range e.Categories {
+			names = append(names, harmCategoryName(category))
+		}
+		msg += fmt.Sprintf(" (カテゴリ: %s)", strings.Join(names, ", "))
+	}
+	return msg + "。"
+}
+
+// newContentBlockedError は genai.BlockedError からブロックの種類と原因となったカテゴリを取り出します。
+func newContentBlockedError(err *genai.BlockedError, model string) *ContentBlockedError {
+	blocked := &ContentBlockedError{Model: model, Err: err}
+	switch {
+	case err.PromptFeedback != nil:
+		blocked.Kind = loader.BlockKindPrompt
+		blocked.Ratings = err.PromptFeedback.SafetyRatings
+	case err.Candidate != nil && err.Candidate.FinishReason == genai.FinishReasonRecitation:
+		blocked.Kind = loader.BlockKindRecitation
+		blocked.Ratings = err.Candidate.SafetyRatings
+	default:
+		blocked.Kind = loader.BlockKindSafety
+		if err.Candidate != nil {
+			blocked.Ratings = err.Candidate.SafetyRatings
+		}
+	}
+	blocked.Categories = blockedCategories(blocked.Ratings)
+	return blocked
+}
+
+// blockedCategories は Blocked が立っているカテゴリを返します。
+// Blocked が1つも無い場合は、確率が中程度以上のカテゴリを原因とみなします。
+func blockedCategories(ratings []*genai.SafetyRating) []genai.HarmCategory {
+	var blocked, likely []genai.HarmCategory
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		if rating.Blocked {
+			blocked = append(blocked, rating.Category)
+		} else if rating.Probability >= genai.HarmProbabilityMedium {
+			likely = append(likely, rating.Category)
+		}
+	}
+	if len(blocked) > 0 {
+		return blocked
+	}
+	return likely
+}
+
+// formatSafetyRatings はログ出力用に安全性評価を "カテゴリ=確率" の形で並べます。
+func formatSafetyRatings(ratings []*genai.SafetyRating) string {
+	if len(ratings) == 0 {
+		return "(なし)"
+	}
+	parts := make([]string, 0, len(ratings))
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		part := fmt.Sprintf("%s=%s", rating.Category, rating.Probability)
+		if rating.Blocked {
+			part += "(blocked)"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func harmCategoryName(category genai.HarmCategory) string {
+	switch category {
+	case genai.HarmCategoryHarassment:
+		return "ハラスメント"
+	case genai.HarmCategoryHateSpeech:
+		return "ヘイトスピーチ"
+	case genai.HarmCategorySexuallyExplicit:
+		return "性的なコンテンツ"
+	case genai.HarmCategoryDangerousContent:
+		return "危険なコンテンツ"
+	case genai.HarmCategoryDerogatory:
+		return "差別的な表現"
+	case genai.HarmCategoryToxicity:
+		return "有害な表現"
+	case genai.HarmCategoryViolence:
+		return "暴力"
+	case genai.HarmCategorySexual:
+		return "性的な表現"
+	case genai.HarmCategoryMedical:
+		return "医療"
+	case genai.HarmCategoryDangerous:
+		return "危険な行為"
+	}
+	return category.String()
+}
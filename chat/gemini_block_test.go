@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+const (
+	geminiSafetyBlockedBody     = `{"candidates":[{"finishReason":3,"safetyRatings":[{"category":7,"probability":4,"blocked":true},{"category":10,"probability":1}]}]}`
+	geminiRecitationBlockedBody = `{"candidates":[{"content":{"role":"model","parts":[{"text":"引用"}]},"finishReason":4}]}`
+	geminiPromptBlockedBody     = `{"promptFeedback":{"blockReason":1,"safetyRatings":[{"category":10,"probability":3}]}}`
+	geminiOKBody                = `{"candidates":[{"content":{"role":"model","parts":[{"text":"代わりの応答"}]},"finishReason":1}]}`
+)
+
+// newBlockingGeminiChat は models に応じた応答を返すフェイクの Gemini エンドポイントに接続した Chat を作成します。
+// models のキーはモデル名、値は generateContent の応答本文です。
+func newBlockingGeminiChat(t *testing.T, modelCfg *loader.ModelConfig, models map[string]string) (*Chat, *[]string) {
+	t.Helper()
+	var called []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		for model, body := range models {
+			if strings.Contains(r.URL.Path, "/models/"+model+":") {
+				called = append(called, model)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, body)
+				return
+			}
+		}
+		t.Errorf("unexpected request: %s", r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("genai.NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	errorLogger = log.New(io.Discard, "", 0)
+	return &Chat{genaiClient: client, historyMgr: historyMgr, modelConfig: modelCfg}, &called
+}
+
+func TestGeminiBlockedResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantKind    string
+		wantMessage string
+	}{
+		{"safety", geminiSafetyBlockedBody, loader.BlockKindSafety, "応答が安全性フィルタによりブロックされました (カテゴリ: ハラスメント)。"},
+		{"recitation", geminiRecitationBlockedBody, loader.BlockKindRecitation, "既存のコンテンツをそのまま引用している"},
+		{"prompt", geminiPromptBlockedBody, loader.BlockKindPrompt, "入力内容が安全性フィルタによりブロックされました (カテゴリ: 危険なコンテンツ)。"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newBlockingGeminiChat(t, &loader.ModelConfig{ModelName: "primary"}, map[string]string{"primary": tt.body})
+
+			_, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+			var blocked *ContentBlockedError
+			if !errors.As(err, &blocked) {
+				t.Fatalf("expected ContentBlockedError, got %v", err)
+			}
+			if blocked.Kind != tt.wantKind || blocked.Model != "primary" {
+				t.Errorf("unexpected blocked error: %+v", blocked)
+			}
+			if msg := blocked.UserMessage(); !strings.HasPrefix(msg, tt.wantMessage) {
+				t.Errorf("unexpected user message %q", msg)
+			}
+		})
+	}
+}
+
+func TestGeminiBlockFallback(t *testing.T) {
+	t.Run("retries on the fallback configured for the finish reason", func(t *testing.T) {
+		modelCfg := &loader.ModelConfig{
+			ModelName:     "primary",
+			BlockFallback: loader.BlockFallbackConfig{Safety: "fallback", Recitation: "other"},
+		}
+		c, called := newBlockingGeminiChat(t, modelCfg, map[string]string{"primary": geminiSafetyBlockedBody, "fallback": geminiOKBody})
+
+		resp, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Text != "代わりの応答" || resp.ModelName != "fallback" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		if strings.Join(*called, ",") != "primary,fallback" {
+			t.Errorf("unexpected calls: %v", *called)
+		}
+	})
+
+	t.Run("retries only once", func(t *testing.T) {
+		modelCfg := &loader.ModelConfig{
+			ModelName:     "primary",
+			BlockFallback: loader.BlockFallbackConfig{Safety: "fallback"},
+		}
+		c, called := newBlockingGeminiChat(t, modelCfg, map[string]string{"primary": geminiSafetyBlockedBody, "fallback": geminiSafetyBlockedBody})
+
+		_, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+		var blocked *ContentBlockedError
+		if !errors.As(err, &blocked) || blocked.Model != "fallback" {
+			t.Fatalf("expected block from fallback model, got %v", err)
+		}
+		if len(*called) != 2 {
+			t.Errorf("expected 2 calls, got %v", *called)
+		}
+	})
+}
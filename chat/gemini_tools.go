@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// invokeGeminiWithTools は tools を Gemini の関数呼び出しとして宣言して問い合わせます。
+// Gemini が関数を呼び出した場合は実行結果を会話に加え、最大 maxToolRounds 回まで再問い合わせします。
+// genai の ChatSession はストリーミング API を使うため、往復は generativelanguage のクライアントで組み立てます。
+// ツールの実行結果は prompt の境界文字列を付けたタグで囲みます。
+func (c *Chat) invokeGeminiWithTools(ctx context.Context, userID, threadID, message string, prompt Prompt, modelCfg *loader.ModelConfig, tools []Tool) (*ChatResponse, error) {
+	fullInput := prompt.FullInput()
+	log.Printf("Using Gemini (%s) with %d tools for user %s in thread %s", modelCfg.ModelName, len(tools), userID, threadID)
+	req := &pb.GenerateContentRequest{
+		Model:    "models/" + modelCfg.ModelName,
+		Contents: []*pb.Content{{Role: "user", Parts: []*pb.Part{{Data: &pb.Part_Text{Text: fullInput}}}}},
+		Tools:    []*pb.Tool{{FunctionDeclarations: protoFunctionDeclarations(tools)}},
+	}
+	if modelCfg.GeminiTools.CodeExecutionEnabled(threadID, parentChannelFrom(ctx)) {
+		req.Tools = append(req.Tools, &pb.Tool{CodeExecution: &pb.CodeExecution{}})
+	}
+
+	start := time.Now()
+	for round := 0; ; round++ {
+		genCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+		resp, err := c.restClient.GenerateContent(genCtx, req)
+		cancel()
+		elapsed := float64(time.Since(start).Milliseconds())
+		if err != nil {
+			return c.handleGeminiError(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, wrapGeminiError(err))
+		}
+		if blocked := blockedErrorFromProto(resp); blocked != nil {
+			return c.handleGeminiBlocked(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, newContentBlockedError(blocked, modelCfg.ModelName))
+		}
+		if len(resp.GetCandidates()) == 0 {
+			errorLogger.Println("Gemini response candidates are empty.")
+			return &ChatResponse{Text: "応答を取得できませんでした。", ElapsedMs: elapsed, ModelName: modelCfg.ModelName}, nil
+		}
+
+		content := resp.GetCandidates()[0].GetContent()
+		var calls []*pb.FunctionCall
+		for _, part := range content.GetParts() {
+			if call := part.GetFunctionCall(); call != nil {
+				calls = append(calls, call)
+			}
+		}
+		if len(calls) == 0 || round+1 >= maxToolRounds {
+			if len(calls) > 0 {
+				log.Printf("Gemini: ツール呼び出しが %d 回に達したため打ち切ります", maxToolRounds)
+			}
+			text, files := renderProtoParts(content.GetParts())
+			chatResp := &ChatResponse{Text: text, ElapsedMs: elapsed, ModelName: modelCfg.ModelName, Files: files}
+			return c.completeResponse(ctx, "Gemini", userID, threadID, message, chatResp, nil)
+		}
+
+		// モデルの関数呼び出しと実行結果を会話に追加して、次の問い合わせで続きを生成させる
+		content.Role = "model"
+		results := &pb.Content{Role: "user"}
+		for _, call := range calls {
+			log.Printf("Gemini: ツール %s を実行します (user %s, thread %s)", call.GetName(), userID, threadID)
+			result, _ := runTool(ctx, tools, call.GetName(), call.GetArgs().AsMap())
+			results.Parts = append(results.Parts, &pb.Part{Data: &pb.Part_FunctionResponse{FunctionResponse: &pb.FunctionResponse{
+				Name:     call.GetName(),
+				Response: &structpb.Struct{Fields: map[string]*structpb.Value{"content": structpb.NewStringValue(prompt.toolResult(call.GetName(), result))}},
+			}}})
+		}
+		req.Contents = append(req.Contents, content, results)
+	}
+}
+
+// renderProtoParts はテキストとコード実行のパートを、processGeminiResponse と同じ形の本文と添付ファイルにまとめます。
+func renderProtoParts(parts []*pb.Part) (string, []ResponseFile) {
+	var text strings.Builder
+	var codeRenderer codeExecutionRenderer
+	for _, part := range parts {
+		switch data := part.GetData().(type) {
+		case *pb.Part_Text:
+			text.WriteString(data.Text)
+		case *pb.Part_ExecutableCode:
+			codeRenderer.renderCode(&text, &genai.ExecutableCode{
+				Language: genai.ExecutableCodeLanguage(data.ExecutableCode.GetLanguage()),
+				Code:     data.ExecutableCode.GetCode(),
+			})
+		case *pb.Part_CodeExecutionResult:
+			codeRenderer.renderResult(&text, &genai.CodeExecutionResult{
+				Outcome: genai.CodeExecutionResultOutcome(data.CodeExecutionResult.GetOutcome()),
+				Output:  data.CodeExecutionResult.GetOutput(),
+			})
+		}
+	}
+	return text.String(), codeRenderer.files
+}
+
+// protoFunctionDeclarations は tools を generativelanguage の FunctionDeclaration に変換します。
+func protoFunctionDeclarations(tools []Tool) []*pb.FunctionDeclaration {
+	declarations := make([]*pb.FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		declarations = append(declarations, &pb.FunctionDeclaration{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  schemaToProto(schemaToGenai(tool.Parameters)),
+		})
+	}
+	return declarations
+}
+
+// schemaToProto は *genai.Schema を generativelanguage の *pb.Schema に変換します。Type の値は両者で共通です。
+func schemaToProto(s *genai.Schema) *pb.Schema {
+	if s == nil {
+		return nil
+	}
+	converted := &pb.Schema{
+		Type:        pb.Type(s.Type),
+		Format:      s.Format,
+		Description: s.Description,
+		Nullable:    s.Nullable,
+		Enum:        s.Enum,
+		Items:       schemaToProto(s.Items),
+		Required:    s.Required,
+	}
+	if len(s.Properties) > 0 {
+		converted.Properties = make(map[string]*pb.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			converted.Properties[name] = schemaToProto(prop)
+		}
+	}
+	return converted
+}
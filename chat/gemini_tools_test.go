@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	generativelanguage "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"google.golang.org/api/option"
+)
+
+func TestGeminiFunctionCalling(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_channel_messages","args":{"limit":3}}}]},"finishReason":1}]}`)
+			return
+		}
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"今日は雑談がありました。"}]},"finishReason":1}]}`)
+	}))
+	defer server.Close()
+
+	client, err := generativelanguage.NewGenerativeRESTClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("NewGenerativeRESTClient failed: %v", err)
+	}
+	defer client.Close()
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	errorLogger = log.New(io.Discard, "", 0)
+	c := &Chat{restClient: client, historyMgr: historyMgr, modelConfig: &loader.ModelConfig{ModelName: "gemini-fake"}}
+
+	var gotArgs map[string]interface{}
+	tools := []Tool{{
+		Name:        "get_channel_messages",
+		Description: "チャンネルの最近のメッセージを取得します",
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"limit": map[string]interface{}{"type": "integer"}},
+		},
+		Execute: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			gotArgs = args
+			return "alice: こんにちは", nil
+		},
+	}}
+
+	resp, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "今日の要約", Tools: tools})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "今日は雑談がありました。" {
+		t.Errorf("unexpected text %q", resp.Text)
+	}
+	if gotArgs["limit"] != float64(3) {
+		t.Errorf("unexpected tool args: %v", gotArgs)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	declarations := requests[0]["tools"].([]interface{})[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	if declarations[0].(map[string]interface{})["name"] != "get_channel_messages" {
+		t.Errorf("unexpected declarations: %v", declarations)
+	}
+	// 2回目のリクエストには、モデルの関数呼び出しとツールの実行結果が会話として含まれる
+	contents := requests[1]["contents"].([]interface{})
+	if len(contents) != 3 || contents[1].(map[string]interface{})["role"] != "model" {
+		t.Fatalf("unexpected contents: %v", contents)
+	}
+	// ツールの実行結果は第三者の書いた内容を含むため、タグで囲んで渡す
+	response := contents[2].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	content, _ := response["response"].(map[string]interface{})["content"].(string)
+	if response["name"] != "get_channel_messages" || stripBoundary(content) != `<tool_result name="get_channel_messages">alice: こんにちは</tool_result>` {
+		t.Errorf("unexpected function response: %v", response)
+	}
+}
@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// IsStopped は err が呼び出し元によるキャンセル、または期限切れによるものかを返します。
+func IsStopped(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// providerContext は provider ("gemini" など) に設定された期限を ctx に適用します。
+func (c *Chat) providerContext(ctx context.Context, provider string) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(loader.DefaultProviderTimeoutSeconds) * time.Second
+	if c.modelConfig != nil {
+		timeout = c.modelConfig.Timeouts.Provider(strings.ToLower(provider))
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// completeResponse はプロバイダの結果を履歴に保存します。
+// キャンセルや期限切れで止まった場合でも、それまでに生成されたテキストがあれば
+// 未完了 (Incomplete) の応答として保存し、エラーにはしません。
+func (c *Chat) completeResponse(ctx context.Context, providerName, userID, threadID, message string, resp *ChatResponse, err error) (*ChatResponse, error) {
+	if err != nil {
+		if !IsStopped(err) || resp == nil || resp.Text == "" {
+			return resp, err
+		}
+		log.Printf("%s の生成が途中で停止しました (user %s, thread %s): %v", providerName, userID, threadID, err)
+		resp.Incomplete, resp.StopErr = true, err
+	}
+
+	if resp.Text == "" {
+		log.Printf("Skipping history add for user %s in thread %s because %s responseText is empty.", userID, threadID, providerName)
+		return resp, nil
+	}
+	if addErr := c.historyMgr.AddMessages(userID, threadID,
+		userHistoryMessage(ctx, message),
+		history.HistoryMessage{Role: "model", Content: resp.Text, Incomplete: resp.Incomplete, Grounding: resp.Grounding},
+	); addErr != nil {
+		log.Printf("履歴の保存に失敗しました (user %s, thread %s): %v", userID, threadID, addErr)
+	}
+	return resp, nil
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// newStallingOllamaServer は最初のチャンクを送った後、クライアントが切断するまで応答を止めるフェイクサーバーです。
+func newStallingOllamaServer(t *testing.T, firstChunkSent chan<- struct{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprintln(w, `{"response":"途中まで","done":false}`)
+		w.(http.Flusher).Flush()
+		close(firstChunkSent)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetResponseCancellation(t *testing.T) {
+	t.Run("cancel keeps partial text and marks history incomplete", func(t *testing.T) {
+		firstChunkSent := make(chan struct{})
+		server := newStallingOllamaServer(t, firstChunkSent)
+		historyMgr, _ := history.NewInMemoryHistoryManager(10)
+		c := &Chat{
+			historyMgr:  historyMgr,
+			modelConfig: &loader.ModelConfig{Ollama: loader.OllamaConfig{Enabled: true, APIEndpoint: server.URL, ModelName: "fake"}},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-firstChunkSent
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		resp, err := c.GetResponse(ctx, ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+		if err != nil {
+			t.Fatalf("expected partial response without error, got %v", err)
+		}
+		if !resp.Incomplete || resp.Text != "途中まで" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		msgs, _ := historyMgr.Get("u1", "t1")
+		if len(msgs) != 2 || !msgs[1].Incomplete || msgs[1].Content != "途中まで" {
+			t.Errorf("unexpected history: %+v", msgs)
+		}
+	})
+
+	t.Run("provider deadline without output is an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}))
+		t.Cleanup(server.Close)
+		historyMgr, _ := history.NewInMemoryHistoryManager(10)
+		c := &Chat{
+			historyMgr: historyMgr,
+			modelConfig: &loader.ModelConfig{
+				Ollama:   loader.OllamaConfig{Enabled: true, APIEndpoint: server.URL, ModelName: "fake"},
+				Timeouts: loader.TimeoutConfig{ProviderSeconds: map[string]int{loader.ProviderOllama: 1}},
+			},
+		}
+		errorLogger = log.New(io.Discard, "", 0)
+
+		start := time.Now()
+		_, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+		if !errors.Is(err, context.DeadlineExceeded) || !IsStopped(err) {
+			t.Fatalf("expected deadline error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("provider deadline was not applied, took %v", elapsed)
+		}
+		if msgs, _ := historyMgr.Get("u1", "t1"); len(msgs) != 0 {
+			t.Errorf("nothing should be stored without output, got %+v", msgs)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+)
+
+type parentChannelContextKey struct{}
+
+// withParentChannel は、スレッドの親チャンネルのIDを ctx に付けます。
+// gemini_tools はスレッドの親チャンネルでも有効にできるため、プロバイダの呼び出しまで引き継ぎます。
+func withParentChannel(ctx context.Context, channelID string) context.Context {
+	return context.WithValue(ctx, parentChannelContextKey{}, channelID)
+}
+
+// parentChannelFrom は withParentChannel で付けた親チャンネルのIDを返します。スレッドでない場合は空文字列です。
+func parentChannelFrom(ctx context.Context) string {
+	id, _ := ctx.Value(parentChannelContextKey{}).(string)
+	return id
+}
+
+// invokeGeminiGrounded は Google 検索ツールを有効にして Gemini を呼び出し、参照した出典を応答と履歴に添えます。
+// genai パッケージは Google 検索ツールに対応していないため、generativelanguage のクライアントを直接使用します。
+func (c *Chat) invokeGeminiGrounded(ctx context.Context, userID, threadID, message, fullInput string, modelCfg *loader.ModelConfig) (*ChatResponse, error) {
+	log.Printf("Using Gemini (%s) with Google Search grounding for user %s in thread %s", modelCfg.ModelName, userID, threadID)
+
+	start := time.Now()
+	genCtx, cancel := c.providerContext(ctx, loader.ProviderGemini)
+	resp, err := c.restClient.GenerateContent(genCtx, &pb.GenerateContentRequest{
+		Model:    "models/" + modelCfg.ModelName,
+		Contents: []*pb.Content{{Role: "user", Parts: []*pb.Part{{Data: &pb.Part_Text{Text: fullInput}}}}},
+		Tools:    []*pb.Tool{{GoogleSearch: &pb.Tool_GoogleSearch{}}},
+	})
+	cancel()
+	elapsed := float64(time.Since(start).Milliseconds())
+
+	if err != nil {
+		return c.handleGeminiError(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, wrapGeminiError(err))
+	}
+	if blocked := blockedErrorFromProto(resp); blocked != nil {
+		return c.handleGeminiBlocked(ctx, userID, threadID, message, fullInput, modelCfg, elapsed, newContentBlockedError(blocked, modelCfg.ModelName))
+	}
+
+	chatResp := &ChatResponse{ElapsedMs: elapsed, ModelName: modelCfg.ModelName}
+	if len(resp.GetCandidates()) == 0 {
+		errorLogger.Println("Gemini grounded response candidates are empty.")
+		chatResp.Text = "応答を取得できませんでした。"
+		return chatResp, nil
+	}
+	candidate := resp.GetCandidates()[0]
+	var text strings.Builder
+	for _, part := range candidate.GetContent().GetParts() {
+		text.WriteString(part.GetText())
+	}
+	chatResp.Text = text.String()
+	chatResp.Grounding = groundingFromProto(candidate.GetGroundingMetadata())
+	return c.completeResponse(ctx, "Gemini", userID, threadID, message, chatResp, nil)
+}
+
+// groundingFromProto は GroundingMetadata から検索クエリとWebの出典を取り出します。どちらも無い場合は nil を返します。
+func groundingFromProto(meta *pb.GroundingMetadata) *history.Grounding {
+	if meta == nil {
+		return nil
+	}
+	grounding := &history.Grounding{Queries: meta.GetWebSearchQueries()}
+	for _, chunk := range meta.GetGroundingChunks() {
+		web := chunk.GetWeb()
+		if web.GetUri() == "" {
+			continue
+		}
+		grounding.Sources = append(grounding.Sources, history.GroundingSource{Title: web.GetTitle(), URI: web.GetUri()})
+	}
+	if len(grounding.Queries) == 0 && len(grounding.Sources) == 0 {
+		return nil
+	}
+	return grounding
+}
+
+// blockedErrorFromProto は genai パッケージと同じ基準で、ブロックされた応答を *genai.BlockedError に変換します。
+func blockedErrorFromProto(resp *pb.GenerateContentResponse) *genai.BlockedError {
+	if feedback := resp.GetPromptFeedback(); feedback.GetBlockReason() != pb.GenerateContentResponse_PromptFeedback_BLOCK_REASON_UNSPECIFIED {
+		return &genai.BlockedError{PromptFeedback: &genai.PromptFeedback{
+			BlockReason:   genai.BlockReason(feedback.GetBlockReason()),
+			SafetyRatings: safetyRatingsFromProto(feedback.GetSafetyRatings()),
+		}}
+	}
+	for _, candidate := range resp.GetCandidates() {
+		if reason := candidate.GetFinishReason(); reason == pb.Candidate_SAFETY || reason == pb.Candidate_RECITATION {
+			return &genai.BlockedError{Candidate: &genai.Candidate{
+				FinishReason:  genai.FinishReason(reason),
+				SafetyRatings: safetyRatingsFromProto(candidate.GetSafetyRatings()),
+			}}
+		}
+	}
+	return nil
+}
+
+func safetyRatingsFromProto(ratings []*pb.SafetyRating) []*genai.SafetyRating {
+	converted := make([]*genai.SafetyRating, 0, len(ratings))
+	for _, rating := range ratings {
+		converted = append(converted, &genai.SafetyRating{
+			Category:    genai.HarmCategory(rating.GetCategory()),
+			Probability: genai.HarmProbability(rating.GetProbability()),
+			Blocked:     rating.GetBlocked(),
+		})
+	}
+	return converted
+}
@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	generativelanguage "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"google.golang.org/api/option"
+)
+
+func TestGeminiGrounding(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"東京タワーは333mです。"}]},"finishReason":1,`+
+			`"groundingMetadata":{"webSearchQueries":["東京タワー 高さ"],"groundingChunks":[`+
+			`{"web":{"uri":"https://example.com/a","title":"example.com"}},{"web":{"uri":"https://example.org/b","title":"example.org"}}]}}]}`)
+	}))
+	defer server.Close()
+
+	client, err := generativelanguage.NewGenerativeRESTClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("NewGenerativeRESTClient failed: %v", err)
+	}
+	defer client.Close()
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	errorLogger = log.New(io.Discard, "", 0)
+	c := &Chat{
+		restClient: client,
+		historyMgr: historyMgr,
+		modelConfig: &loader.ModelConfig{
+			ModelName:   "gemini-fake",
+			GeminiTools: loader.GeminiToolsConfig{GroundingChannelIDs: []string{"study"}},
+		},
+	}
+
+	resp, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "study", Message: "東京タワーの高さは？"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tools, _ := request["tools"].([]interface{})
+	if len(tools) != 1 || tools[0].(map[string]interface{})["googleSearch"] == nil {
+		t.Errorf("expected google_search tool in request, got %v", request["tools"])
+	}
+	if resp.Text != "東京タワーは333mです。" || resp.Grounding == nil {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(resp.Grounding.Sources) != 2 || resp.Grounding.Sources[1].URI != "https://example.org/b" || resp.Grounding.Queries[0] != "東京タワー 高さ" {
+		t.Errorf("unexpected grounding: %+v", resp.Grounding)
+	}
+
+	msgs, _ := historyMgr.Get("u1", "study")
+	if len(msgs) != 2 || msgs[1].Grounding == nil || len(msgs[1].Grounding.Sources) != 2 {
+		t.Errorf("grounding should be stored with the turn, got %+v", msgs)
+	}
+
+	// 設定したチャンネルの中のスレッドでも使う
+	request = nil
+	if _, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "thread1", ParentChannelID: "study", Message: "東京タワーの高さは？"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tools, _ := request["tools"].([]interface{}); len(tools) != 1 || tools[0].(map[string]interface{})["googleSearch"] == nil {
+		t.Errorf("threads under a grounding channel should use grounding, got %v", request["tools"])
+	}
+}
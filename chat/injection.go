@@ -0,0 +1,22 @@
+package chat
+
+import (
+	"log"
+
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// screenInjection は params.Message をよくあるプロンプトインジェクションのパターンと照合し、一致した場合はログに記録します。
+// prompt_injection.action が "refuse" の場合は、LLM を呼ばずに返す断りの応答を返します。それ以外は nil です。
+func screenInjection(modelCfg *loader.ModelConfig, params ChatParams) *ChatResponse {
+	pattern, detected := modelCfg.PromptInjection.Detect(params.Message)
+	if !detected {
+		return nil
+	}
+	if !modelCfg.PromptInjection.Refuses() {
+		log.Printf("プロンプトインジェクションの疑いがあるメッセージを検出しました (応答は続けます)。UserID: %s, ThreadID: %s, パターン: %s", params.UserID, params.ThreadID, pattern)
+		return nil
+	}
+	log.Printf("プロンプトインジェクションの疑いがあるメッセージのため応答を断ります。UserID: %s, ThreadID: %s, パターン: %s", params.UserID, params.ThreadID, pattern)
+	return &ChatResponse{Text: modelCfg.PromptInjection.RefusalMessage()}
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+func waitForMemory(t *testing.T, c *Chat) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		running := false
+		c.remembering.Range(func(key, value interface{}) bool {
+			running = true
+			return false
+		})
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("memory extraction did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLongTermMemory(t *testing.T) {
+	c, historyMgr, prompts := newSummaryTestChat(t)
+	c.modelConfig.Summary.Enabled = false
+	c.modelConfig.Memory = loader.MemoryConfig{Enabled: true, MaxFacts: 2}
+	c.botPolicy = newBotPolicy(loader.BotPolicyConfig{})
+	store := historyMgr.(history.MemoryStore)
+	store.AddMemories("u1", "猫が好き", "Go を書いている")
+
+	if _, err := c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "僕の名前は太郎です"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForMemory(t, c)
+
+	if !strings.Contains(stripBoundary(prompts()[0]), "このユーザーについて覚えていること:\n<memories>\n- 猫が好き\n- Go を書いている\n</memories>") {
+		t.Errorf("memories should be in the prompt:\n%s", prompts()[0])
+	}
+	if extraction := stripBoundary(prompts()[1]); !strings.Contains(extraction, "既に覚えていること:\n<memories>\n- 猫が好き") ||
+		!strings.Contains(extraction, "ユーザーの発言:\n<message role=\"user\">僕の名前は太郎です</message>") {
+		t.Errorf("extraction should see the message and existing memories:\n%s", extraction)
+	}
+	memories, _ := store.ListMemories("u1")
+	if len(memories) != 2 || memories[0].Fact != "Go を書いている" || memories[1].Fact != "名前は太郎" {
+		t.Errorf("expected the new fact with the oldest one dropped, got %+v", memories)
+	}
+
+	// /memory off のユーザーと Bot の発言では記憶を使わない
+	store.SetMemoryEnabled("u1", false)
+	c.GetResponse(context.Background(), ChatParams{UserID: "u1", ThreadID: "t1", Message: "こんにちは"})
+	c.GetResponse(context.Background(), ChatParams{UserID: "bot", ThreadID: "t1", Message: "私の名前はボットです", IsBot: true})
+	waitForMemory(t, c)
+	if len(prompts()) != 4 || strings.Contains(prompts()[2], "覚えていること") {
+		t.Errorf("memory should not be used when disabled, got %d requests", len(prompts()))
+	}
+}
+
+func TestRelevantMemories(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.MemoryStore)
+	store.AddMemories("u1", "猫を2匹飼っている")
+	store.AddMemories("u1", "Go でBotを作っている")
+	store.AddMemories("u1", "辛い料理が苦手")
+	c := &Chat{historyMgr: historyMgr}
+	cfg := &loader.ModelConfig{Memory: loader.MemoryConfig{Enabled: true, PromptFacts: 2}}
+
+	var facts []string
+	for _, memory := range c.relevantMemories(cfg, store, "u1", "おすすめの料理を教えて") {
+		facts = append(facts, memory.Fact)
+	}
+	if len(facts) != 2 || facts[0] != "Go でBotを作っている" || facts[1] != "辛い料理が苦手" {
+		t.Errorf("expected the related fact and the newest one in order, got %q", facts)
+	}
+}
@@ -15,125 +15,161 @@ import (
 	"github.com/eraiza0816/llm-discord/loader"
 )
 
-func (c *Chat) getOllamaResponse(ctx context.Context, userID, threadID, message, fullInput string, ollamaCfg loader.OllamaConfig) (string, float64, error) {
+// ollamaStreamResult は Ollama のストリーミング応答の解析結果を表します。
+type ollamaStreamResult struct {
+	Text      string // response フィールドを連結したもの
+	Reasoning string // thinking フィールドを連結したもの (think 対応モデルのみ)
+	Full      string // 受信した生の行
+	Chunks    int    // 解析できた行数
+	Malformed int    // JSONとして解析できなかった行数
+}
+
+// ollamaProvider は Ollama の /api/generate を呼び出す ChatProvider です。
+type ollamaProvider struct {
+	cfg        loader.OllamaConfig
+	format     interface{} // nil 以外の場合、format として送信する ("json" または JSON Schema)
+	httpClient *http.Client
+}
+
+func newOllamaProvider(cfg loader.OllamaConfig) *ollamaProvider {
+	return &ollamaProvider{
+		cfg: cfg,
+		// 期限は呼び出し元の context で指定する
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *ollamaProvider) Name() string { return "Ollama" }
+
+func (p *ollamaProvider) Invoke(ctx context.Context, fullInput string) (*ChatResponse, error) {
 	start := time.Now()
-	url := ollamaCfg.APIEndpoint
-	modelName := ollamaCfg.ModelName
+	url := p.cfg.APIEndpoint
+	modelName := p.cfg.ModelName
 	if url == "" || modelName == "" {
-		return "", 0, fmt.Errorf("Ollama APIエンドポイントまたはモデル名が設定されていません")
+		return nil, fmt.Errorf("Ollama APIエンドポイントまたはモデル名が設定されていません")
 	}
 
-	payload := map[string]string{
+	payload := map[string]interface{}{
 		"prompt": fullInput,
 		"model":  modelName,
 	}
+	if p.format != nil {
+		payload["format"] = p.format
+	}
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		return "", 0, fmt.Errorf("OllamaリクエストペイロードのJSON作成に失敗: %w", err)
+		return nil, fmt.Errorf("OllamaリクエストペイロードのJSON作成に失敗: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return "", 0, fmt.Errorf("Ollamaリクエストの作成に失敗: %w", err)
+		return nil, fmt.Errorf("Ollamaリクエストの作成に失敗: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	resp, err := client.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return "", 0, fmt.Errorf("Ollama APIへのリクエストに失敗: %w", err)
+		return nil, fmt.Errorf("Ollama APIへのリクエストに失敗: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("Ollama APIエラー: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newHTTPProviderError(p.Name(), resp)
 	}
 
 	reader := bufio.NewReader(resp.Body)
-	responseText, fullResponse, err := parseOllamaStreamResponse(reader)
+	result, err := parseOllamaStreamResponse(reader)
 	elapsed := float64(time.Since(start).Milliseconds())
+	chatResp := &ChatResponse{ElapsedMs: elapsed, ModelName: modelName, Text: result.Text, Reasoning: result.Reasoning}
 
 	if err != nil {
+		applyReasoning(chatResp)
 		log.Printf("Ollamaレスポンス解析エラー: %v", err)
-		if len(fullResponse) > 0 {
-			lastLine := ""
-			lines := strings.Split(strings.TrimSuffix(fullResponse, "\n"), "\n")
-			if len(lines) > 0 {
-				lastLine = lines[len(lines)-1]
-			}
-			log.Printf("Ollama API partial response before error: %s", lastLine)
+		if len(result.Full) > 0 {
+			log.Printf("Ollama API partial response before error: %s", lastLine(result.Full))
 		}
-		return "", elapsed, fmt.Errorf("Ollamaレスポンスの解析に失敗しました: %w", err)
+		return chatResp, fmt.Errorf("Ollamaレスポンスの解析に失敗しました: %w", err)
 	}
-
-	lastLine := ""
-	lines := strings.Split(strings.TrimSuffix(fullResponse, "\n"), "\n")
-	if len(lines) > 0 {
-		lastLine = lines[len(lines)-1]
+	if result.Chunks == 0 && result.Malformed > 0 {
+		return chatResp, fmt.Errorf("Ollama: %w", ErrMalformedResponse)
 	}
-	log.Printf("Ollama API response (last line): %s", lastLine)
-	log.Printf("Ollama full response text: %s", responseText)
-
-	if responseText != "" {
-		c.historyMgr.Add(userID, threadID, message, responseText)
-		log.Printf("Added Ollama response to history for user %s in thread %s", userID, threadID)
-	} else {
-		log.Printf("Skipping history add for user %s in thread %s because Ollama responseText is empty.", userID, threadID)
+
+	log.Printf("Ollama API response (last line): %s", lastLine(result.Full))
+
+	applyReasoning(chatResp)
+	log.Printf("Ollama full response text: %s", chatResp.Text)
+	if chatResp.Reasoning != "" {
+		log.Printf("Ollama reasoning tokens (estimated): %d", chatResp.ReasoningTokens)
 	}
+	return chatResp, nil
+}
+
+func (c *Chat) getOllamaResponse(ctx context.Context, userID, threadID, message, fullInput string, ollamaCfg loader.OllamaConfig) (*ChatResponse, error) {
+	ctx, cancel := c.providerContext(ctx, loader.ProviderOllama)
+	defer cancel()
+	chatResp, err := newOllamaProvider(ollamaCfg).Invoke(ctx, fullInput)
+	return c.completeResponse(ctx, "Ollama", userID, threadID, message, chatResp, err)
+}
 
-	return responseText, elapsed, nil
+func lastLine(full string) string {
+	lines := strings.Split(strings.TrimSuffix(full, "\n"), "\n")
+	return lines[len(lines)-1]
 }
 
-func parseOllamaStreamResponse(reader *bufio.Reader) (string, string, error) {
+func parseOllamaStreamResponse(reader *bufio.Reader) (ollamaStreamResult, error) {
 	var responseTextBuilder strings.Builder
+	var reasoningBuilder strings.Builder
 	var fullResponseBuilder strings.Builder
+	var chunks, malformed int
+	result := func() ollamaStreamResult {
+		return ollamaStreamResult{
+			Text:      responseTextBuilder.String(),
+			Reasoning: reasoningBuilder.String(),
+			Full:      fullResponseBuilder.String(),
+			Chunks:    chunks,
+			Malformed: malformed,
+		}
+	}
+
 	for {
 		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				trimmedLine := strings.TrimSpace(string(line))
-				if trimmedLine != "" {
-					fullResponseBuilder.Write(line)
-					var result map[string]interface{}
-					if jsonErr := json.Unmarshal([]byte(trimmedLine), &result); jsonErr == nil {
-						if responsePart, ok := result["response"].(string); ok {
-							responseTextBuilder.WriteString(responsePart)
-						}
-						if done, ok := result["done"].(bool); ok && done {
-							break
-						}
-					} else {
-						log.Printf("最後の行のJSON解析に失敗（EOF）: %v, line: %s", jsonErr, trimmedLine)
-					}
-				}
-				break
-			}
-			return responseTextBuilder.String(), fullResponseBuilder.String(), fmt.Errorf("Ollamaレスポンスの読み込みに失敗: %w", err)
+		if err != nil && err != io.EOF {
+			return result(), fmt.Errorf("Ollamaレスポンスの読み込みに失敗: %w", err)
 		}
-
-		fullResponseBuilder.Write(line)
+		atEOF := err == io.EOF
 
 		trimmedLine := strings.TrimSpace(string(line))
-		if trimmedLine == "" {
-			continue
-		}
-
-		var result map[string]interface{}
-		if err := json.Unmarshal([]byte(trimmedLine), &result); err != nil {
-			log.Printf("Ollamaレスポンス行のJSON解析に失敗: %v, line: %s", err, trimmedLine)
-			continue
-		}
-
-		if responsePart, ok := result["response"].(string); ok {
-			responseTextBuilder.WriteString(responsePart)
+		if trimmedLine != "" {
+			fullResponseBuilder.Write(line)
+
+			var chunk map[string]interface{}
+			if jsonErr := json.Unmarshal([]byte(trimmedLine), &chunk); jsonErr != nil {
+				malformed++
+				if atEOF {
+					log.Printf("最後の行のJSON解析に失敗（EOF）: %v, line: %s", jsonErr, trimmedLine)
+				} else {
+					log.Printf("Ollamaレスポンス行のJSON解析に失敗: %v, line: %s", jsonErr, trimmedLine)
+				}
+			} else {
+				chunks++
+				if errMsg, ok := chunk["error"].(string); ok && errMsg != "" {
+					return result(), &ProviderError{Provider: "Ollama", Message: errMsg}
+				}
+				if thinkingPart, ok := chunk["thinking"].(string); ok {
+					reasoningBuilder.WriteString(thinkingPart)
+				}
+				if responsePart, ok := chunk["response"].(string); ok {
+					responseTextBuilder.WriteString(responsePart)
+				}
+				if done, ok := chunk["done"].(bool); ok && done {
+					break
+				}
+			}
 		}
 
-		if done, ok := result["done"].(bool); ok && done {
+		if atEOF {
 			break
 		}
 	}
-	return responseTextBuilder.String(), fullResponseBuilder.String(), nil
+	return result(), nil
 }
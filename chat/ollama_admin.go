@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OllamaModel は /api/tags が返すローカルモデルの情報を表します。
+type OllamaModel struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Details    struct {
+		ParameterSize     string `json:"parameter_size"`
+		QuantizationLevel string `json:"quantization_level"`
+	} `json:"details"`
+}
+
+// OllamaRunningModel は /api/ps が返すメモリ上にロード済みのモデルの情報を表します。
+type OllamaRunningModel struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OllamaPullProgress は /api/pull のストリーミング応答の1行分を表します。
+type OllamaPullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// OllamaAdmin は Ollama のモデル管理 API (/api/tags, /api/ps, /api/pull, keep_alive) のクライアントです。
+type OllamaAdmin struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewOllamaAdmin は OllamaConfig.APIEndpoint (例: http://127.0.0.1:11434/api/generate) から
+// ベースURLを求めて OllamaAdmin を作成します。
+func NewOllamaAdmin(apiEndpoint string) (*OllamaAdmin, error) {
+	if apiEndpoint == "" {
+		return nil, fmt.Errorf("Ollama APIエンドポイントが設定されていません")
+	}
+	u, err := url.Parse(apiEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Ollama APIエンドポイントの解析に失敗: %w", err)
+	}
+	if idx := strings.Index(u.Path, "/api/"); idx >= 0 {
+		u.Path = u.Path[:idx]
+	}
+	u.RawQuery = ""
+	return &OllamaAdmin{
+		baseURL:    strings.TrimRight(u.String(), "/"),
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// ListModels はローカルに存在するモデルの一覧を返します (/api/tags)。
+func (a *OllamaAdmin) ListModels(ctx context.Context) ([]OllamaModel, error) {
+	var body struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := a.getJSON(ctx, "/api/tags", &body); err != nil {
+		return nil, err
+	}
+	return body.Models, nil
+}
+
+// RunningModels はメモリ上にロードされているモデルの一覧を返します (/api/ps)。
+func (a *OllamaAdmin) RunningModels(ctx context.Context) ([]OllamaRunningModel, error) {
+	var body struct {
+		Models []OllamaRunningModel `json:"models"`
+	}
+	if err := a.getJSON(ctx, "/api/ps", &body); err != nil {
+		return nil, err
+	}
+	return body.Models, nil
+}
+
+// Pull はモデルをダウンロードします (/api/pull)。進捗は1行受信するごとに progress に渡されます。
+func (a *OllamaAdmin) Pull(ctx context.Context, model string, progress func(OllamaPullProgress)) error {
+	resp, err := a.post(ctx, "/api/pull", map[string]interface{}{"model": model, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	lastStatus := ""
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var p OllamaPullProgress
+			if err := json.Unmarshal(trimmed, &p); err != nil {
+				return fmt.Errorf("Ollama pull 応答のJSON解析に失敗: %w, line: %s", err, trimmed)
+			}
+			if p.Error != "" {
+				return fmt.Errorf("Ollama pull エラー: %s", p.Error)
+			}
+			lastStatus = p.Status
+			if progress != nil {
+				progress(p)
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return fmt.Errorf("Ollama pull 応答の読み込みに失敗: %w", readErr)
+		}
+	}
+	if lastStatus != "success" {
+		return fmt.Errorf("Ollama pull が完了しませんでした (最終ステータス: %q)", lastStatus)
+	}
+	return nil
+}
+
+// Unload はモデルを即座にメモリから解放します (keep_alive: 0)。
+func (a *OllamaAdmin) Unload(ctx context.Context, model string) error {
+	return a.generateKeepAlive(ctx, model, 0)
+}
+
+// Warm はモデルをメモリにロードし、keepAlive の間保持させます。keepAlive が空の場合はOllamaの既定値に従います。
+func (a *OllamaAdmin) Warm(ctx context.Context, model, keepAlive string) error {
+	if keepAlive == "" {
+		return a.generateKeepAlive(ctx, model, nil)
+	}
+	return a.generateKeepAlive(ctx, model, keepAlive)
+}
+
+// generateKeepAlive はプロンプトなしで /api/generate を呼び出し、モデルのロード・アンロードのみを行います。
+func (a *OllamaAdmin) generateKeepAlive(ctx context.Context, model string, keepAlive interface{}) error {
+	payload := map[string]interface{}{"model": model, "stream": false}
+	if keepAlive != nil {
+		payload["keep_alive"] = keepAlive
+	}
+	resp, err := a.post(ctx, "/api/generate", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (a *OllamaAdmin) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("Ollamaリクエストの作成に失敗: %w", err)
+	}
+	resp, err := a.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Ollama %s 応答のJSON解析に失敗: %w", path, err)
+	}
+	return nil
+}
+
+func (a *OllamaAdmin) post(ctx context.Context, path string, payload interface{}) (*http.Response, error) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("OllamaリクエストペイロードのJSON作成に失敗: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+path, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return nil, fmt.Errorf("Ollamaリクエストの作成に失敗: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return a.do(req)
+}
+
+func (a *OllamaAdmin) do(req *http.Request) (*http.Response, error) {
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Ollama APIへのリクエストに失敗: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Ollama APIエラー: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return resp, nil
+}
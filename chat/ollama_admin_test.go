@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestOllamaAdmin(t *testing.T, handler http.HandlerFunc) *OllamaAdmin {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	admin, err := NewOllamaAdmin(server.URL + "/api/generate")
+	if err != nil {
+		t.Fatalf("NewOllamaAdmin failed: %v", err)
+	}
+	return admin
+}
+
+func TestNewOllamaAdmin(t *testing.T) {
+	admin, err := NewOllamaAdmin("http://127.0.0.1:11434/api/generate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if admin.baseURL != "http://127.0.0.1:11434" {
+		t.Errorf("unexpected base URL %q", admin.baseURL)
+	}
+	if _, err := NewOllamaAdmin(""); err == nil {
+		t.Error("expected error for empty endpoint")
+	}
+}
+
+func TestOllamaAdmin(t *testing.T) {
+	t.Run("list and ps", func(t *testing.T) {
+		admin := newTestOllamaAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/tags":
+				fmt.Fprint(w, `{"models":[{"name":"gemma3:12b","size":1024,"details":{"parameter_size":"12B","quantization_level":"Q8_0"}}]}`)
+			case "/api/ps":
+				fmt.Fprint(w, `{"models":[{"name":"gemma3:12b","size":1024,"size_vram":512}]}`)
+			default:
+				http.NotFound(w, r)
+			}
+		})
+
+		models, err := admin.ListModels(context.Background())
+		if err != nil {
+			t.Fatalf("ListModels failed: %v", err)
+		}
+		if len(models) != 1 || models[0].Name != "gemma3:12b" || models[0].Details.ParameterSize != "12B" {
+			t.Errorf("unexpected models: %+v", models)
+		}
+
+		running, err := admin.RunningModels(context.Background())
+		if err != nil {
+			t.Fatalf("RunningModels failed: %v", err)
+		}
+		if len(running) != 1 || running[0].SizeVRAM != 512 {
+			t.Errorf("unexpected running models: %+v", running)
+		}
+	})
+
+	t.Run("pull streams progress", func(t *testing.T) {
+		admin := newTestOllamaAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+			fmt.Fprintln(w, `{"status":"pulling abc","digest":"abc","total":100,"completed":50}`)
+			fmt.Fprintln(w, `{"status":"success"}`)
+		})
+
+		var statuses []string
+		err := admin.Pull(context.Background(), "gemma3:12b", func(p OllamaPullProgress) {
+			statuses = append(statuses, p.Status)
+		})
+		if err != nil {
+			t.Fatalf("Pull failed: %v", err)
+		}
+		if len(statuses) != 3 || statuses[1] != "pulling abc" {
+			t.Errorf("unexpected progress: %v", statuses)
+		}
+	})
+
+	t.Run("pull error in stream", func(t *testing.T) {
+		admin := newTestOllamaAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+			fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+		})
+		if err := admin.Pull(context.Background(), "missing", nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unload and warm send keep_alive", func(t *testing.T) {
+		var payloads []map[string]interface{}
+		admin := newTestOllamaAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+			var p map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&p)
+			payloads = append(payloads, p)
+			fmt.Fprint(w, `{"done":true}`)
+		})
+
+		if err := admin.Unload(context.Background(), "gemma3:12b"); err != nil {
+			t.Fatalf("Unload failed: %v", err)
+		}
+		if err := admin.Warm(context.Background(), "gemma3:12b", "30m"); err != nil {
+			t.Fatalf("Warm failed: %v", err)
+		}
+		if len(payloads) != 2 {
+			t.Fatalf("expected 2 requests, got %d", len(payloads))
+		}
+		if payloads[0]["keep_alive"] != float64(0) {
+			t.Errorf("expected keep_alive 0 for unload, got %v", payloads[0]["keep_alive"])
+		}
+		if payloads[1]["keep_alive"] != "30m" {
+			t.Errorf("expected keep_alive 30m for warm, got %v", payloads[1]["keep_alive"])
+		}
+	})
+
+	t.Run("non-200 status", func(t *testing.T) {
+		admin := newTestOllamaAdmin(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		})
+		if _, err := admin.ListModels(context.Background()); err == nil {
+			t.Error("expected error for 500 response")
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -18,10 +19,34 @@ import (
 type openaiStreamingResponse struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Usage *struct {
+		CompletionTokensDetails *struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"completion_tokens_details"`
+	} `json:"usage"`
+}
+
+// openaiStreamResult は OpenAI 互換 API のストリーミング応答の解析結果を表します。
+type openaiStreamResult struct {
+	Text            string
+	Reasoning       string // delta.reasoning_content (または delta.reasoning) を連結したもの
+	ReasoningTokens int    // usage に含まれていた場合のみ設定される
+	Chunks          int    // 解析できた data 行数
+	Malformed       int    // JSONとして解析できなかった data 行数
+}
+
+// openaiStreamOptions はストリーミング時に usage を受け取るためのオプションです。
+type openaiStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // openaiChatMessage は OpenAI 互換 API リクエストのメッセージを表します。
@@ -32,19 +57,49 @@ type openaiChatMessage struct {
 
 // openaiChatRequest は OpenAI 互換 API のチャット補完リクエストを表します。
 type openaiChatRequest struct {
-	Model       string              `json:"model"`
-	Messages    []openaiChatMessage `json:"messages"`
-	Stream      bool                `json:"stream"`
-	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Model          string                `json:"model"`
+	Messages       []openaiChatMessage   `json:"messages"`
+	Stream         bool                  `json:"stream"`
+	StreamOptions  *openaiStreamOptions  `json:"stream_options,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	ResponseFormat *openaiResponseFormat `json:"response_format,omitempty"`
+}
+
+// openaiResponseFormat は構造化出力 (response_format: json_schema) の指定です。
+type openaiResponseFormat struct {
+	Type       string                `json:"type"`
+	JSONSchema *openaiJSONSchemaSpec `json:"json_schema,omitempty"`
+}
+
+type openaiJSONSchemaSpec struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
 }
 
-// getOpenAIResponse は OpenAI 互換 API エンドポイント（v1/chat/completions）にリクエストを送信し、
-// ストリーミング応答からテキストを取得します。
-func (c *Chat) getOpenAIResponse(ctx context.Context, userID, threadID, message, fullInput string, openaiCfg loader.OpenAIConfig) (string, float64, error) {
+// openaiProvider は OpenAI 互換 API エンドポイント（v1/chat/completions）を呼び出す ChatProvider です。
+type openaiProvider struct {
+	cfg            loader.OpenAIConfig
+	responseFormat *openaiResponseFormat
+	httpClient     *http.Client
+}
+
+func newOpenAIProvider(cfg loader.OpenAIConfig) *openaiProvider {
+	return &openaiProvider{
+		cfg: cfg,
+		// 期限は呼び出し元の context で指定する
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *openaiProvider) Name() string { return "OpenAI" }
+
+// Invoke は OpenAI 互換 API にリクエストを送信し、ストリーミング応答からテキストを取得します。
+func (p *openaiProvider) Invoke(ctx context.Context, fullInput string) (*ChatResponse, error) {
 	start := time.Now()
+	openaiCfg := p.cfg
 
 	if openaiCfg.APIEndpoint == "" || openaiCfg.ModelName == "" {
-		return "", 0, fmt.Errorf("OpenAI APIエンドポイントまたはモデル名が設定されていません")
+		return nil, fmt.Errorf("OpenAI APIエンドポイントまたはモデル名が設定されていません")
 	}
 
 	// エンドポイント末尾に /chat/completions がなければ補完
@@ -61,18 +116,20 @@ func (c *Chat) getOpenAIResponse(ctx context.Context, userID, threadID, message,
 				Content: fullInput,
 			},
 		},
-		Stream:    true,
-		MaxTokens: 4096,
+		Stream:         true,
+		StreamOptions:  &openaiStreamOptions{IncludeUsage: true},
+		MaxTokens:      4096,
+		ResponseFormat: p.responseFormat,
 	}
 
 	jsonPayload, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", 0, fmt.Errorf("OpenAIリクエストペイロードのJSON作成に失敗: %w", err)
+		return nil, fmt.Errorf("OpenAIリクエストペイロードのJSON作成に失敗: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return "", 0, fmt.Errorf("OpenAIリクエストの作成に失敗: %w", err)
+		return nil, fmt.Errorf("OpenAIリクエストの作成に失敗: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -81,41 +138,75 @@ func (c *Chat) getOpenAIResponse(ctx context.Context, userID, threadID, message,
 		req.Header.Set("Authorization", "Bearer "+openaiCfg.APIKey)
 	}
 
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	resp, err := client.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return "", 0, fmt.Errorf("OpenAI APIへのリクエストに失敗: %w", err)
+		return nil, fmt.Errorf("OpenAI APIへのリクエストに失敗: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("OpenAI APIエラー: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newHTTPProviderError(p.Name(), resp)
 	}
 
 	reader := bufio.NewReader(resp.Body)
-	responseText, err := parseOpenAIStreamResponse(reader)
+	result, err := parseOpenAIStreamResponse(reader)
 	elapsed := float64(time.Since(start).Milliseconds())
+	chatResp := &ChatResponse{
+		Text:            result.Text,
+		ElapsedMs:       elapsed,
+		ModelName:       openaiCfg.ModelName,
+		Reasoning:       result.Reasoning,
+		ReasoningTokens: result.ReasoningTokens,
+	}
 
 	if err != nil {
-		return "", elapsed, fmt.Errorf("OpenAIレスポンスの解析に失敗しました: %w", err)
+		applyReasoning(chatResp)
+		return chatResp, fmt.Errorf("OpenAIレスポンスの解析に失敗しました: %w", err)
+	}
+	if result.Chunks == 0 && result.Malformed > 0 {
+		return chatResp, fmt.Errorf("OpenAI: %w", ErrMalformedResponse)
 	}
 
-	responseText = strings.TrimSpace(responseText)
-
-	if responseText != "" {
-		c.historyMgr.Add(userID, threadID, message, responseText)
+	applyReasoning(chatResp)
+	if chatResp.Reasoning != "" {
+		log.Printf("OpenAI reasoning tokens: %d", chatResp.ReasoningTokens)
 	}
+	return chatResp, nil
+}
+
+// getOpenAIResponse は OpenAI 互換 API から応答を取得し、履歴に保存します。
+func (c *Chat) getOpenAIResponse(ctx context.Context, userID, threadID, message, fullInput string, openaiCfg loader.OpenAIConfig) (*ChatResponse, error) {
+	ctx, cancel := c.providerContext(ctx, loader.ProviderOpenAI)
+	defer cancel()
+	chatResp, err := newOpenAIProvider(openaiCfg).Invoke(ctx, fullInput)
+	return c.completeResponse(ctx, "OpenAI", userID, threadID, message, chatResp, err)
+}
 
-	return responseText, elapsed, nil
+// isSSEField は行が data 以外の SSE フィールドまたはコメントかを返します。
+func isSSEField(line string) bool {
+	for _, prefix := range []string{":", "event:", "id:", "retry:"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // parseOpenAIStreamResponse は OpenAI 互換 API の Server-Sent Events (SSE) ストリームを解析します。
 // 各行は "data: <json>" の形式で送信され、"data: [DONE]" で終了します。
-func parseOpenAIStreamResponse(reader *bufio.Reader) (string, error) {
+func parseOpenAIStreamResponse(reader *bufio.Reader) (openaiStreamResult, error) {
 	var responseTextBuilder strings.Builder
+	var reasoningBuilder strings.Builder
+	var reasoningTokens, chunks, malformed int
+	result := func() openaiStreamResult {
+		return openaiStreamResult{
+			Text:            responseTextBuilder.String(),
+			Reasoning:       reasoningBuilder.String(),
+			ReasoningTokens: reasoningTokens,
+			Chunks:          chunks,
+			Malformed:       malformed,
+		}
+	}
 
 	for {
 		line, err := reader.ReadString('\n')
@@ -123,7 +214,7 @@ func parseOpenAIStreamResponse(reader *bufio.Reader) (string, error) {
 			if err == io.EOF {
 				break
 			}
-			return responseTextBuilder.String(), fmt.Errorf("ストリーム読み込みエラー: %w", err)
+			return result(), fmt.Errorf("ストリーム読み込みエラー: %w", err)
 		}
 
 		line = strings.TrimSpace(line)
@@ -133,6 +224,9 @@ func parseOpenAIStreamResponse(reader *bufio.Reader) (string, error) {
 
 		// "data: " プレフィックスを除去
 		if !strings.HasPrefix(line, "data: ") {
+			if !isSSEField(line) {
+				malformed++
+			}
 			continue
 		}
 		data := strings.TrimPrefix(line, "data: ")
@@ -145,16 +239,31 @@ func parseOpenAIStreamResponse(reader *bufio.Reader) (string, error) {
 		var streamResp openaiStreamingResponse
 		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
 			// パースに失敗した行はスキップ（不完全な行の可能性）
+			malformed++
 			continue
 		}
+		chunks++
+
+		if streamResp.Error != nil {
+			return result(), &ProviderError{Provider: "OpenAI", Message: streamResp.Error.Message}
+		}
+
+		if streamResp.Usage != nil && streamResp.Usage.CompletionTokensDetails != nil {
+			reasoningTokens = streamResp.Usage.CompletionTokensDetails.ReasoningTokens
+		}
 
 		if len(streamResp.Choices) > 0 {
 			delta := streamResp.Choices[0].Delta
+			if delta.ReasoningContent != "" {
+				reasoningBuilder.WriteString(delta.ReasoningContent)
+			} else if delta.Reasoning != "" {
+				reasoningBuilder.WriteString(delta.Reasoning)
+			}
 			if delta.Content != "" {
 				responseTextBuilder.WriteString(delta.Content)
 			}
 		}
 	}
 
-	return responseTextBuilder.String(), nil
+	return result(), nil
 }
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// activePersona は応答に使うペルソナです。
+type activePersona struct {
+	id string
+	loader.PersonaConfig
+}
+
+// resolvePersona は params の会話に使うペルソナを返します。ペルソナを使わない場合は nil です。
+// ユーザーが選んだペルソナ、スレッド、親チャンネルの既定のペルソナの順に探します。
+// 共有スレッドでは発言者ごとに Bot のキャラクターが変わらないよう、チャンネルの既定のみを使います。
+func (c *Chat) resolvePersona(modelCfg *loader.ModelConfig, params ChatParams) *activePersona {
+	if len(modelCfg.Personas) == 0 {
+		return nil
+	}
+	store, ok := c.historyMgr.(history.PersonaStore)
+	if !ok {
+		return nil
+	}
+
+	type selection struct{ scope, targetID string }
+	var selections []selection
+	if !params.SharedThread {
+		selections = append(selections, selection{history.PersonaScopeUser, params.UserID})
+	}
+	selections = append(selections, selection{history.PersonaScopeChannel, params.ThreadID}, selection{history.PersonaScopeChannel, params.ParentChannelID})
+	for _, sel := range selections {
+		if sel.targetID == "" {
+			continue
+		}
+		id, ok, err := store.GetPersona(sel.scope, sel.targetID)
+		if err != nil {
+			errorLogger.Printf("%s %s のペルソナの取得に失敗しました: %v", sel.scope, sel.targetID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		persona, ok := modelCfg.Persona(id)
+		if !ok {
+			log.Printf("%s %s に設定されているペルソナ %s は model.json に無いため使いません", sel.scope, sel.targetID, id)
+			continue
+		}
+		return &activePersona{id: id, PersonaConfig: persona}
+	}
+	return nil
+}
+
+// withPersonaExamples はペルソナの会話の例を、システムプロンプトの後ろに加えた Prompt を返します。
+func withPersonaExamples(prompt Prompt, examples []loader.PersonaExample) Prompt {
+	if len(examples) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	b.WriteString("\n会話の例 (口調の参考にしてください):\n")
+	for _, example := range examples {
+		fmt.Fprintf(&b, "user: %s\nassistant: %s\n", example.User, example.Assistant)
+	}
+	prompt.System += b.String()
+	return prompt
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+func TestGetResponseWithPersona(t *testing.T) {
+	c, historyMgr, prompts := newSummaryTestChat(t)
+	c.modelConfig.Summary.Enabled = false
+	c.modelConfig.Name = "通常のBot"
+	c.modelConfig.Prompts = map[string]string{"default": "あなたは{{.BotName}}です。"}
+	c.modelConfig.Personas = map[string]loader.PersonaConfig{
+		"butler": {
+			DisplayName: "執事",
+			Icon:        "https://example.com/butler.png",
+			Prompt:      "あなたは{{.BotName}}です。丁寧に話します。",
+			Examples:    []loader.PersonaExample{{User: "おはよう", Assistant: "おはようございます、お嬢様。"}},
+			ModelName:   "fake-butler",
+		},
+		"otaku": {Prompt: "あなたは{{.BotName}}です。早口で話します。"},
+	}
+	store := historyMgr.(history.PersonaStore)
+	store.SetPersona(history.PersonaScopeChannel, "parent", "butler")
+	store.SetPersona(history.PersonaScopeUser, "fan", "otaku")
+	store.SetPersona(history.PersonaScopeUser, "stale", "removed")
+
+	tests := []struct {
+		name      string
+		params    ChatParams
+		system    string
+		persona   string
+		modelName string
+	}{
+		{"no persona", ChatParams{UserID: "u1", ThreadID: "other"}, "あなたは通常のBotです。", "", "fake"},
+		{"channel default from the parent channel", ChatParams{UserID: "u1", ThreadID: "thread", ParentChannelID: "parent"}, "あなたは執事です。丁寧に話します。", "執事", "fake-butler"},
+		{"user choice wins over the channel default", ChatParams{UserID: "fan", ThreadID: "thread", ParentChannelID: "parent"}, "あなたはotakuです。早口で話します。", "otaku", "fake"},
+		{"shared threads use the channel default", ChatParams{UserID: "fan", ThreadID: "thread", ParentChannelID: "parent", SharedThread: true}, "あなたは執事です。", "執事", "fake-butler"},
+		{"unknown persona is ignored", ChatParams{UserID: "stale", ThreadID: "other"}, "あなたは通常のBotです。", "", "fake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Message = "こんにちは"
+			resp, err := c.GetResponse(context.Background(), tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sent := prompts()
+			prompt := sent[len(sent)-1]
+			if !strings.HasPrefix(prompt, tt.system) {
+				t.Errorf("prompt should start with %q, got:\n%s", tt.system, prompt)
+			}
+			if resp.PersonaName != tt.persona || resp.ModelName != tt.modelName {
+				t.Errorf("got persona %q model %q, want %q %q", resp.PersonaName, resp.ModelName, tt.persona, tt.modelName)
+			}
+			hasExamples := strings.Contains(prompt, "user: おはよう\nassistant: おはようございます、お嬢様。")
+			if hasExamples != (tt.persona == "執事") {
+				t.Errorf("few-shot examples should be included only for the butler persona, got:\n%s", prompt)
+			}
+		})
+	}
+}
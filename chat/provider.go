@@ -1,9 +1,75 @@
 package chat
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
 
 // ChatProvider defines the interface for LLM provider implementations.
+// Invoke は履歴の保存を行わず、プロバイダへの1回の問い合わせ結果のみを返します。
 type ChatProvider interface {
-	Invoke(ctx context.Context, fullInput string) (string, float64, error)
+	Invoke(ctx context.Context, fullInput string) (*ChatResponse, error)
 	Name() string
 }
+
+// ErrMalformedResponse はプロバイダの応答を1つも解析できなかった場合に返されます。
+var ErrMalformedResponse = errors.New("プロバイダの応答を解析できませんでした")
+
+// ProviderError はプロバイダのAPIが成功以外のステータスを返した場合、
+// またはストリームの途中でエラーを通知した場合のエラーです。
+type ProviderError struct {
+	Provider   string
+	StatusCode int           // ストリーム途中のエラーでは 0
+	RetryAfter time.Duration // Retry-After ヘッダーが無い場合は 0
+	Message    string
+	Err        error // 元のエラー (Gemini の *googleapi.Error など)
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s APIエラー: %s", e.Provider, e.Message)
+	}
+	return fmt.Sprintf("%s APIエラー: status code %d, body: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// IsRateLimited は429 (Too Many Requests) によるエラーかを返します。
+func (e *ProviderError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// newHTTPProviderError は成功以外のHTTP応答から ProviderError を作成します。resp.Body は読み切られます。
+func newHTTPProviderError(provider string, resp *http.Response) *ProviderError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Message:    string(bodyBytes),
+	}
+}
+
+// parseRetryAfter は Retry-After ヘッダーの値 (秒数またはHTTP日付) を待機時間に変換します。
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
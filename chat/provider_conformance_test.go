@@ -0,0 +1,339 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// providerScenario はフェイクサーバーに演じさせる応答のシナリオです。
+type providerScenario struct {
+	chunks     []string      // 応答テキストを分割したもの。ストリーミング対応のプロバイダでは1つずつフラッシュされる
+	failAfter  int           // >0 の場合、chunks[:failAfter] を送った後にストリーム内でエラーを通知する
+	dropAfter  int           // >0 の場合、chunks[:dropAfter] を送った後に接続を切断する
+	status     int           // 200 以外を返す場合のステータスコード
+	retryAfter string        // status と共に返す Retry-After ヘッダー
+	delay      time.Duration // 応答ヘッダーを返すまでの遅延
+	malformed  bool          // JSONとして解析できない本文を返す
+}
+
+// fakeProvider はフェイクサーバーのプロトコル部分と、そのサーバーに向けたプロバイダの作り方を表します。
+type fakeProvider struct {
+	name string
+	// streaming はチャンク単位で応答を受け取るプロバイダか。true の場合、途中で失敗しても受信済みのテキストを返すことを期待する
+	streaming bool
+	// writeStream は 200 応答の本文を書き込みます。flush はチャンクごとに呼び出します。
+	writeStream func(w http.ResponseWriter, sc providerScenario, flush func())
+	// writeError はステータスコード付きのエラー本文を書き込みます。
+	writeError  func(w http.ResponseWriter, status int)
+	newProvider func(t *testing.T, serverURL string) ChatProvider
+}
+
+func fakeOllama() fakeProvider {
+	return fakeProvider{
+		name:      "ollama",
+		streaming: true,
+		writeStream: func(w http.ResponseWriter, sc providerScenario, flush func()) {
+			for i, chunk := range sc.chunks {
+				if sc.failAfter > 0 && i == sc.failAfter {
+					fmt.Fprintln(w, `{"error":"boom"}`)
+					return
+				}
+				if sc.dropAfter > 0 && i == sc.dropAfter {
+					panic(http.ErrAbortHandler)
+				}
+				b, _ := json.Marshal(map[string]interface{}{"model": "fake", "response": chunk, "done": false})
+				fmt.Fprintf(w, "%s\n", b)
+				flush()
+			}
+			fmt.Fprintln(w, `{"model":"fake","response":"","done":true}`)
+		},
+		writeError: func(w http.ResponseWriter, status int) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"error":"fake failure"}`)
+		},
+		newProvider: func(t *testing.T, serverURL string) ChatProvider {
+			return newOllamaProvider(loader.OllamaConfig{APIEndpoint: serverURL + "/api/generate", ModelName: "fake"})
+		},
+	}
+}
+
+func fakeOpenAI() fakeProvider {
+	return fakeProvider{
+		name:      "openai",
+		streaming: true,
+		writeStream: func(w http.ResponseWriter, sc providerScenario, flush func()) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i, chunk := range sc.chunks {
+				if sc.failAfter > 0 && i == sc.failAfter {
+					fmt.Fprint(w, "data: {\"error\":{\"message\":\"boom\"}}\n\n")
+					return
+				}
+				if sc.dropAfter > 0 && i == sc.dropAfter {
+					panic(http.ErrAbortHandler)
+				}
+				b, _ := json.Marshal(map[string]interface{}{
+					"choices": []map[string]interface{}{{"delta": map[string]string{"content": chunk}}},
+				})
+				fmt.Fprintf(w, "data: %s\n\n", b)
+				flush()
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		},
+		writeError: func(w http.ResponseWriter, status int) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"error":{"message":"fake failure"}}`)
+		},
+		newProvider: func(t *testing.T, serverURL string) ChatProvider {
+			return newOpenAIProvider(loader.OpenAIConfig{APIEndpoint: serverURL + "/v1", ModelName: "fake", APIKey: "test-key"})
+		},
+	}
+}
+
+func fakeAnthropic() fakeProvider {
+	return fakeProvider{
+		name:      "anthropic",
+		streaming: true,
+		writeStream: func(w http.ResponseWriter, sc providerScenario, flush func()) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			writeAnthropicEvent(w, "message_start", `{"type":"message_start","message":{"model":"fake","usage":{"input_tokens":3}}}`)
+			writeAnthropicEvent(w, "content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+			for i, chunk := range sc.chunks {
+				if sc.failAfter > 0 && i == sc.failAfter {
+					writeAnthropicEvent(w, "error", `{"type":"error","error":{"type":"overloaded_error","message":"boom"}}`)
+					return
+				}
+				if sc.dropAfter > 0 && i == sc.dropAfter {
+					panic(http.ErrAbortHandler)
+				}
+				b, _ := json.Marshal(map[string]interface{}{
+					"type": "content_block_delta", "index": 0,
+					"delta": map[string]string{"type": "text_delta", "text": chunk},
+				})
+				writeAnthropicEvent(w, "content_block_delta", string(b))
+				flush()
+			}
+			writeAnthropicEvent(w, "content_block_stop", `{"type":"content_block_stop","index":0}`)
+			writeAnthropicEvent(w, "message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`)
+			writeAnthropicEvent(w, "message_stop", `{"type":"message_stop"}`)
+		},
+		writeError: func(w http.ResponseWriter, status int) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"fake_error","message":"fake failure"}}`)
+		},
+		newProvider: func(t *testing.T, serverURL string) ChatProvider {
+			return newAnthropicProvider(loader.AnthropicConfig{APIEndpoint: serverURL + "/v1/messages", ModelName: "fake"}, "test-key", nil)
+		},
+	}
+}
+
+func writeAnthropicEvent(w io.Writer, event, data string) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// fakeGemini は generateContent の REST エンドポイントを模倣します。Gemini は一括応答のため、
+// ストリーム途中の失敗は途中で途切れたJSONとして表現します。
+func fakeGemini() fakeProvider {
+	return fakeProvider{
+		name: "gemini",
+		writeStream: func(w http.ResponseWriter, sc providerScenario, flush func()) {
+			w.Header().Set("Content-Type", "application/json")
+			parts := make([]map[string]string, 0, len(sc.chunks))
+			for _, chunk := range sc.chunks {
+				parts = append(parts, map[string]string{"text": chunk})
+			}
+			b, _ := json.Marshal(map[string]interface{}{
+				"candidates": []map[string]interface{}{{
+					"content":      map[string]interface{}{"role": "model", "parts": parts},
+					"finishReason": 1,
+				}},
+			})
+			switch {
+			case sc.failAfter > 0:
+				w.Write(b[:len(b)/2])
+			case sc.dropAfter > 0:
+				w.Write(b[:len(b)/2])
+				flush()
+				panic(http.ErrAbortHandler)
+			default:
+				w.Write(b)
+			}
+		},
+		writeError: func(w http.ResponseWriter, status int) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"error":{"code":%d,"message":"fake failure","status":"FAKE"}}`, status)
+		},
+		newProvider: func(t *testing.T, serverURL string) ChatProvider {
+			client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(serverURL))
+			if err != nil {
+				t.Fatalf("genai.NewClient failed: %v", err)
+			}
+			t.Cleanup(func() { client.Close() })
+			return newGeminiProvider(client, "gemini-fake")
+		},
+	}
+}
+
+// start はシナリオを演じるフェイクサーバーを起動し、そのサーバーに向けたプロバイダを返します。
+func (f fakeProvider) start(t *testing.T, sc providerScenario) ChatProvider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 本文を読み切らないとサーバーはクライアントの切断を検知できない
+		io.Copy(io.Discard, r.Body)
+		if sc.delay > 0 {
+			select {
+			case <-time.After(sc.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if sc.retryAfter != "" {
+			w.Header().Set("Retry-After", sc.retryAfter)
+		}
+		if sc.status != 0 && sc.status != http.StatusOK {
+			f.writeError(w, sc.status)
+			return
+		}
+		if sc.malformed {
+			fmt.Fprint(w, "this is not json\n<html>\n")
+			return
+		}
+		flush := func() {
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+		f.writeStream(w, sc, flush)
+	}))
+	t.Cleanup(server.Close)
+	return f.newProvider(t, server.URL)
+}
+
+func TestProviderConformance(t *testing.T) {
+	fakes := []fakeProvider{fakeOllama(), fakeOpenAI(), fakeAnthropic(), fakeGemini()}
+	chunks := []string{"Hello", ", ", "World", "!"}
+
+	for _, f := range fakes {
+		f := f
+		t.Run(f.name, func(t *testing.T) {
+			t.Run("chunked stream is concatenated", func(t *testing.T) {
+				p := f.start(t, providerScenario{chunks: chunks})
+				resp, err := p.Invoke(context.Background(), "hi")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if resp.Text != "Hello, World!" {
+					t.Errorf("expected %q, got %q", "Hello, World!", resp.Text)
+				}
+				if resp.ModelName == "" {
+					t.Error("expected model name to be set")
+				}
+			})
+
+			t.Run("error partway through stream", func(t *testing.T) {
+				p := f.start(t, providerScenario{chunks: chunks, failAfter: 2})
+				resp, err := p.Invoke(context.Background(), "hi")
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if f.streaming {
+					var providerErr *ProviderError
+					if !errors.As(err, &providerErr) || providerErr.Message != "boom" {
+						t.Errorf("expected in-stream ProviderError, got %v", err)
+					}
+					if resp == nil || resp.Text != "Hello," {
+						t.Errorf("expected partial text to be kept, got %+v", resp)
+					}
+				}
+			})
+
+			t.Run("connection dropped mid stream", func(t *testing.T) {
+				p := f.start(t, providerScenario{chunks: chunks, dropAfter: 1})
+				if _, err := p.Invoke(context.Background(), "hi"); err == nil {
+					t.Fatal("expected error")
+				}
+			})
+
+			t.Run("429 with Retry-After", func(t *testing.T) {
+				p := f.start(t, providerScenario{status: http.StatusTooManyRequests, retryAfter: "7"})
+				_, err := p.Invoke(context.Background(), "hi")
+				var providerErr *ProviderError
+				if !errors.As(err, &providerErr) {
+					t.Fatalf("expected ProviderError, got %v", err)
+				}
+				if !providerErr.IsRateLimited() {
+					t.Errorf("expected rate limited error, got status %d", providerErr.StatusCode)
+				}
+				if providerErr.RetryAfter != 7*time.Second {
+					t.Errorf("expected RetryAfter 7s, got %v", providerErr.RetryAfter)
+				}
+			})
+
+			t.Run("server error", func(t *testing.T) {
+				p := f.start(t, providerScenario{status: http.StatusInternalServerError})
+				_, err := p.Invoke(context.Background(), "hi")
+				var providerErr *ProviderError
+				if !errors.As(err, &providerErr) || providerErr.StatusCode != http.StatusInternalServerError {
+					t.Fatalf("expected ProviderError with status 500, got %v", err)
+				}
+				if !strings.Contains(providerErr.Message, "fake failure") {
+					t.Errorf("expected error body in message, got %q", providerErr.Message)
+				}
+			})
+
+			t.Run("slow response honours context deadline", func(t *testing.T) {
+				p := f.start(t, providerScenario{chunks: chunks, delay: 5 * time.Second})
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				start := time.Now()
+				_, err := p.Invoke(ctx, "hi")
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+				}
+				if elapsed := time.Since(start); elapsed > 2*time.Second {
+					t.Errorf("expected Invoke to return promptly, took %v", elapsed)
+				}
+			})
+
+			t.Run("malformed JSON", func(t *testing.T) {
+				p := f.start(t, providerScenario{malformed: true})
+				resp, err := p.Invoke(context.Background(), "hi")
+				if err == nil {
+					t.Fatalf("expected error, got response %+v", resp)
+				}
+			})
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-30 * time.Second).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
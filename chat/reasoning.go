@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// splitReasoning は応答テキストから <think>…</think> ブロックを取り除き、回答と思考過程に分離します。
+// 開始タグを省略して </think> だけを出力するモデルや、閉じタグの前に打ち切られた出力にも対応します。
+func splitReasoning(text string) (answer, reasoning string) {
+	var answerBuilder, reasoningBuilder strings.Builder
+
+	rest := text
+	if closeIdx := strings.Index(rest, thinkCloseTag); closeIdx >= 0 && !strings.Contains(rest[:closeIdx], thinkOpenTag) {
+		reasoningBuilder.WriteString(rest[:closeIdx])
+		rest = rest[closeIdx+len(thinkCloseTag):]
+	}
+
+	for {
+		openIdx := strings.Index(rest, thinkOpenTag)
+		if openIdx < 0 {
+			answerBuilder.WriteString(rest)
+			break
+		}
+		answerBuilder.WriteString(rest[:openIdx])
+		rest = rest[openIdx+len(thinkOpenTag):]
+
+		closeIdx := strings.Index(rest, thinkCloseTag)
+		if closeIdx < 0 {
+			appendReasoning(&reasoningBuilder, rest)
+			break
+		}
+		appendReasoning(&reasoningBuilder, rest[:closeIdx])
+		rest = rest[closeIdx+len(thinkCloseTag):]
+	}
+
+	return strings.TrimSpace(answerBuilder.String()), strings.TrimSpace(reasoningBuilder.String())
+}
+
+func appendReasoning(sb *strings.Builder, part string) {
+	part = strings.TrimSpace(part)
+	if part == "" {
+		return
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString(part)
+}
+
+// estimateTokens はテキストのおおよそのトークン数を見積もります。
+// ASCII 文字は4文字で1トークン、それ以外 (日本語など) は1文字1トークンとして数えます。
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	asciiBytes := 0
+	others := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			asciiBytes++
+		} else {
+			others++
+		}
+	}
+	return (asciiBytes+3)/4 + others
+}
+
+// EstimateTokens は estimateTokens と同じ見積もりです。返信の連鎖をたどる量の制限など、chat パッケージの外で使います。
+func EstimateTokens(text string) int {
+	return estimateTokens(text)
+}
+
+// applyReasoning は resp.Text に含まれる <think> ブロックを Reasoning に移し、
+// 思考過程のトークン数が未設定の場合は推定値を設定します。
+func applyReasoning(resp *ChatResponse) {
+	answer, inline := splitReasoning(resp.Text)
+	resp.Text = answer
+	if inline != "" {
+		if resp.Reasoning != "" {
+			resp.Reasoning += "\n\n" + inline
+		} else {
+			resp.Reasoning = inline
+		}
+	}
+	resp.Reasoning = strings.TrimSpace(resp.Reasoning)
+	if resp.ReasoningTokens == 0 {
+		resp.ReasoningTokens = estimateTokens(resp.Reasoning)
+	}
+}
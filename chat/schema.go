@@ -0,0 +1,251 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// validateJSONSchema は value (json.Unmarshal で interface{} に復元した値) が schema に適合するかを検証します。
+// 対応しているのは type, properties, required, additionalProperties (false のみ), items, enum, nullable です。
+func validateJSONSchema(value interface{}, schema map[string]interface{}) error {
+	return validateSchemaAt("$", value, schema)
+}
+
+func validateSchemaAt(path string, value interface{}, schema map[string]interface{}) error {
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schemaAllowsType(schema, "null") {
+			return nil
+		}
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 {
+		matched := false
+		for _, typ := range types {
+			if jsonValueHasType(value, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: %s 型が必要ですが %s でした", path, strings.Join(types, " または "), jsonTypeName(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if fmt.Sprint(candidate) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v は許可された値 %v のいずれでもありません", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, name := range schemaRequired(schema) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: 必須プロパティ %q がありません", path, name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propSchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, isBool := schema["additionalProperties"].(bool); isBool && !additional {
+					return fmt.Errorf("%s: 未定義のプロパティ %q があります", path, key)
+				}
+				continue
+			}
+			if err := validateSchemaAt(path+"."+key, v[key], propSchema); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateSchemaAt(fmt.Sprintf("%s[%d]", path, i), item, items); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes は schema の type を文字列のスライスとして返します (type: ["string", "null"] 形式にも対応)。
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func schemaAllowsType(schema map[string]interface{}, typ string) bool {
+	for _, t := range schemaTypes(schema) {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaRequired(schema map[string]interface{}) []string {
+	switch r := schema["required"].(type) {
+	case []string:
+		return r
+	case []interface{}:
+		required := make([]string, 0, len(r))
+		for _, v := range r {
+			if s, ok := v.(string); ok {
+				required = append(required, s)
+			}
+		}
+		return required
+	}
+	return nil
+}
+
+func jsonValueHasType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// extractJSON はLLMの出力から JSON 部分を取り出します。```json のコードブロックで囲まれている場合は中身を返します。
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		if idx := strings.LastIndex(text, "```"); idx >= 0 {
+			text = text[:idx]
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// schemaToGenai は JSON Schema を Gemini の ResponseSchema / FunctionDeclaration で使う *genai.Schema に変換します。
+func schemaToGenai(schema map[string]interface{}) *genai.Schema {
+	if schema == nil {
+		return nil
+	}
+	s := &genai.Schema{}
+	for _, typ := range schemaTypes(schema) {
+		switch typ {
+		case "object":
+			s.Type = genai.TypeObject
+		case "array":
+			s.Type = genai.TypeArray
+		case "string":
+			s.Type = genai.TypeString
+		case "number":
+			s.Type = genai.TypeNumber
+		case "integer":
+			s.Type = genai.TypeInteger
+		case "boolean":
+			s.Type = genai.TypeBoolean
+		case "null":
+			s.Nullable = true
+		}
+	}
+	if nullable, ok := schema["nullable"].(bool); ok && nullable {
+		s.Nullable = true
+	}
+	s.Description, _ = schema["description"].(string)
+	s.Format, _ = schema["format"].(string)
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		for _, v := range enum {
+			s.Enum = append(s.Enum, fmt.Sprint(v))
+		}
+	} else if enum, ok := schema["enum"].([]string); ok {
+		s.Enum = append(s.Enum, enum...)
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		s.Properties = make(map[string]*genai.Schema, len(properties))
+		for name, prop := range properties {
+			if propSchema, ok := prop.(map[string]interface{}); ok {
+				s.Properties[name] = schemaToGenai(propSchema)
+			}
+		}
+	}
+	s.Required = schemaRequired(schema)
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		s.Items = schemaToGenai(items)
+	}
+	return s
+}
+
+// normalizeSchema は Go のリテラルで書かれた schema ([]string など) を JSON を経由して
+// json.Unmarshal と同じ形 ([]interface{} など) に揃えます。
+func normalizeSchema(schema map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("スキーマのJSON変換に失敗: %w", err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("スキーマのJSON解析に失敗: %w", err)
+	}
+	return normalized, nil
+}
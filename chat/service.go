@@ -1,5 +1,7 @@
 package chat
 
+import "github.com/eraiza0816/llm-discord/history"
+
 // ChatParams はチャット処理に必要なパラメータをカプセル化します。
 type ChatParams struct {
 	UserID    string
@@ -9,13 +11,45 @@ type ChatParams struct {
 	Timestamp string
 	Prompt    string
 	IsBot     bool
+	Tools     []Tool // このリクエストでLLMが呼び出せるツール (Discord の操作など)。Gemini と Anthropic で使用
+	// ParentChannelID はスレッドの場合の親チャンネルのIDです。gemini_tools の設定を親チャンネルからスレッドにも適用し、チャンネルの既定のペルソナを探すのに使います。
+	ParentChannelID string
+	// SharedThread はスレッドの参加者全員で1つの履歴を共有する会話であることを表します (shared_threads)。
+	// 履歴は history.SharedUserID をキーに保存され、発言者の名前として DisplayName (無ければ Username) が残ります。
+	SharedThread bool
+	// ReplyChain は返信の連鎖から組み立てた会話 (古い順) です。空でない場合、保存されている履歴と要約の代わりに使います。
+	ReplyChain []history.HistoryMessage
+	// ChannelContext はメンションされたチャンネルの最近の会話です。空でない場合、システムプロンプトの後ろに加えます。
+	ChannelContext string
+
+	// システムプロンプトのテンプレートに渡す情報。取得できない場合は空のままで構いません
+	DisplayName string
+	GuildName   string
+	ChannelName string
+	ThreadTitle string
 }
 
 // ChatResponse はチャット処理の結果をカプセル化します。
 type ChatResponse struct {
-	Text      string
-	ElapsedMs float64
-	ModelName string
+	Text            string
+	ElapsedMs       float64
+	ModelName       string
+	Reasoning       string             // 推論モデルの思考過程。Text と履歴には含めない
+	ReasoningTokens int                // Reasoning のトークン数 (APIが返さない場合は推定値)
+	InputTokens     int                // APIが返した入力トークン数 (不明な場合は 0)
+	OutputTokens    int                // APIが返した出力トークン数 (不明な場合は 0)
+	Incomplete      bool               // キャンセルまたは期限切れにより、生成が途中で止まった応答
+	StopErr         error              // Incomplete の場合に生成を止めたエラー (プロバイダの期限切れか、呼び出し元によるキャンセルかの区別に使う)
+	Grounding       *history.Grounding // Google 検索によるグラウンディングの出典 (使用しなかった場合は nil)
+	Files           []ResponseFile     // 応答に添付するファイル (本文に収まらないコードの実行結果など)
+	PersonaName     string             // 応答に使ったペルソナの表示名 (ペルソナを使わなかった場合は空)
+	PersonaIcon     string             // 応答に使ったペルソナのアイコンのURL (未設定の場合は空)
+}
+
+// ResponseFile は応答に添付するテキストファイルです。
+type ResponseFile struct {
+	Name    string
+	Content string
 }
 
 // LLMProvider はLLMプロバイダを表します。
@@ -25,14 +59,15 @@ const (
 	ProviderGemini LLMProvider = iota
 	ProviderOllama
 	ProviderOpenAI
+	ProviderAnthropic
 )
 
 // ModelSelection はLLM選択結果を表します。
 type ModelSelection struct {
-	Provider  LLMProvider
-	OllamaCfg OllamaConfig // ProviderOllama の場合のみ有効
-	OpenAICfg OpenAIConfig // ProviderOpenAI の場合のみ有効
-	GeminiModelName string // ProviderGemini の場合のモデル名
+	Provider        LLMProvider
+	OllamaCfg       OllamaConfig // ProviderOllama の場合のみ有効
+	OpenAICfg       OpenAIConfig // ProviderOpenAI の場合のみ有効
+	GeminiModelName string       // ProviderGemini の場合のモデル名
 }
 
 // OpenAIConfig はOpenAI互換APIの設定を表します。
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/eraiza0816/llm-discord/history"
+)
+
+// speakerContextKey は共有スレッドでの発言者の表示名を context に持たせるキーです。
+type speakerContextKey struct{}
+
+// withSpeaker は共有スレッドでの今回の発言者の表示名を ctx に付けます。
+// 各プロバイダが履歴を保存する際に、ユーザーの発言に名前を残すために使います。
+func withSpeaker(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, speakerContextKey{}, name)
+}
+
+// speakerFrom は withSpeaker で付けた表示名を返します。個別の会話では空文字列です。
+func speakerFrom(ctx context.Context) string {
+	name, _ := ctx.Value(speakerContextKey{}).(string)
+	return name
+}
+
+// userHistoryMessage は今回のユーザーの発言を、履歴に保存する形にします。
+func userHistoryMessage(ctx context.Context, message string) history.HistoryMessage {
+	return history.HistoryMessage{Role: "user", Content: message, Name: speakerFrom(ctx)}
+}
+
+// sharedSpeaker は共有スレッドの履歴に残す発言者の名前を返します。
+func sharedSpeaker(params ChatParams) string {
+	if params.DisplayName != "" {
+		return params.DisplayName
+	}
+	if params.Username != "" {
+		return params.Username
+	}
+	return params.UserID
+}
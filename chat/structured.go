@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// maxStructuredAttempts は GetStructured が検証エラーを返して再生成させる回数の上限です (初回を含む)。
+const maxStructuredAttempts = 3
+
+// GetStructured は prompt に対する応答を、schema (JSON Schema) に適合する JSON として返します。
+// 各プロバイダの構造化出力機能 (Gemini の ResponseSchema、OpenAI の response_format、Ollama の format) を使い、
+// 返ってきた JSON がスキーマに適合しない場合は検証エラーを添えて再生成させます。履歴には保存しません。
+func (c *Chat) GetStructured(ctx context.Context, prompt string, schema map[string]interface{}) (json.RawMessage, error) {
+	return c.getStructured(ctx, c.modelConfig, prompt, schema)
+}
+
+// getStructured は modelCfg で有効なプロバイダを使う GetStructured です。
+func (c *Chat) getStructured(ctx context.Context, modelCfg *loader.ModelConfig, prompt string, schema map[string]interface{}) (json.RawMessage, error) {
+	normalized, err := normalizeSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := c.structuredProvider(modelCfg, normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	input := prompt
+	var lastErr error
+	for attempt := 1; attempt <= maxStructuredAttempts; attempt++ {
+		attemptCtx, cancel := c.providerContext(ctx, provider.Name())
+		resp, err := provider.Invoke(attemptCtx, input)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("%s での構造化出力の生成に失敗: %w", provider.Name(), err)
+		}
+
+		output := extractJSON(resp.Text)
+		var value interface{}
+		if err := json.Unmarshal([]byte(output), &value); err != nil {
+			lastErr = fmt.Errorf("JSONとして解析できません: %w", err)
+		} else if err := validateJSONSchema(value, normalized); err != nil {
+			lastErr = err
+		} else {
+			return json.RawMessage(output), nil
+		}
+
+		log.Printf("構造化出力の検証に失敗しました (%d/%d, %s): %v", attempt, maxStructuredAttempts, provider.Name(), lastErr)
+		input = fmt.Sprintf("%s\n\n前回の出力はJSONスキーマの検証に失敗しました。\nエラー: %v\n前回の出力:\n%s\n\nエラーを修正し、スキーマに適合するJSONのみを出力してください。", prompt, lastErr, output)
+	}
+	return nil, fmt.Errorf("構造化出力がスキーマに適合しませんでした (%d回試行): %w", maxStructuredAttempts, lastErr)
+}
+
+// structuredProvider は modelCfg で有効なプロバイダを、JSON出力を強制する設定で作成します。
+func (c *Chat) structuredProvider(modelCfg *loader.ModelConfig, schema map[string]interface{}) (ChatProvider, error) {
+	switch {
+	case modelCfg.Ollama.Enabled:
+		p := newOllamaProvider(modelCfg.Ollama)
+		p.format = schema
+		return p, nil
+	case modelCfg.OpenAI.Enabled:
+		p := newOpenAIProvider(modelCfg.OpenAI)
+		p.responseFormat = &openaiResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &openaiJSONSchemaSpec{Name: "response", Schema: schema},
+		}
+		return p, nil
+	case modelCfg.Anthropic.Enabled:
+		// Messages API には JSON モードが無いため、システムプロンプトでスキーマを指示し、検証と再生成で担保する
+		schemaJSON, err := json.Marshal(schema)
+		if err != nil {
+			return nil, fmt.Errorf("スキーマのJSON変換に失敗: %w", err)
+		}
+		return &systemPromptProvider{
+			provider: newAnthropicProvider(modelCfg.Anthropic, c.config.AnthropicAPIKey, nil),
+			system:   "次のJSON Schemaに適合するJSONのみを出力してください。説明文やコードブロックは不要です。\n" + string(schemaJSON),
+		}, nil
+	default:
+		if c.genaiClient == nil {
+			return nil, fmt.Errorf("Geminiクライアントが初期化されていません")
+		}
+		p := newGeminiProvider(c.genaiClient, modelCfg.ModelName)
+		p.model.ResponseMIMEType = "application/json"
+		p.model.ResponseSchema = schemaToGenai(schema)
+		return p, nil
+	}
+}
+
+// systemPromptProvider は Anthropic の呼び出しに固定のシステムプロンプトを付与します。
+type systemPromptProvider struct {
+	provider *anthropicProvider
+	system   string
+}
+
+func (p *systemPromptProvider) Name() string { return p.provider.Name() }
+
+func (p *systemPromptProvider) Invoke(ctx context.Context, fullInput string) (*ChatResponse, error) {
+	return p.provider.invokePrompt(ctx, Prompt{System: strings.TrimSpace(p.system), Message: fullInput})
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
+)
+
+var testClassificationSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"label":      map[string]interface{}{"type": "string", "enum": []string{"question", "chat"}},
+		"confidence": map[string]interface{}{"type": "number"},
+		"tags":       map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+	"required":             []string{"label", "confidence"},
+	"additionalProperties": false,
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema, err := normalizeSchema(testClassificationSchema)
+	if err != nil {
+		t.Fatalf("normalizeSchema failed: %v", err)
+	}
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"valid", `{"label":"chat","confidence":0.9,"tags":["a"]}`, ""},
+		{"missing required", `{"label":"chat"}`, "confidence"},
+		{"wrong type", `{"label":"chat","confidence":"high"}`, "$.confidence"},
+		{"enum", `{"label":"other","confidence":1}`, "$.label"},
+		{"array items", `{"label":"chat","confidence":1,"tags":[1]}`, "$.tags[0]"},
+		{"additional property", `{"label":"chat","confidence":1,"extra":true}`, "extra"},
+		{"not an object", `[1,2]`, "object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.input), &value); err != nil {
+				t.Fatalf("bad test input: %v", err)
+			}
+			err := validateJSONSchema(value, schema)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSchemaToGenai(t *testing.T) {
+	s := schemaToGenai(testClassificationSchema)
+	if s.Type != genai.TypeObject || len(s.Required) != 2 {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+	if label := s.Properties["label"]; label.Type != genai.TypeString || len(label.Enum) != 2 {
+		t.Errorf("unexpected label schema: %+v", label)
+	}
+	if tags := s.Properties["tags"]; tags.Type != genai.TypeArray || tags.Items.Type != genai.TypeString {
+		t.Errorf("unexpected tags schema: %+v", tags)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	if got := extractJSON("```json\n{\"a\":1}\n```"); got != `{"a":1}` {
+		t.Errorf("unexpected %q", got)
+	}
+	if got := extractJSON(" {\"a\":1} "); got != `{"a":1}` {
+		t.Errorf("unexpected %q", got)
+	}
+}
+
+func TestGetStructured(t *testing.T) {
+	t.Run("ollama retries with validation error", func(t *testing.T) {
+		var prompts []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			if _, ok := payload["format"].(map[string]interface{}); !ok {
+				t.Errorf("expected schema in format, got %v", payload["format"])
+			}
+			prompts = append(prompts, payload["prompt"].(string))
+			answer := `{"label":"unknown","confidence":0.5}`
+			if len(prompts) > 1 {
+				answer = `{"label":"question","confidence":0.5}`
+			}
+			b, _ := json.Marshal(map[string]interface{}{"response": answer, "done": true})
+			fmt.Fprintf(w, "%s\n", b)
+		}))
+		defer server.Close()
+
+		c := &Chat{modelConfig: &loader.ModelConfig{Ollama: loader.OllamaConfig{Enabled: true, APIEndpoint: server.URL, ModelName: "fake"}}}
+		raw, err := c.GetStructured(context.Background(), "分類して", testClassificationSchema)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(raw) != `{"label":"question","confidence":0.5}` {
+			t.Errorf("unexpected result %s", raw)
+		}
+		if len(prompts) != 2 || !strings.Contains(prompts[1], "$.label") || !strings.HasPrefix(prompts[1], "分類して") {
+			t.Errorf("expected retry prompt with validation error, got %q", prompts)
+		}
+	})
+
+	t.Run("openai sends json_schema response_format", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req openaiChatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" || req.ResponseFormat.JSONSchema.Schema["type"] != "object" {
+				t.Errorf("unexpected response_format: %+v", req.ResponseFormat)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"{\\\"label\\\":\\\"chat\\\",\\\"confidence\\\":1}\"}}]}\n\ndata: [DONE]\n\n")
+		}))
+		defer server.Close()
+
+		c := &Chat{modelConfig: &loader.ModelConfig{OpenAI: loader.OpenAIConfig{Enabled: true, APIEndpoint: server.URL, ModelName: "fake"}}}
+		raw, err := c.GetStructured(context.Background(), "分類して", testClassificationSchema)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(raw) != `{"label":"chat","confidence":1}` {
+			t.Errorf("unexpected result %s", raw)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"fake\"}}\n\n"+
+				"data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n"+
+				"data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"not json\"}}\n\n"+
+				"data: {\"type\":\"message_stop\"}\n\n")
+		}))
+		defer server.Close()
+
+		c := &Chat{
+			config:      &config.Config{},
+			modelConfig: &loader.ModelConfig{Anthropic: loader.AnthropicConfig{Enabled: true, APIEndpoint: server.URL, ModelName: "fake"}},
+		}
+		if _, err := c.GetStructured(context.Background(), "分類して", testClassificationSchema); err == nil {
+			t.Fatal("expected error")
+		}
+		if calls != maxStructuredAttempts {
+			t.Errorf("expected %d attempts, got %d", maxStructuredAttempts, calls)
+		}
+	})
+}
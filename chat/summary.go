@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// summaryTimeout はバックグラウンドで要約を更新する際の期限です。
+const summaryTimeout = 2 * time.Minute
+
+// ErrSummaryUnavailable は要約が無効、または履歴の保存先が要約に対応していない場合に返されます。
+var ErrSummaryUnavailable = errors.New("会話の要約は有効になっていません")
+
+// summaryStore は要約の保存先を返します。summary が無効な場合、または履歴の保存先が要約に対応していない場合は nil です。
+func (c *Chat) summaryStore(modelCfg *loader.ModelConfig) history.SummaryStore {
+	if !modelCfg.Summary.Enabled {
+		return nil
+	}
+	store, _ := c.historyMgr.(history.SummaryStore)
+	return store
+}
+
+// withSummary は要約をシステムプロンプトの後ろ (会話履歴より前) に加えた Prompt を返します。
+func withSummary(prompt Prompt, summary string) Prompt {
+	if summary != "" {
+		prompt.System += "\nこれまでの会話の要約:\n" + prompt.fence("summary", summary) + "\n"
+	}
+	return prompt
+}
+
+// scheduleSummaryUpdate は、プロンプト用に読み込んだ履歴 (overflow と recent) のうち、プロンプトに含めた最新 kept 件より前で
+// まだ要約に含まれていないメッセージを、バックグラウンドで要約に追加します。
+// overflow は max_history_size を超えて Get では返らなくなった古い履歴です。
+// 同じ会話の更新が実行中の場合は何もしません (次の発言で改めて追加されます)。
+func (c *Chat) scheduleSummaryUpdate(userID, threadID string, modelCfg *loader.ModelConfig, store history.SummaryStore, current history.Summary, overflow, recent []history.HistoryMessage, kept int) {
+	var pending []history.HistoryMessage
+	for _, msg := range slices.Concat(overflow, recent[:max(len(recent)-kept, 0)]) {
+		if !current.Covers(msg) {
+			pending = append(pending, msg)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	key := userID + "/" + threadID
+	if _, running := c.summarizing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer c.summarizing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+		defer cancel()
+		if _, err := c.updateSummary(ctx, userID, threadID, modelCfg, store, current.Text, pending); err != nil {
+			errorLogger.Printf("ユーザー %s のスレッド %s の要約の更新に失敗しました: %v", userID, threadID, err)
+		}
+	}()
+}
+
+// updateSummary は previous に messages の内容を加えた要約を生成して保存します。
+func (c *Chat) updateSummary(ctx context.Context, userID, threadID string, modelCfg *loader.ModelConfig, store history.SummaryStore, previous string, messages []history.HistoryMessage) (history.Summary, error) {
+	text, err := c.summarize(ctx, modelCfg, previous, messages)
+	if err != nil {
+		return history.Summary{}, err
+	}
+	summary := history.Summary{Text: text, CoveredUntil: messages[len(messages)-1].CreatedAt, UpdatedAt: time.Now()}
+	if err := store.SaveSummary(userID, threadID, summary); err != nil {
+		return history.Summary{}, err
+	}
+	log.Printf("ユーザー %s のスレッド %s の要約を更新しました (%d 件を追加)", userID, threadID, len(messages))
+	return summary, nil
+}
+
+// summarize は summary 設定のモデルで、previous と messages をまとめた要約を生成します。
+func (c *Chat) summarize(ctx context.Context, modelCfg *loader.ModelConfig, previous string, messages []history.HistoryMessage) (string, error) {
+	provider, err := c.summaryProvider(modelCfg)
+	if err != nil {
+		return "", err
+	}
+
+	// 要約は以降の全てのプロンプトに入るため、会話に書かれた指示が要約に紛れ込まないよう応答と同じくタグで囲む
+	p := Prompt{boundary: newBoundary()}
+	var input strings.Builder
+	fmt.Fprintf(&input, "あなたは会話の記録係です。以下の会話を、今後の会話で参照するための要約にしてください。\n"+
+		"話題、決まったこと、ユーザーの好みや依頼、未解決の事項を優先し、%d文字以内の日本語の箇条書きで出力してください。要約以外は出力しないでください。\n", modelCfg.Summary.Limit())
+	input.WriteString(p.fenceNotice() + "\n")
+	if previous != "" {
+		fmt.Fprintf(&input, "これまでの要約:\n%s\n\n続きの会話:\n", p.fence("summary", previous))
+	} else {
+		input.WriteString("会話:\n")
+	}
+	for _, msg := range messages {
+		input.WriteString(p.historyLine(msg) + "\n")
+	}
+
+	resp, err := provider.Invoke(ctx, input.String())
+	if err != nil {
+		return "", fmt.Errorf("%s での要約の生成に失敗: %w", provider.Name(), err)
+	}
+	applyReasoning(resp)
+	text := strings.TrimSpace(resp.Text)
+	if text == "" {
+		return "", fmt.Errorf("%s が空の要約を返しました", provider.Name())
+	}
+	if runes := []rune(text); len(runes) > modelCfg.Summary.Limit() {
+		text = string(runes[:modelCfg.Summary.Limit()])
+	}
+	return text, nil
+}
+
+// summaryProvider は summary 設定のプロバイダとモデルで ChatProvider を作成します。
+func (c *Chat) summaryProvider(modelCfg *loader.ModelConfig) (ChatProvider, error) {
+	cfg := modelCfg
+	if modelCfg.Summary.Provider != "" || modelCfg.Summary.ModelName != "" {
+		overridden, err := modelCfg.WithProviderOverride(modelCfg.Summary.Provider, modelCfg.Summary.ModelName)
+		if err != nil {
+			return nil, fmt.Errorf("summary: %w", err)
+		}
+		cfg = overridden
+	}
+	switch provider, modelName := cfg.ActiveModel(); provider {
+	case loader.ProviderOllama:
+		return newOllamaProvider(cfg.Ollama), nil
+	case loader.ProviderOpenAI:
+		return newOpenAIProvider(cfg.OpenAI), nil
+	case loader.ProviderAnthropic:
+		return newAnthropicProvider(cfg.Anthropic, c.config.AnthropicAPIKey, nil), nil
+	default:
+		if c.genaiClient == nil {
+			return nil, fmt.Errorf("Geminiクライアントが初期化されていません")
+		}
+		return newGeminiProvider(c.genaiClient, modelName), nil
+	}
+}
+
+// GetSummary は userID と threadID の会話の要約を返します。
+func (c *Chat) GetSummary(userID, threadID string) (history.Summary, bool, error) {
+	store := c.summaryStore(c.modelConfig)
+	if store == nil {
+		return history.Summary{}, false, ErrSummaryUnavailable
+	}
+	return store.GetSummary(userID, threadID)
+}
+
+// RegenerateSummary は保存されている会話履歴の全体から、要約を作り直します。
+// 要約済みで max_history_size より古い履歴は既に削除されているため、作り直した要約には含まれません。
+func (c *Chat) RegenerateSummary(ctx context.Context, userID, threadID string) (history.Summary, error) {
+	store := c.summaryStore(c.modelConfig)
+	if store == nil {
+		return history.Summary{}, ErrSummaryUnavailable
+	}
+	recent, overflow, err := store.GetWithOverflow(userID, threadID)
+	if err != nil {
+		return history.Summary{}, fmt.Errorf("履歴の取得に失敗: %w", err)
+	}
+	messages := slices.Concat(overflow, recent)
+	if len(messages) == 0 {
+		return history.Summary{}, fmt.Errorf("要約する会話がありません")
+	}
+	return c.updateSummary(ctx, userID, threadID, c.modelConfig, store, "", messages)
+}
@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// maxToolRounds はツール呼び出しとLLMへの再問い合わせを繰り返す最大回数です。
+const maxToolRounds = 5
+
+// toolsUnavailableNotice は、ツールを渡せないプロバイダで応答する場合にシステムプロンプトへ加える文です。
+// ツールが必要な依頼を黙って無視せず、対応できないことをユーザーに伝えさせます。
+const toolsUnavailableNotice = "この応答ではツール (チャンネルのメッセージの取得や Web ページの取得など) を使えません。" +
+	"それらが必要な依頼には、この環境では対応できないことを伝えてください。\n"
+
+// Tool はLLMから呼び出せる関数を表します。Parameters は type: object の JSON Schema です。
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+	Execute     func(ctx context.Context, args map[string]interface{}) (string, error)
+}
+
+// toolNames は tools の名前を返します。
+func toolNames(tools []Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// runTool は name のツールを実行し、LLMに返す結果と、それがエラーかどうかを返します。
+func runTool(ctx context.Context, tools []Tool, name string, args map[string]interface{}) (string, bool) {
+	for _, tool := range tools {
+		if tool.Name != name {
+			continue
+		}
+		result, err := tool.Execute(ctx, args)
+		if err != nil {
+			log.Printf("ツール %s の実行に失敗しました: %v", name, err)
+			return fmt.Sprintf("ツールの実行に失敗しました: %v", err), true
+		}
+		return result, false
+	}
+	log.Printf("Unknown function call: %s", name)
+	return fmt.Sprintf("不明な関数呼び出し: %s", name), true
+}
@@ -0,0 +1,347 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"golang.org/x/net/html"
+)
+
+// maxWebTextLength はLLMに返すページ本文の最大文字数です。
+const maxWebTextLength = 8000
+
+var errBlockedAddress = errors.New("プライベートネットワークやループバックのアドレスにはアクセスできません")
+
+// blockedNetworks は net.IP のメソッドで判定できない、外部から到達できない (または到達させるべきでない) 範囲です。
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // 「このネットワーク」
+	"100.64.0.0/10", // キャリアグレードNAT
+	"192.0.0.0/24",  // IETF プロトコル割り当て
+	"198.18.0.0/15", // ベンチマーク用
+	"240.0.0.0/4",   // 予約済み
+	"64:ff9b::/96",  // NAT64 (IPv4 アドレスを埋め込める)
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPublicIP は ip がインターネット上の到達可能なアドレスかを返します。
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webFetcher はLLMの依頼でWebページを取得し、読みやすいテキストに変換します。
+// 接続先は接続の直前に名前解決した結果で検査するため、リダイレクトやDNSの差し替えでも内部ネットワークには届きません。
+type webFetcher struct {
+	client   *http.Client
+	cache    history.URLCache // nil の場合はキャッシュしない
+	cacheTTL time.Duration
+	maxBytes int64
+
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newWebFetcher(cfg loader.WebFetchConfig, cache history.URLCache) *webFetcher {
+	maxBytes, timeout, maxRedirects := cfg.Limits()
+	dialer := &net.Dialer{Timeout: timeout}
+	f := &webFetcher{
+		cache:    cache,
+		cacheTTL: cfg.CacheTTL(),
+		maxBytes: maxBytes,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		dial: dialer.DialContext,
+	}
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // 環境変数のプロキシ経由だと接続先の検査を回避できるため使わない
+			DialContext:           f.dialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("リダイレクトが %d 回を超えました", maxRedirects)
+			}
+			return checkWebURL(req.URL)
+		},
+	}
+	return f
+}
+
+// checkWebURL は u が取得してよい http(s) の URL かを確認します。
+func checkWebURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("http または https の URL のみ取得できます: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("URL にホスト名がありません")
+	}
+	if u.User != nil {
+		return fmt.Errorf("認証情報を含む URL は取得できません")
+	}
+	return nil
+}
+
+// dialContext は addr のホストを名前解決し、公開アドレスであることを確認してから、そのアドレスに接続します。
+func (f *webFetcher) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = f.lookupIP(ctx, host); err != nil {
+			return nil, fmt.Errorf("%s の名前解決に失敗しました: %w", host, err)
+		}
+	}
+	// 解決結果に1つでも内部アドレスが含まれるホストは、接続先を選べる余地を残さず拒否する
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			log.Printf("Web取得: %s (%s) は公開アドレスではないため接続を拒否しました", host, ip)
+			return nil, fmt.Errorf("%s: %w", host, errBlockedAddress)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s のアドレスが見つかりません", host)
+	}
+	return f.dial(ctx, network, net.JoinHostPort(ips[0].String(), port))
+}
+
+// mentionsURL は message に http(s) の URL が含まれるかを返します。
+func mentionsURL(message string) bool {
+	lower := strings.ToLower(message)
+	return strings.Contains(lower, "http://") || strings.Contains(lower, "https://")
+}
+
+// Tool は fetcher を fetch_url ツールとして返します。
+func (f *webFetcher) Tool() Tool {
+	return Tool{
+		Name:        "fetch_url",
+		Description: "Webページを取得し、本文をテキストで返します。ユーザーがURLを示して内容の確認や要約を求めたときに使います。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"url": map[string]interface{}{"type": "string", "description": "取得するページの http(s) URL"},
+			},
+			"required": []string{"url"},
+		},
+		Execute: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			rawURL, _ := args["url"].(string)
+			return f.Fetch(ctx, strings.TrimSpace(rawURL))
+		},
+	}
+}
+
+// Fetch は rawURL のページを取得してテキストに変換します。キャッシュが有効な間は再取得しません。
+func (f *webFetcher) Fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("URL の解析に失敗しました: %w", err)
+	}
+	if err := checkWebURL(u); err != nil {
+		return "", err
+	}
+	if f.cache != nil {
+		if content, ok, err := f.cache.GetURLContent(u.String(), f.cacheTTL); err != nil {
+			log.Printf("Web取得: キャッシュの読み込みに失敗しました: %v", err)
+		} else if ok {
+			return content, nil
+		}
+	}
+
+	content, err := f.fetch(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	if f.cache != nil {
+		if err := f.cache.PutURLContent(u.String(), content); err != nil {
+			log.Printf("Web取得: キャッシュの保存に失敗しました: %v", err)
+		}
+	}
+	return content, nil
+}
+
+func (f *webFetcher) fetch(ctx context.Context, u *url.URL) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	}
+	req.Header.Set("User-Agent", "llm-discord-bot (+fetch_url)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ページの取得に失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ページの取得に失敗しました (ステータスコード: %d)", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("本文の読み込みに失敗しました: %w", err)
+	}
+	truncated := int64(len(body)) > f.maxBytes
+	if truncated {
+		body = body[:f.maxBytes]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var title, text string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		title, text = htmlToText(string(body))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "":
+		text = strings.TrimSpace(string(body))
+	default:
+		return "", fmt.Errorf("テキストではないコンテンツは読み取れません (Content-Type: %s)", mediaType)
+	}
+
+	if runes := []rune(text); len(runes) > maxWebTextLength {
+		text = string(runes[:maxWebTextLength])
+		truncated = true
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s\n", resp.Request.URL)
+	if title != "" {
+		fmt.Fprintf(&b, "タイトル: %s\n", title)
+	}
+	b.WriteString("\n")
+	b.WriteString(text)
+	if truncated {
+		b.WriteString("\n\n(長いため以降は省略されています)")
+	}
+	return b.String(), nil
+}
+
+// skippedElements は本文として読む価値のない要素です。
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "head": true, "nav": true, "footer": true, "form": true,
+}
+
+// blockElements は前後で改行する要素です。
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "section": true, "article": true, "main": true,
+	"header": true, "aside": true, "blockquote": true, "pre": true, "table": true, "tr": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// htmlToText は HTML からタイトルと、スクリプトや装飾を除いた本文を取り出します。
+func htmlToText(src string) (title, text string) {
+	tokenizer := html.NewTokenizer(strings.NewReader(src))
+	var b strings.Builder
+	skipDepth := 0
+	inTitle := false
+	for {
+		switch tokenType := tokenizer.Next(); tokenType {
+		case html.ErrorToken:
+			return strings.TrimSpace(title), collapseBlankLines(b.String())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = tokenType == html.StartTagToken
+				continue
+			}
+			if skippedElements[tag] {
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+				continue
+			}
+			if skipDepth == 0 && blockElements[tag] {
+				b.WriteString("\n")
+				if tag == "li" {
+					b.WriteString("- ")
+				} else if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+					b.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+				}
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = false
+				continue
+			}
+			if skippedElements[tag] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth == 0 && blockElements[tag] {
+				b.WriteString("\n")
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if words := strings.Fields(string(tokenizer.Text())); len(words) > 0 {
+				b.WriteString(strings.Join(words, " "))
+				b.WriteString(" ")
+			}
+		}
+	}
+}
+
+// collapseBlankLines は各行の前後の空白を取り除き、連続する空行を1行にまとめます。
+func collapseBlankLines(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(lines) > 0 {
+				blank = true
+			}
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -6,49 +6,15 @@ import (
 	"os"
 	"strings"
 
-	"encoding/json"
 	"github.com/eraiza0816/llm-discord/loader"
 	"github.com/joho/godotenv"
-	"log"
-	"sync"
 )
 
-// CustomPromptConfig はユーザーごとのカスタムプロンプト設定を表します。
-type CustomPromptConfig struct {
-	Prompts map[string]string `json:"prompts"`
-}
-
-var customPromptMutex sync.Mutex
-
 type Config struct {
 	DiscordBotToken string
 	GeminiAPIKey    string
+	AnthropicAPIKey string // 任意。anthropic.enabled の場合のみ必要
 	Model           *loader.ModelConfig
-	CustomModel     *CustomPromptConfig
-}
-
-func loadCustomPrompts(filePath string) (*CustomPromptConfig, error) {
-	customPromptMutex.Lock()
-	defer customPromptMutex.Unlock()
-
-	config := &CustomPromptConfig{Prompts: make(map[string]string)}
-
-	if _, err := os.Stat(filePath); err == nil {
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("カスタムプロンプトファイル '%s' の読み込みに失敗しました: %w", filePath, err)
-		}
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, config); err != nil {
-				log.Printf("カスタムプロンプトファイル '%s' のJSON解析に失敗しました: %v。空の設定として扱います。", filePath, err)
-				config.Prompts = make(map[string]string)
-			}
-		}
-	} else if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("カスタムプロンプトファイル '%s' の状態確認中にエラーが発生しました: %w", filePath, err)
-	}
-
-	return config, nil
 }
 
 func LoadConfig() (*Config, error) {
@@ -76,15 +42,15 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("model.json の読み込みに失敗しました: %w", err)
 	}
 
-	customModelCfg, err := loadCustomPrompts("json/custom_model.json")
-	if err != nil {
-		return nil, fmt.Errorf("custom_model.json の読み込みに失敗しました: %w", err)
+	anthropicAPIKey := os.Getenv("ANTHROPIC_API_KEY")
+	if modelCfg.Anthropic.Enabled && anthropicAPIKey == "" {
+		return nil, errors.New("anthropic.enabled が true ですが、環境変数 ANTHROPIC_API_KEY が設定されていません")
 	}
 
 	return &Config{
 		DiscordBotToken: token,
 		GeminiAPIKey:    geminiAPIKey,
+		AnthropicAPIKey: anthropicAPIKey,
 		Model:           modelCfg,
-		CustomModel:     customModelCfg,
 	}, nil
 }
@@ -2,6 +2,7 @@ package discord
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/eraiza0816/llm-discord/chat"
 	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/loader"
 )
 
 func chatCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, chatSvc chat.Service, threadID string, cfg *config.Config) {
@@ -27,15 +29,18 @@ func chatCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, ch
 	var username string
 	var userID string
 	var avatarURL string
+	var userDisplayName string
 
 	if i.Member != nil && i.Member.User != nil {
 		username = i.Member.User.Username
 		userID = i.Member.User.ID
 		avatarURL = i.Member.User.AvatarURL("")
+		userDisplayName = displayName(i.Member, i.Member.User)
 	} else if i.User != nil {
 		username = i.User.Username
 		userID = i.User.ID
 		avatarURL = i.User.AvatarURL("")
+		userDisplayName = displayName(nil, i.User)
 	} else {
 		log.Println("chatCommandHandler: User information not found in interaction")
 		sendErrorResponse(s, i, fmt.Errorf("ユーザー情報が取得できませんでした。"))
@@ -54,13 +59,47 @@ func chatCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, ch
 		},
 	})
 
-	// GetResponse の最後の引数に cfg.Model.Prompts["default"] を渡す
-	// userPrompt のロジックは chat.GetResponse 内に移動したため削除
-	response, elapsed, modelName, err := chatSvc.GetResponse(context.Background(), userID, threadID, username, message, timestamp, cfg.Model.Prompts["default"], false)
+	ctx, done := generations.start(context.Background(), i.ID, userID, modelCfg.Timeouts.Command("chat"))
+	defer done()
+	pending := "ちょっと待ってね！"
+	components := stopButton(i.ID)
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &pending,
+		Components: &components,
+	}); err != nil {
+		log.Printf("停止ボタンの表示に失敗しました: %v", err)
+	}
+
+	session := &discordgoSession{s}
+	pc := lookupPromptContext(session, i.GuildID, i.ChannelID)
+	resp, err := chatSvc.GetResponse(ctx, chat.ChatParams{
+		UserID:          userID,
+		ThreadID:        threadID,
+		Username:        username,
+		Message:         message,
+		Timestamp:       timestamp,
+		Prompt:          cfg.Model.Prompts["default"],
+		Tools:           discordTools(session, i.GuildID, i.ChannelID, userID, ""),
+		DisplayName:     userDisplayName,
+		GuildName:       pc.GuildName,
+		ChannelName:     pc.ChannelName,
+		ThreadTitle:     pc.ThreadTitle,
+		ParentChannelID: pc.ParentID,
+		SharedThread:    modelCfg.SharedThreads.Enabled(i.ChannelID, pc.ParentID),
+	})
 	if err != nil {
+		if chat.IsStopped(err) {
+			sendErrorResponse(s, i, errors.New(stoppedReason(ctx, err)))
+			return
+		}
+		if msg, ok := blockedErrorMessage(err); ok {
+			sendErrorResponse(s, i, errors.New(msg))
+			return
+		}
 		sendErrorResponse(s, i, fmt.Errorf("LLMからの応答取得中にエラーが発生しました: %w", err))
 		return
 	}
+	resp = markIncomplete(ctx, resp)
 
 	embedUser := &discordgo.MessageEmbed{
 		Author: &discordgo.MessageEmbedAuthor{
@@ -73,20 +112,50 @@ func chatCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, ch
 		Color: 0xfff9b7,
 	}
 
+	// ペルソナで応答した場合は、そのペルソナの名前とアイコンを表示する
+	botName, botIcon := modelCfg.Name, modelCfg.Icon
+	if resp.PersonaName != "" {
+		botName = resp.PersonaName
+		if resp.PersonaIcon != "" {
+			botIcon = resp.PersonaIcon
+		}
+	}
 	embedBot := &discordgo.MessageEmbed{
 		Author: &discordgo.MessageEmbedAuthor{
-			Name:    modelCfg.Name,
-			IconURL: modelCfg.Icon,
+			Name:    botName,
+			IconURL: botIcon,
 		},
-		Fields: SplitToEmbedFields(response),
+		Fields: SplitToEmbedFields(resp.Text),
 		Color:  0xa8ffee,
 		Footer: &discordgo.MessageEmbedFooter{
-			Text: fmt.Sprintf("%vms %s", elapsed, modelName),
+			Text: fmt.Sprintf("%vms %s", resp.ElapsedMs, resp.ModelName),
 		},
 	}
 
+	embeds := []*discordgo.MessageEmbed{embedUser}
+	if resp.Reasoning != "" {
+		switch modelCfg.Reasoning.Display {
+		case loader.ReasoningDisplaySpoiler:
+			const maxFieldLength = 1024
+			if spoiler := reasoningSpoiler(resp.Reasoning, resp.ReasoningTokens, maxFieldLength); spoiler != "" {
+				embedBot.Fields = append([]*discordgo.MessageEmbedField{{Value: spoiler}}, embedBot.Fields...)
+			}
+		case loader.ReasoningDisplayEmbed:
+			embeds = append(embeds, buildReasoningEmbed(resp.Reasoning, resp.ReasoningTokens))
+		}
+		embedBot.Footer.Text += fmt.Sprintf(" / 思考 %d tokens", resp.ReasoningTokens)
+	}
+	embeds = append(embeds, embedBot)
+	if resp.Grounding != nil {
+		embeds = append(embeds, buildGroundingEmbed(resp.Grounding))
+	}
+
+	content := ""
 	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Embeds: &[]*discordgo.MessageEmbed{embedUser, embedBot},
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &[]discordgo.MessageComponent{},
+		Files:      responseFiles(resp),
 	})
 	if err != nil {
 		log.Printf("InteractionResponseEdit error: %v", err)
@@ -1,6 +1,8 @@
 package discord
 
 import (
+	"strings"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/eraiza0816/llm-discord/chat"
 	"github.com/eraiza0816/llm-discord/config"
@@ -13,6 +15,17 @@ type CommandHandler interface {
 	Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error
 }
 
+// AutocompleteHandler is implemented by commands whose options offer autocomplete choices.
+type AutocompleteHandler interface {
+	Autocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) error
+}
+
+// ModalHandler is implemented by commands that open modals.
+// The modal's custom ID must start with the command name followed by ":" (e.g. "edit:set").
+type ModalHandler interface {
+	HandleModal(s *discordgo.Session, i *discordgo.InteractionCreate) error
+}
+
 // commandDispatcher stores registered command handlers and dispatches interactions.
 type commandDispatcher struct {
 	handlers map[string]CommandHandler
@@ -37,6 +50,25 @@ func (d *commandDispatcher) Dispatch(s *discordgo.Session, i *discordgo.Interact
 	return h.Handle(s, i)
 }
 
+// DispatchAutocomplete routes an autocomplete interaction to the command that owns the focused option.
+func (d *commandDispatcher) DispatchAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	h, ok := d.handlers[i.ApplicationCommandData().Name].(AutocompleteHandler)
+	if !ok {
+		return nil
+	}
+	return h.Autocomplete(s, i)
+}
+
+// DispatchModal routes a modal submit interaction to the command that opened the modal.
+func (d *commandDispatcher) DispatchModal(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	name, _, _ := strings.Cut(i.ModalSubmitData().CustomID, ":")
+	h, ok := d.handlers[name].(ModalHandler)
+	if !ok {
+		return nil
+	}
+	return h.HandleModal(s, i)
+}
+
 // chatCommand implements the /chat command.
 type chatCommand struct {
 	chatSvc chat.Service
@@ -84,13 +116,20 @@ func (c *aboutCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCrea
 
 // editCommand implements the /edit command.
 type editCommand struct {
-	cfg *config.Config
+	cfg     *config.Config
+	chatSvc chat.Service
+	store   history.CustomPromptStore // nil の場合はカスタムプロンプトを使えない
 }
 
 func (c *editCommand) Name() string { return "edit" }
 
 func (c *editCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
-	editCommandHandler(s, i, c.cfg)
+	editCommandHandler(s, i, c)
+	return nil
+}
+
+func (c *editCommand) HandleModal(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	editModalHandler(s, i, c)
 	return nil
 }
 
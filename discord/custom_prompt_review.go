@@ -0,0 +1,91 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/history"
+)
+
+// 管理用チャンネルの承認・却下ボタンの CustomID の接頭辞。後ろに変更の番号が付く。
+const (
+	approveCustomPromptCustomIDPrefix = "custom_prompt_approve:"
+	rejectCustomPromptCustomIDPrefix  = "custom_prompt_reject:"
+)
+
+// canReviewCustomPrompts はカスタムプロンプトの変更を承認・却下できるメンバー (サーバー管理権限を持つ) かを返します。
+func canReviewCustomPrompts(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&(discordgo.PermissionManageServer|discordgo.PermissionAdministrator) != 0
+}
+
+// requestCustomPromptReview は管理用チャンネル channelID に、承認待ちの変更 v の承認を依頼するメッセージを送ります。
+func requestCustomPromptReview(s *discordgo.Session, channelID string, v history.CustomPromptVersion) error {
+	_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{customPromptReviewEmbed(v)},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "承認", Style: discordgo.SuccessButton, CustomID: approveCustomPromptCustomIDPrefix + strconv.FormatInt(v.ID, 10)},
+				discordgo.Button{Label: "却下", Style: discordgo.DangerButton, CustomID: rejectCustomPromptCustomIDPrefix + strconv.FormatInt(v.ID, 10)},
+			}},
+		},
+	})
+	return err
+}
+
+// customPromptReviewEmbed は承認の依頼と、審査後の結果を表す埋め込みを作ります。
+func customPromptReviewEmbed(v history.CustomPromptVersion) *discordgo.MessageEmbed {
+	status := customPromptStatusLabel(v.Status)
+	color := 0xffcc4d
+	switch v.Status {
+	case history.CustomPromptApproved:
+		color = 0x77dd77
+	case history.CustomPromptRejected:
+		color = 0xff6961
+	}
+	if v.ReviewedBy != "" {
+		status += fmt.Sprintf(" (<@%s>)", v.ReviewedBy)
+	}
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("カスタムプロンプトの変更 #%d", v.ID),
+		Description: codeBlock(v.Prompt),
+		Color:       color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "ユーザー", Value: fmt.Sprintf("<@%s>", v.UserID), Inline: true},
+			{Name: "状態", Value: status, Inline: true},
+		},
+		Timestamp: v.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// customPromptReviewHandler は承認・却下ボタンの操作を処理し、依頼のメッセージを結果に書き換えます。
+func customPromptReviewHandler(s *discordgo.Session, i *discordgo.InteractionCreate, store history.CustomPromptStore, idText string, approve bool) {
+	if !canReviewCustomPrompts(i) {
+		sendEphemeralErrorResponse(s, i, errors.New("カスタムプロンプトの変更はサーバー管理権限を持つメンバーのみ審査できます。"))
+		return
+	}
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if store == nil || err != nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("不明な変更です: %s", idText))
+		return
+	}
+	v, err := store.ReviewCustomPrompt(id, approve, i.Member.User.ID)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	log.Printf("カスタムプロンプトの変更 #%d (UserID=%s) を審査しました: %s (ReviewerID=%s)", v.ID, v.UserID, v.Status, v.ReviewedBy)
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{customPromptReviewEmbed(v)},
+			Components: []discordgo.MessageComponent{},
+		},
+	}); err != nil {
+		log.Printf("審査ボタンへの応答に失敗しました: %v", err)
+	}
+}
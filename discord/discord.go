@@ -11,6 +11,34 @@ import (
 	"github.com/eraiza0816/llm-discord/history"
 )
 
+// adminPermissions は管理者向けコマンドの既定の実行権限です。
+var adminPermissions int64 = discordgo.PermissionAdministrator
+
+// minMemoryID は /memory forget で指定できる記憶の番号の最小値です。
+var minMemoryID float64 = 1
+
+// minCustomPromptVersion は /edit rollback で指定できる変更の番号の最小値です。
+var minCustomPromptVersion float64 = 1
+
+func ollamaModelOption(required bool) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "model",
+		Description: "モデル名 (例: gemma3:12b)",
+		Required:    required,
+	}
+}
+
+func personaNameOption(required bool) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionString,
+		Name:         "name",
+		Description:  "ペルソナ",
+		Required:     required,
+		Autocomplete: true,
+	}
+}
+
 func StartBot(cfg *config.Config) error {
 	log.Println("StartBot called")
 	if cfg == nil {
@@ -46,20 +74,70 @@ func StartBot(cfg *config.Config) error {
 			Name:        "reset",
 			Description: "あなたとのチャット履歴をリセット",
 		},
+		{
+			Name:        "summary",
+			Description: "このスレッドでのあなたとの会話の要約を表示",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "regenerate",
+					Description: "履歴から要約を作り直す",
+				},
+			},
+		},
 		{
 			Name:        "about",
 			Description: "このBotについて",
 		},
 		{
 			Name:        "edit",
-			Description: "カスタムテンプレートであなたの好みに編集",
+			Description: "あなたとの会話で使うシステムプロンプトを編集",
 			Options: []*discordgo.ApplicationCommandOption{
-				{
-					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        "set_custom_prompt",
-					Description: "カスタムテンプレートを入力(deleteで削除)",
-					Required:    true,
-				},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "set", Description: "エディタで設定中のカスタムプロンプトを編集 ({{.DisplayName}} などのテンプレートも使える)"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "reset", Description: "既定のプロンプトをエディタに入れて書き直す (そのまま送信すると既定に戻す)"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "delete", Description: "カスタムプロンプトを削除して既定のプロンプトに戻す"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show", Description: "設定中のカスタムプロンプトを表示"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "preview", Description: "プロンプトを展開した結果を表示 (省略すると設定中のもの)", Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "prompt", Description: "試すプロンプト", MaxLength: maxCustomPromptLength},
+				}},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "history", Description: "カスタムプロンプトの変更履歴を表示"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "rollback", Description: "反映済みの以前の変更に戻す", Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "version", Description: "/edit history で表示される番号", Required: true, MinValue: &minCustomPromptVersion},
+				}},
+			},
+		},
+		{
+			Name:        "persona",
+			Description: "Botのペルソナ (キャラクター) を選ぶ",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "set", Description: "あなたとの会話で使うペルソナを選ぶ", Options: []*discordgo.ApplicationCommandOption{personaNameOption(true)}},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "clear", Description: "ペルソナの選択を取り消す"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "選べるペルソナと選択中のペルソナを表示"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "channel", Description: "このチャンネルの既定のペルソナを設定 (チャンネル管理権限が必要。省略で取り消し)", Options: []*discordgo.ApplicationCommandOption{personaNameOption(false)}},
+			},
+		},
+		{
+			Name:        "memory",
+			Description: "Botが覚えているあなたについての事実を管理",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "覚えていることを表示"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "forget", Description: "覚えていることを忘れさせる (番号を省略すると全て)", Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "id", Description: "/memory list で表示される番号", MinValue: &minMemoryID},
+				}},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "off", Description: "新しく覚えることと、覚えていることを会話に使うのをやめる"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "on", Description: "覚えていることを会話に使う"},
+			},
+		},
+		{
+			Name:                     "ollama",
+			Description:              "Ollamaのモデルを管理 (管理者のみ)",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "ローカルのモデル一覧"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "ps", Description: "ロード中のモデル一覧"},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "pull", Description: "モデルをダウンロード", Options: []*discordgo.ApplicationCommandOption{ollamaModelOption(true)}},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "unload", Description: "モデルをメモリから解放 (省略時は設定のモデル)", Options: []*discordgo.ApplicationCommandOption{ollamaModelOption(false)}},
+				{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "warm", Description: "モデルをメモリにロード (省略時は設定のモデル)", Options: []*discordgo.ApplicationCommandOption{ollamaModelOption(false)}},
 			},
 		},
 	}
@@ -96,13 +174,17 @@ func StartBot(cfg *config.Config) error {
 
 	// setupHandlers から history.HistoryManager と chat.Service を受け取る
 	var historyMgr history.HistoryManager
-	var chatSvc chat.Service // chat.Service 型の変数を宣言
+	var chatSvc chat.Service                                                    // chat.Service 型の変数を宣言
 	historyMgr, chatSvc, err = setupHandlers(session, cfg, chatSvc, historyMgr) // chatSvc と historyMgr を渡す
 	if err != nil {
 		log.Printf("Error in setupHandlers: %v", err)
 		return fmt.Errorf("ハンドラの設定中にエラーが発生しました: %w", err)
 	}
 
+	if cfg.Model.Ollama.WarmUpOnStartup {
+		go warmUpOllama(cfg)
+	}
+
 	// AddHandler for messageCreate to pass chatSvc and cfg
 	session.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		messageCreateHandler(s, m, chatSvc, cfg)
@@ -110,7 +192,6 @@ func StartBot(cfg *config.Config) error {
 	session.AddHandler(messageUpdateHandler) //  messageUpdateHandler と messageDeleteHandler は変更なし
 	session.AddHandler(messageDeleteHandler)
 
-
 	// HistoryManager のクローズ処理
 	if historyMgr != nil {
 		defer func() {
@@ -0,0 +1,271 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+)
+
+const (
+	defaultToolMessageLimit = 20
+	maxToolMessageLimit     = 100
+	// threadArchiveMinutes はツールで作成したスレッドが自動でアーカイブされるまでの時間 (分) です。
+	threadArchiveMinutes = 1440
+)
+
+var (
+	toolTimeZone = time.FixedZone("JST", 9*60*60)
+	mentionID    = regexp.MustCompile(`^<@!?(\d+)>$`)
+	snowflake    = regexp.MustCompile(`^\d+$`)
+	customEmoji  = regexp.MustCompile(`^<a?:(\w+:\d+)>$`)
+)
+
+// discordToolScope はツールを呼び出したユーザーと、その会話が行われている場所です。
+// ツールは常にこのユーザーの権限で実行されるため、ユーザー自身ができない操作はLLMにもさせません。
+type discordToolScope struct {
+	session   DiscordSession
+	guildID   string
+	channelID string
+	userID    string
+	messageID string // 応答のきっかけになったメッセージ。スラッシュコマンドでは空
+}
+
+// discordTools はLLMから呼び出せる Discord 向けのツールを返します。
+// サーバーの情報を扱うツールのため、DM (guildID が空) では nil を返します。
+func discordTools(s DiscordSession, guildID, channelID, userID, messageID string) []chat.Tool {
+	if guildID == "" {
+		return nil
+	}
+	scope := &discordToolScope{session: s, guildID: guildID, channelID: channelID, userID: userID, messageID: messageID}
+	return []chat.Tool{
+		{
+			Name:        "get_channel_messages",
+			Description: "このチャンネルの最近のメッセージを古い順に取得します。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"limit": map[string]interface{}{"type": "integer", "description": fmt.Sprintf("取得する件数 (1〜%d、既定値 %d)", maxToolMessageLimit, defaultToolMessageLimit)},
+				},
+			},
+			Execute: scope.getChannelMessages,
+		},
+		{
+			Name:        "get_member_info",
+			Description: "サーバーのメンバーのロールと参加日を調べます。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"user": map[string]interface{}{"type": "string", "description": "ユーザーID、メンション、またはユーザー名"},
+				},
+				"required": []string{"user"},
+			},
+			Execute: scope.getMemberInfo,
+		},
+		{
+			Name:        "list_pinned_messages",
+			Description: "このチャンネルのピン留めされたメッセージを一覧します。",
+			Parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+			Execute:     scope.listPinnedMessages,
+		},
+		{
+			Name:        "create_thread",
+			Description: "このチャンネルに指定したタイトルの公開スレッドを作成します。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"title": map[string]interface{}{"type": "string", "description": "スレッドのタイトル (100文字まで)"},
+				},
+				"required": []string{"title"},
+			},
+			Execute: scope.createThread,
+		},
+		{
+			Name:        "add_reaction",
+			Description: "メッセージに絵文字でリアクションします。message_id を省略するとユーザーのメッセージにリアクションします。",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"emoji":      map[string]interface{}{"type": "string", "description": "Unicode 絵文字、または <:name:id> 形式のカスタム絵文字"},
+					"message_id": map[string]interface{}{"type": "string", "description": "リアクションするメッセージのID"},
+				},
+				"required": []string{"emoji"},
+			},
+			Execute: scope.addReaction,
+		},
+	}
+}
+
+// requirePermission はツールを呼び出したユーザーがこのチャンネルで perm を持っているか確認します。
+func (t *discordToolScope) requirePermission(perm int64, action string) error {
+	perms, err := t.session.UserChannelPermissions(t.userID, t.channelID)
+	if err != nil {
+		return fmt.Errorf("権限の確認に失敗しました: %w", err)
+	}
+	if perms&perm != perm {
+		log.Printf("ユーザー %s はチャンネル %s で%sできないため、ツールの実行を拒否しました", t.userID, t.channelID, action)
+		return fmt.Errorf("ユーザーにはこのチャンネルで%s権限がありません", action)
+	}
+	return nil
+}
+
+func (t *discordToolScope) getChannelMessages(ctx context.Context, args map[string]interface{}) (string, error) {
+	if err := t.requirePermission(discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory, "メッセージ履歴を読む"); err != nil {
+		return "", err
+	}
+	limit := defaultToolMessageLimit
+	if v, ok := args["limit"].(float64); ok && v >= 1 {
+		limit = min(int(v), maxToolMessageLimit)
+	}
+	messages, err := t.session.ChannelMessages(t.channelID, limit, "", "", "")
+	if err != nil {
+		return "", fmt.Errorf("メッセージの取得に失敗しました: %w", err)
+	}
+	// API は新しい順に返すため、会話として読みやすい古い順に並べ替える
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+	return formatToolMessages(messages, "メッセージはありません。"), nil
+}
+
+func (t *discordToolScope) getMemberInfo(ctx context.Context, args map[string]interface{}) (string, error) {
+	if err := t.requirePermission(discordgo.PermissionViewChannel, "チャンネルを見る"); err != nil {
+		return "", err
+	}
+	query, _ := args["user"].(string)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", fmt.Errorf("user を指定してください")
+	}
+
+	var member *discordgo.Member
+	if id := mentionID.FindStringSubmatch(query); id != nil || snowflake.MatchString(query) {
+		userID := query
+		if id != nil {
+			userID = id[1]
+		}
+		m, err := t.session.GuildMember(t.guildID, userID)
+		if err != nil {
+			return "", fmt.Errorf("メンバーの取得に失敗しました: %w", err)
+		}
+		member = m
+	} else {
+		members, err := t.session.GuildMembersSearch(t.guildID, strings.TrimPrefix(query, "@"), 1)
+		if err != nil {
+			return "", fmt.Errorf("メンバーの検索に失敗しました: %w", err)
+		}
+		if len(members) == 0 {
+			return fmt.Sprintf("「%s」に一致するメンバーは見つかりませんでした。", query), nil
+		}
+		member = members[0]
+	}
+
+	roles, err := t.session.GuildRoles(t.guildID)
+	if err != nil {
+		return "", fmt.Errorf("ロールの取得に失敗しました: %w", err)
+	}
+	roleNames := make(map[string]string, len(roles))
+	for _, role := range roles {
+		roleNames[role.ID] = role.Name
+	}
+	var memberRoles []string
+	for _, id := range member.Roles {
+		if name, ok := roleNames[id]; ok {
+			memberRoles = append(memberRoles, name)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ユーザー名: %s\nID: %s\n", member.User.Username, member.User.ID)
+	if member.Nick != "" {
+		fmt.Fprintf(&b, "ニックネーム: %s\n", member.Nick)
+	}
+	fmt.Fprintf(&b, "参加日: %s\n", member.JoinedAt.In(toolTimeZone).Format("2006-01-02"))
+	if len(memberRoles) == 0 {
+		b.WriteString("ロール: なし")
+	} else {
+		fmt.Fprintf(&b, "ロール: %s", strings.Join(memberRoles, ", "))
+	}
+	return b.String(), nil
+}
+
+func (t *discordToolScope) listPinnedMessages(ctx context.Context, args map[string]interface{}) (string, error) {
+	if err := t.requirePermission(discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory, "メッセージ履歴を読む"); err != nil {
+		return "", err
+	}
+	messages, err := t.session.ChannelMessagesPinned(t.channelID)
+	if err != nil {
+		return "", fmt.Errorf("ピン留めの取得に失敗しました: %w", err)
+	}
+	return formatToolMessages(messages, "ピン留めされたメッセージはありません。"), nil
+}
+
+func (t *discordToolScope) createThread(ctx context.Context, args map[string]interface{}) (string, error) {
+	if err := t.requirePermission(discordgo.PermissionCreatePublicThreads, "スレッドを作成する"); err != nil {
+		return "", err
+	}
+	title, _ := args["title"].(string)
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", fmt.Errorf("title を指定してください")
+	}
+	if runes := []rune(title); len(runes) > 100 {
+		title = string(runes[:100])
+	}
+	thread, err := t.session.ThreadStart(t.channelID, title, discordgo.ChannelTypeGuildPublicThread, threadArchiveMinutes)
+	if err != nil {
+		return "", fmt.Errorf("スレッドの作成に失敗しました: %w", err)
+	}
+	log.Printf("ユーザー %s の依頼でスレッド %s (%s) を作成しました", t.userID, thread.ID, title)
+	return fmt.Sprintf("スレッド「%s」を作成しました: <#%s>", title, thread.ID), nil
+}
+
+func (t *discordToolScope) addReaction(ctx context.Context, args map[string]interface{}) (string, error) {
+	if err := t.requirePermission(discordgo.PermissionAddReactions, "リアクションを追加する"); err != nil {
+		return "", err
+	}
+	emoji, _ := args["emoji"].(string)
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" {
+		return "", fmt.Errorf("emoji を指定してください")
+	}
+	// カスタム絵文字は API では name:id の形で指定する
+	if m := customEmoji.FindStringSubmatch(emoji); m != nil {
+		emoji = m[1]
+	}
+	messageID, _ := args["message_id"].(string)
+	if messageID == "" {
+		messageID = t.messageID
+	}
+	if messageID == "" {
+		return "", fmt.Errorf("message_id を指定してください")
+	}
+	if err := t.session.MessageReactionAdd(t.channelID, messageID, emoji); err != nil {
+		return "", fmt.Errorf("リアクションの追加に失敗しました: %w", err)
+	}
+	return fmt.Sprintf("メッセージ %s に %s でリアクションしました。", messageID, emoji), nil
+}
+
+// formatToolMessages はメッセージを「[日時] ユーザー名: 本文」の行に整形します。
+func formatToolMessages(messages []*discordgo.Message, empty string) string {
+	if len(messages) == 0 {
+		return empty
+	}
+	var b strings.Builder
+	for _, m := range messages {
+		author := "unknown"
+		if m.Author != nil {
+			author = m.Author.Username
+		}
+		content := m.Content
+		if len(m.Attachments) > 0 {
+			content += fmt.Sprintf(" (添付ファイル %d 件)", len(m.Attachments))
+		}
+		fmt.Fprintf(&b, "[%s] %s (ID: %s): %s\n", m.Timestamp.In(toolTimeZone).Format("2006-01-02 15:04"), author, m.ID, content)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
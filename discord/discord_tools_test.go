@@ -0,0 +1,88 @@
+package discord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func findTool(t *testing.T, tools []chat.Tool, name string) chat.Tool {
+	t.Helper()
+	for _, tool := range tools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	t.Fatalf("tool %s not found", name)
+	return chat.Tool{}
+}
+
+func TestDiscordTools(t *testing.T) {
+	const allowed = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory | discordgo.PermissionAddReactions
+
+	t.Run("No tools in DM", func(t *testing.T) {
+		assert.Nil(t, discordTools(new(MockDiscordSession), "", "dm_channel_id", "user_id", "msg_id"))
+	})
+
+	t.Run("Channel messages are oldest first and limit is capped", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		now := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+		mockSession.On("UserChannelPermissions", "user_id", "channel_id").Return(int64(allowed), nil)
+		mockSession.On("ChannelMessages", "channel_id", maxToolMessageLimit, "", "", "").Return([]*discordgo.Message{
+			{ID: "2", Author: &discordgo.User{Username: "bob"}, Content: "元気です", Timestamp: now.Add(time.Minute)},
+			{ID: "1", Author: &discordgo.User{Username: "alice"}, Content: "元気？", Timestamp: now},
+		}, nil)
+
+		tool := findTool(t, discordTools(mockSession, "guild_id", "channel_id", "user_id", "msg_id"), "get_channel_messages")
+		result, err := tool.Execute(context.Background(), map[string]interface{}{"limit": float64(500)})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "[2026-10-18 12:00] alice (ID: 1): 元気？\n[2026-10-18 12:01] bob (ID: 2): 元気です", result)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("Member info by mention", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "user_id", "channel_id").Return(int64(allowed), nil)
+		mockSession.On("GuildMember", "guild_id", "42").Return(&discordgo.Member{
+			User:     &discordgo.User{ID: "42", Username: "carol"},
+			Roles:    []string{"r2"},
+			JoinedAt: time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC),
+		}, nil)
+		mockSession.On("GuildRoles", "guild_id").Return([]*discordgo.Role{{ID: "r1", Name: "admin"}, {ID: "r2", Name: "member"}}, nil)
+
+		tool := findTool(t, discordTools(mockSession, "guild_id", "channel_id", "user_id", "msg_id"), "get_member_info")
+		result, err := tool.Execute(context.Background(), map[string]interface{}{"user": "<@!42>"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "ユーザー名: carol\nID: 42\n参加日: 2024-02-01\nロール: member", result)
+	})
+
+	t.Run("Reaction defaults to the requesting message", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "user_id", "channel_id").Return(int64(allowed), nil)
+		mockSession.On("MessageReactionAdd", "channel_id", "msg_id", "party:123").Return(nil).Once()
+
+		tool := findTool(t, discordTools(mockSession, "guild_id", "channel_id", "user_id", "msg_id"), "add_reaction")
+		_, err := tool.Execute(context.Background(), map[string]interface{}{"emoji": "<:party:123>"})
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("Thread creation requires the user's permission", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "user_id", "channel_id").Return(int64(allowed), nil)
+
+		tool := findTool(t, discordTools(mockSession, "guild_id", "channel_id", "user_id", "msg_id"), "create_thread")
+		_, err := tool.Execute(context.Background(), map[string]interface{}{"title": "雑談"})
+
+		assert.ErrorContains(t, err, "スレッドを作成する権限がありません")
+		mockSession.AssertNotCalled(t, "ThreadStart", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
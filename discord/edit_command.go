@@ -1,73 +1,391 @@
 package discord
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/eraiza0816/llm-discord/config" // chat を config に変更
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
 )
 
-func editCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, cfg *config.Config) { // chatSvc を cfg に変更
-	log.Printf("editCommandHandler called")
+// maxCustomPromptLength はカスタムプロンプトの最大文字数です。
+const maxCustomPromptLength = 4000
 
-	var username string
+// maxListedCustomPromptVersions は /edit history で表示する変更の最大数です。
+const maxListedCustomPromptVersions = 10
+
+// /edit のエディタ (モーダル) の CustomID。モーダルの送信は commandDispatcher.DispatchModal から editModalHandler に届く。
+const (
+	editModalCustomID      = "edit:set"
+	editModalPromptInputID = "prompt"
+)
+
+func editCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, c *editCommand) {
+	if c.store == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("このBotではカスタムプロンプトを使えません。"))
+		return
+	}
+	req, err := newEditRequest(s, i, c)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	sub := i.ApplicationCommandData().Options[0]
+	req.subcommand = sub.Name
+	for _, opt := range sub.Options {
+		switch opt.Name {
+		case "prompt":
+			req.prompt = opt.StringValue()
+		case "version":
+			req.version = opt.IntValue()
+		}
+	}
+	log.Printf("User %s performed /edit %s in channel %s", req.params.Username, sub.Name, i.ChannelID)
+
+	if req.subcommand == "set" || req.subcommand == "reset" {
+		openEditModal(s, i, c, req)
+		return
+	}
+	respondEditCommand(s, i, c, req)
+}
+
+// editModalHandler は /edit のエディタで送信されたプロンプトを設定します。
+func editModalHandler(s *discordgo.Session, i *discordgo.InteractionCreate, c *editCommand) {
+	if c.store == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("このBotではカスタムプロンプトを使えません。"))
+		return
+	}
+	req, err := newEditRequest(s, i, c)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	req.subcommand = "set"
+	req.prompt = modalTextValue(i.ModalSubmitData(), editModalPromptInputID)
+	log.Printf("User %s submitted the /edit editor in channel %s", req.params.Username, i.ChannelID)
+	respondEditCommand(s, i, c, req)
+}
+
+// newEditRequest は実行したユーザーと場所から editRequest を作ります。
+func newEditRequest(s *discordgo.Session, i *discordgo.InteractionCreate, c *editCommand) (editRequest, error) {
+	var member *discordgo.Member
+	var user *discordgo.User
 	if i.Member != nil && i.Member.User != nil {
-		username = i.Member.User.Username
+		member, user = i.Member, i.Member.User
 	} else if i.User != nil { // DMからの場合
-		username = i.User.Username
+		user = i.User
 	} else {
-		log.Println("editCommandHandler: User information not found in interaction")
-		sendErrorResponse(s, i, fmt.Errorf("ユーザー情報が取得できませんでした。"))
-		return
+		log.Println("newEditRequest: User information not found in interaction")
+		return editRequest{}, fmt.Errorf("ユーザー情報が取得できませんでした。")
 	}
 
-	options := i.ApplicationCommandData().Options
+	pc := lookupPromptContext(&discordgoSession{s}, i.GuildID, i.ChannelID)
+	return editRequest{
+		params: chat.ChatParams{
+			UserID:      user.ID,
+			Username:    user.Username,
+			DisplayName: displayName(member, user),
+			GuildName:   pc.GuildName,
+			ChannelName: pc.ChannelName,
+			ThreadTitle: pc.ThreadTitle,
+		},
+		submit: func(v history.CustomPromptVersion) error {
+			return requestCustomPromptReview(s, c.settings().ModChannelID, v)
+		},
+	}, nil
+}
 
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+// openEditModal は req.subcommand に応じた内容を入れたエディタを開きます。
+func openEditModal(s *discordgo.Session, i *discordgo.InteractionCreate, c *editCommand, req editRequest) {
+	value, err := c.editorValue(req)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
 		Data: &discordgo.InteractionResponseData{
-			Content: "カスタムテンプレートを更新しています...",
+			CustomID: editModalCustomID,
+			Title:    "カスタムプロンプトの編集",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:    editModalPromptInputID,
+						Label:       "カスタムプロンプト ({{.DisplayName}} なども使える)",
+						Style:       discordgo.TextInputParagraph,
+						Placeholder: "あなたは{{.DisplayName}}さんの相棒の猫です。語尾は「にゃ」にしてください。",
+						Value:       value,
+						Required:    true,
+						MaxLength:   maxCustomPromptLength,
+					},
+				}},
+			},
 		},
-	})
+	}); err != nil {
+		log.Printf("InteractionRespond error: %v", err)
+	}
+}
 
-	prompt := options[0].StringValue()
+// respondEditCommand は req を実行し、結果を本人にのみ見えるEmbedで返します。
+func respondEditCommand(s *discordgo.Session, i *discordgo.InteractionCreate, c *editCommand, req editRequest) {
+	// LLM での確認に時間がかかることがあるため、先に応答を保留しておく
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		log.Printf("InteractionRespond error: %v", err)
+		return
+	}
+	description, err := c.run(context.Background(), req)
+	if err != nil {
+		sendErrorResponse(s, i, err)
+		return
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{{Description: description, Color: 0xa8ffee}},
+	}); err != nil {
+		log.Printf("InteractionResponseEdit error: %v", err)
+	}
+}
+
+// editRequest は /edit の1回の実行内容です。
+type editRequest struct {
+	subcommand string          // "set" / "reset" / "delete" / "show" / "preview" / "history" / "rollback"
+	prompt     string          // "set" で設定する (エディタで入力した)、または "preview" で試すプロンプト
+	version    int64           // "rollback" で戻す変更の番号
+	params     chat.ChatParams // 実行したユーザーと場所。プロンプトの展開に使う
+	// submit は承認が必要な場合に、承認待ちの変更を管理者に送ります。
+	submit func(v history.CustomPromptVersion) error
+}
+
+// settings はカスタムプロンプトの確認と承認の設定を返します。
+func (c *editCommand) settings() loader.CustomPromptsConfig {
+	if c.cfg == nil || c.cfg.Model == nil {
+		return loader.CustomPromptsConfig{}
+	}
+	return c.cfg.Model.CustomPrompts
+}
+
+// defaultPrompt は req のユーザーに使われる model.json のプロンプトを返します。
+func (c *editCommand) defaultPrompt(req editRequest) string {
+	if c.cfg == nil || c.cfg.Model == nil {
+		return ""
+	}
+	return c.cfg.Model.GetPromptByUser(req.params.Username)
+}
 
-	if strings.ToLower(prompt) == "delete" {
-		err := DeleteCustomPromptForUser(username)
+// editorValue はエディタに最初に入れておくプロンプトを返します。
+// "set" では設定中のカスタムプロンプト、"reset" では既定のプロンプトを元に書き直せるよう model.json のプロンプトです。
+// "reset" で開いたエディタをそのまま送信した場合は、run でカスタムプロンプトを削除します。
+func (c *editCommand) editorValue(req editRequest) (string, error) {
+	var value string
+	switch req.subcommand {
+	case "set":
+		prompt, _, err := c.store.GetCustomPrompt(req.params.UserID)
 		if err != nil {
-			sendErrorResponse(s, i, fmt.Errorf("カスタムテンプレートの削除中にエラーが発生しました: %w", err))
-			return
+			return "", fmt.Errorf("カスタムプロンプトの取得に失敗しました: %w", err)
 		}
+		value = prompt
+	case "reset":
+		value = c.defaultPrompt(req)
+	}
+	return truncateRunes(value, maxCustomPromptLength), nil
+}
 
-		embed := &discordgo.MessageEmbed{
-			Description: "あなたのカスタムテンプレートを削除しました！",
-			Color:       0xa8ffee,
+// run は req を実行し、実行したユーザーに表示する埋め込みの説明文を返します。
+func (c *editCommand) run(ctx context.Context, req editRequest) (string, error) {
+	userID := req.params.UserID
+	switch req.subcommand {
+	case "set":
+		prompt := strings.TrimSpace(req.prompt)
+		if prompt == "" {
+			return "", errors.New("カスタムプロンプトが空です。削除する場合は `/edit delete` を使ってください。")
 		}
-		_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Embeds: &[]*discordgo.MessageEmbed{embed},
-		})
-		if editErr != nil {
-			log.Printf("InteractionResponseEdit error after deleting prompt: %v", editErr)
+		if len([]rune(prompt)) > maxCustomPromptLength {
+			return "", fmt.Errorf("カスタムプロンプトは %d 文字以内にしてください。", maxCustomPromptLength)
 		}
-		return
+		// 既定のプロンプトの写しを保存すると、以降の model.json の変更が反映されなくなるため、削除と同じく既定に戻す
+		if prompt == strings.TrimSpace(c.defaultPrompt(req)) {
+			if err := c.store.SetCustomPrompt(userID, ""); err != nil {
+				return "", fmt.Errorf("カスタムプロンプトの削除に失敗しました: %w", err)
+			}
+			return "既定のプロンプトと同じ内容のため、カスタムプロンプトを削除して既定のプロンプトに戻しました！", nil
+		}
+		if _, err := loader.ParseCustomPromptTemplate(userID, prompt); err != nil {
+			return "", customPromptError(err)
+		}
+		if err := c.screen(ctx, prompt); err != nil {
+			return "", err
+		}
+		if c.settings().RequiresApproval() {
+			return c.submit(req, prompt)
+		}
+		if err := c.store.SetCustomPrompt(userID, prompt); err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの保存に失敗しました: %w", err)
+		}
+		return "カスタムプロンプトを更新しました！次の発言から使われます。", nil
+	case "delete":
+		// 削除は既定のプロンプトに戻すだけなので、承認を待たずに反映する
+		if err := c.store.SetCustomPrompt(userID, ""); err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの削除に失敗しました: %w", err)
+		}
+		return "あなたのカスタムプロンプトを削除しました！", nil
+	case "show":
+		prompt, ok, err := c.store.GetCustomPrompt(userID)
+		if err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの取得に失敗しました: %w", err)
+		}
+		if !ok {
+			return "カスタムプロンプトは設定されていません。`/edit set` で設定できます。", nil
+		}
+		return "あなたのカスタムプロンプト:\n" + codeBlock(prompt), nil
+	case "preview":
+		prompt := strings.TrimSpace(req.prompt)
+		heading := "入力したプロンプトの展開結果:"
+		if prompt == "" {
+			saved, ok, err := c.store.GetCustomPrompt(userID)
+			if err != nil {
+				return "", fmt.Errorf("カスタムプロンプトの取得に失敗しました: %w", err)
+			}
+			prompt, heading = saved, "あなたのカスタムプロンプトの展開結果:"
+			if !ok {
+				heading = "カスタムプロンプトが無いため、既定のプロンプトの展開結果:"
+			}
+		}
+		system, err := c.chatSvc.PreviewSystemPrompt(req.params, prompt)
+		if err != nil {
+			return "", customPromptError(err)
+		}
+		return heading + "\n" + codeBlock(system), nil
+	case "history":
+		versions, err := c.store.CustomPromptVersions(userID)
+		if err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの変更履歴の取得に失敗しました: %w", err)
+		}
+		if len(versions) == 0 {
+			return "カスタムプロンプトの変更履歴はありません。", nil
+		}
+		return describeCustomPromptVersions(versions), nil
+	case "rollback":
+		versions, err := c.store.CustomPromptVersions(userID)
+		if err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの変更履歴の取得に失敗しました: %w", err)
+		}
+		i := slices.IndexFunc(versions, func(v history.CustomPromptVersion) bool { return v.ID == req.version })
+		if i < 0 {
+			return "", fmt.Errorf("#%d の変更は見つかりませんでした。`/edit history` で番号を確認してください。", req.version)
+		}
+		v := versions[i]
+		if v.Status != history.CustomPromptApproved {
+			return "", fmt.Errorf("#%d の変更は反映されていないため、戻せません。", v.ID)
+		}
+		// 承認済みの内容なので承認は待たないが、その後に追加された禁止語句は確認する
+		if term, ok := c.settings().Blocked(v.Prompt); ok {
+			return "", fmt.Errorf("#%d の内容には禁止されている語句「%s」が含まれているため、戻せません。", v.ID, term)
+		}
+		if err := c.store.SetCustomPrompt(userID, v.Prompt); err != nil {
+			return "", fmt.Errorf("カスタムプロンプトの保存に失敗しました: %w", err)
+		}
+		if v.Prompt == "" {
+			return fmt.Sprintf("#%d の状態 (カスタムプロンプト無し) に戻しました！", v.ID), nil
+		}
+		return fmt.Sprintf("カスタムプロンプトを #%d の内容に戻しました！次の発言から使われます。", v.ID), nil
 	}
+	return "", fmt.Errorf("不明なサブコマンドです: %s", req.subcommand)
+}
 
-	err := SetCustomPromptForUser(username, prompt)
+// screen は custom_prompts の禁止語句と LLM で、prompt を受け付けてよいかを確認します。
+func (c *editCommand) screen(ctx context.Context, prompt string) error {
+	settings := c.settings()
+	if term, ok := settings.Blocked(prompt); ok {
+		return fmt.Errorf("禁止されている語句「%s」が含まれているため、受け付けられません。", term)
+	}
+	if !settings.LLMCheck {
+		return nil
+	}
+	allowed, reason, err := c.chatSvc.CheckCustomPrompt(ctx, prompt)
 	if err != nil {
-		sendErrorResponse(s, i, fmt.Errorf("カスタムテンプレートの設定中にエラーが発生しました: %w", err))
-		return
+		return fmt.Errorf("カスタムプロンプトの確認に失敗しました。時間をおいて試してください: %w", err)
 	}
-
-	embed := &discordgo.MessageEmbed{
-		Description: "カスタムテンプレートを更新しました！",
-		Color:       0xa8ffee,
+	if !allowed {
+		if reason == "" {
+			reason = "不適切な内容が含まれています"
+		}
+		return fmt.Errorf("カスタムプロンプトは受け付けられませんでした: %s", reason)
 	}
-	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Embeds: &[]*discordgo.MessageEmbed{embed},
-	})
+	return nil
+}
+
+// submit は prompt を承認待ちの変更として記録し、管理者に承認を依頼します。
+func (c *editCommand) submit(req editRequest, prompt string) (string, error) {
+	v, err := c.store.SubmitCustomPrompt(req.params.UserID, prompt)
 	if err != nil {
-		log.Printf("InteractionResponseEdit error: %v", err)
+		return "", fmt.Errorf("カスタムプロンプトの保存に失敗しました: %w", err)
+	}
+	if err := req.submit(v); err != nil {
+		// 管理者に届かなかった変更が後から承認されることは無いため、取り下げておく
+		if _, rejectErr := c.store.ReviewCustomPrompt(v.ID, false, ""); rejectErr != nil {
+			log.Printf("承認の依頼に失敗したカスタムプロンプト #%d の取り下げに失敗しました: %v", v.ID, rejectErr)
+		}
+		return "", fmt.Errorf("管理者への承認の依頼に失敗しました: %w", err)
+	}
+	return fmt.Sprintf("カスタムプロンプトの変更 (#%d) を管理者に送りました。承認されると次の発言から使われます。", v.ID), nil
+}
+
+// describeCustomPromptVersions は /edit history の説明文を作ります。反映中の変更には印を付けます。
+func describeCustomPromptVersions(versions []history.CustomPromptVersion) string {
+	var b strings.Builder
+	b.WriteString("カスタムプロンプトの変更履歴 (新しい順)。`/edit rollback` で反映済みの変更に戻せます。\n")
+	current := slices.IndexFunc(versions, func(v history.CustomPromptVersion) bool { return v.Status == history.CustomPromptApproved })
+	for i, v := range versions {
+		if i == maxListedCustomPromptVersions {
+			fmt.Fprintf(&b, "…ほか %d 件\n", len(versions)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\n`#%d` %s %s", v.ID, v.CreatedAt.Format("2006/01/02 15:04"), customPromptStatusLabel(v.Status))
+		if i == current {
+			b.WriteString(" (使用中)")
+		}
+		content := "(削除)"
+		if v.Prompt != "" {
+			content = truncateRunes(strings.Join(strings.Fields(v.Prompt), " "), 80)
+		}
+		fmt.Fprintf(&b, "\n> %s\n", content)
+	}
+	return b.String()
+}
+
+// customPromptStatusLabel は変更の状態の表示名を返します。
+func customPromptStatusLabel(status string) string {
+	switch status {
+	case history.CustomPromptApproved:
+		return "反映済み"
+	case history.CustomPromptPending:
+		return "承認待ち"
+	case history.CustomPromptRejected:
+		return "却下"
+	case history.CustomPromptSuperseded:
+		return "取り下げ"
+	}
+	return status
+}
+
+// customPromptError はテンプレートの誤りを、ユーザーに分かる位置付きのメッセージにします。
+func customPromptError(err error) error {
+	var tmplErr *loader.PromptTemplateError
+	if errors.As(err, &tmplErr) {
+		return fmt.Errorf("カスタムプロンプトの %d行%d列に誤りがあります: %s", tmplErr.Line, tmplErr.Column, tmplErr.Msg)
 	}
+	return fmt.Errorf("カスタムプロンプトを解析できませんでした: %w", err)
+}
+
+// codeBlock は text をコードブロックにします。埋め込みの説明文に収まるよう切り詰め、中の ``` でブロックが閉じないようにします。
+func codeBlock(text string) string {
+	return "```\n" + truncateRunes(strings.ReplaceAll(text, "```", "`\u200b``"), 4000) + "\n```"
 }
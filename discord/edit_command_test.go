@@ -0,0 +1,222 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunEditCommand(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.CustomPromptStore)
+	chatSvc := new(MockChatService)
+	params := chat.ChatParams{UserID: "u1", Username: "taro"}
+	c := &editCommand{chatSvc: chatSvc, store: store}
+	run := func(subcommand, prompt string) (string, error) {
+		return c.run(context.Background(), editRequest{subcommand: subcommand, prompt: prompt, params: params})
+	}
+
+	content, err := run("show", "")
+	assert.NoError(t, err)
+	assert.Contains(t, content, "設定されていません")
+
+	_, err = run("set", "{{.Nickname}}")
+	assert.EqualError(t, err, "カスタムプロンプトの 1行3列に誤りがあります: executing \"u1\" at <.Nickname>: can't evaluate field Nickname in type loader.PromptVars")
+	_, ok, _ := store.GetCustomPrompt("u1")
+	assert.False(t, ok, "invalid prompts should not be saved")
+	_, err = run("set", "  ")
+	assert.Error(t, err)
+
+	_, err = run("set", "  あなたは{{.Username}}さんの猫です。\n```語尾```はにゃ  ")
+	assert.NoError(t, err)
+	prompt, _, _ := store.GetCustomPrompt("u1")
+	assert.Equal(t, "あなたは{{.Username}}さんの猫です。\n```語尾```はにゃ", prompt)
+	content, _ = run("show", "")
+	assert.Equal(t, "あなたのカスタムプロンプト:\n```\nあなたは{{.Username}}さんの猫です。\n`\u200b``語尾`\u200b``はにゃ\n```", content)
+
+	chatSvc.On("PreviewSystemPrompt", params, prompt).Return("あなたはtaroさんの猫です。", nil).Once()
+	content, err = run("preview", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "あなたのカスタムプロンプトの展開結果:\n```\nあなたはtaroさんの猫です。\n```", content)
+	chatSvc.On("PreviewSystemPrompt", params, "{{.Username}}").Return("taro", nil).Once()
+	content, _ = run("preview", "{{.Username}}")
+	assert.Contains(t, content, "入力したプロンプトの展開結果:")
+
+	_, err = run("delete", "")
+	assert.NoError(t, err)
+	_, ok, _ = store.GetCustomPrompt("u1")
+	assert.False(t, ok)
+	chatSvc.On("PreviewSystemPrompt", params, "").Return("既定", nil).Once()
+	content, _ = run("preview", "")
+	assert.Contains(t, content, "既定のプロンプトの展開結果:")
+	chatSvc.AssertExpectations(t)
+	chatSvc.AssertNotCalled(t, "PreviewSystemPrompt", mock.Anything, "{{.Nickname}}")
+}
+
+func TestRunEditCommandModeration(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.CustomPromptStore)
+	chatSvc := new(MockChatService)
+	cfg := &config.Config{Model: &loader.ModelConfig{CustomPrompts: loader.CustomPromptsConfig{
+		ModChannelID: "mod",
+		Blocklist:    []string{"jailbreak"},
+		LLMCheck:     true,
+	}}}
+	c := &editCommand{cfg: cfg, chatSvc: chatSvc, store: store}
+	var submitted []history.CustomPromptVersion
+	var submitErr error
+	run := func(subcommand, prompt string, version int64) (string, error) {
+		return c.run(context.Background(), editRequest{
+			subcommand: subcommand, prompt: prompt, version: version, params: chat.ChatParams{UserID: "u1"},
+			submit: func(v history.CustomPromptVersion) error {
+				submitted = append(submitted, v)
+				return submitErr
+			},
+		})
+	}
+
+	_, err := run("set", "これは JailBreak です", 0)
+	assert.EqualError(t, err, "禁止されている語句「jailbreak」が含まれているため、受け付けられません。")
+	chatSvc.On("CheckCustomPrompt", mock.Anything, "危ないプロンプト").Return(false, "危険な行為の助長", nil).Once()
+	_, err = run("set", "危ないプロンプト", 0)
+	assert.EqualError(t, err, "カスタムプロンプトは受け付けられませんでした: 危険な行為の助長")
+	chatSvc.On("CheckCustomPrompt", mock.Anything, "あなたは猫です。").Return(true, "", nil).Once()
+	content, err := run("set", "あなたは猫です。", 0)
+	assert.NoError(t, err)
+	assert.Contains(t, content, "管理者に送りました")
+	if assert.Len(t, submitted, 1) {
+		assert.Equal(t, history.CustomPromptPending, submitted[0].Status)
+	}
+	_, ok, _ := store.GetCustomPrompt("u1")
+	assert.False(t, ok, "pending prompts should not be applied before approval")
+
+	// 承認の依頼を送れなかった変更は取り下げる
+	submitErr = errors.New("missing access")
+	chatSvc.On("CheckCustomPrompt", mock.Anything, "あなたは犬です。").Return(true, "", nil).Once()
+	_, err = run("set", "あなたは犬です。", 0)
+	assert.EqualError(t, err, "管理者への承認の依頼に失敗しました: missing access")
+	versions, _ := store.CustomPromptVersions("u1")
+	assert.Equal(t, history.CustomPromptRejected, versions[0].Status)
+
+	_, err = store.ReviewCustomPrompt(submitted[0].ID, true, "mod1")
+	assert.ErrorIs(t, err, history.ErrCustomPromptNotPending, "the failed submission supersedes the earlier one")
+
+	// 承認済みの以前の変更には承認を待たずに戻せる
+	store.SetCustomPrompt("u1", "あなたは鳥です。")
+	store.SetCustomPrompt("u1", "あなたは魚です。")
+	versions, _ = store.CustomPromptVersions("u1")
+	_, err = run("rollback", "", versions[2].ID)
+	assert.EqualError(t, err, fmt.Sprintf("#%d の変更は反映されていないため、戻せません。", versions[2].ID))
+	_, err = run("rollback", "", 999)
+	assert.Error(t, err)
+	content, err = run("rollback", "", versions[1].ID)
+	assert.NoError(t, err)
+	assert.Contains(t, content, fmt.Sprintf("#%d の内容に戻しました", versions[1].ID))
+	prompt, _, _ := store.GetCustomPrompt("u1")
+	assert.Equal(t, "あなたは鳥です。", prompt)
+
+	content, err = run("history", "", 0)
+	assert.NoError(t, err)
+	assert.Contains(t, content, fmt.Sprintf("`#%d` ", versions[1].ID+2))
+	assert.Contains(t, content, "反映済み (使用中)\n> あなたは鳥です。")
+	assert.Contains(t, content, "却下\n> あなたは犬です。")
+	assert.Contains(t, content, "取り下げ\n> あなたは猫です。")
+	chatSvc.AssertExpectations(t)
+}
+
+func TestEditorValue(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.CustomPromptStore)
+	cfg := &config.Config{Model: &loader.ModelConfig{Prompts: map[string]string{"default": "あなたは通常のBotです。", "taro": "あなたは太郎専用のBotです。"}}}
+	c := &editCommand{cfg: cfg, store: store}
+	value := func(subcommand, username string) string {
+		v, err := c.editorValue(editRequest{subcommand: subcommand, params: chat.ChatParams{UserID: "u1", Username: username}})
+		assert.NoError(t, err)
+		return v
+	}
+
+	assert.Equal(t, "", value("set", "hanako"), "the editor is empty without a custom prompt")
+	store.SetCustomPrompt("u1", "あなたは猫です。\n\n語尾は「にゃ」です。")
+	assert.Equal(t, "あなたは猫です。\n\n語尾は「にゃ」です。", value("set", "hanako"))
+	assert.Equal(t, "あなたは通常のBotです。", value("reset", "hanako"))
+	assert.Equal(t, "あなたは太郎専用のBotです。", value("reset", "taro"))
+}
+
+func TestRunEditCommandResetToDefault(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.CustomPromptStore)
+	chatSvc := new(MockChatService)
+	cfg := &config.Config{Model: &loader.ModelConfig{
+		Prompts:       map[string]string{"default": "あなたは通常のBotです。"},
+		CustomPrompts: loader.CustomPromptsConfig{ModChannelID: "mod", LLMCheck: true},
+	}}
+	c := &editCommand{cfg: cfg, chatSvc: chatSvc, store: store}
+	store.SetCustomPrompt("u1", "あなたは猫です。")
+
+	// /edit reset のエディタをそのまま送信すると、写しを保存せずにカスタムプロンプトを削除する (確認や承認も不要)
+	content, err := c.run(context.Background(), editRequest{
+		subcommand: "set", prompt: " あなたは通常のBotです。\n", params: chat.ChatParams{UserID: "u1", Username: "hanako"},
+		submit: func(v history.CustomPromptVersion) error {
+			t.Error("an unchanged default prompt should not be submitted for approval")
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, content, "既定のプロンプトに戻しました")
+	_, ok, _ := store.GetCustomPrompt("u1")
+	assert.False(t, ok)
+	chatSvc.AssertNotCalled(t, "CheckCustomPrompt", mock.Anything, mock.Anything)
+}
+
+func TestModalTextValue(t *testing.T) {
+	data := discordgo.ModalSubmitInteractionData{
+		CustomID: editModalCustomID,
+		Components: []discordgo.MessageComponent{
+			&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				&discordgo.TextInput{CustomID: "other", Value: "x"},
+			}},
+			&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				&discordgo.TextInput{CustomID: editModalPromptInputID, Value: "一段落目\n\n二段落目"},
+			}},
+		},
+	}
+	assert.Equal(t, "一段落目\n\n二段落目", modalTextValue(data, editModalPromptInputID))
+	assert.Equal(t, "", modalTextValue(data, "missing"))
+}
+
+type fakeModalCommand struct{ handled []string }
+
+func (c *fakeModalCommand) Name() string { return "edit" }
+
+func (c *fakeModalCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	return nil
+}
+
+func (c *fakeModalCommand) HandleModal(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	c.handled = append(c.handled, i.ModalSubmitData().CustomID)
+	return nil
+}
+
+func TestDispatchModal(t *testing.T) {
+	d := newCommandDispatcher()
+	cmd := &fakeModalCommand{}
+	d.Register(cmd)
+	d.Register(&aboutCommand{})
+	for _, customID := range []string{editModalCustomID, "about:x", "unknown:x"} {
+		i := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type: discordgo.InteractionModalSubmit,
+			Data: discordgo.ModalSubmitInteractionData{CustomID: customID},
+		}}
+		assert.NoError(t, d.DispatchModal(nil, i))
+	}
+	assert.Equal(t, []string{editModalCustomID}, cmd.handled)
+}
@@ -1,6 +1,12 @@
 package discord
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/history"
+)
 
 // SplitToEmbedFields は、指定されたテキストをDiscordのEmbed Fieldの制約に合わせて分割します。
 //
@@ -80,3 +86,92 @@ func SplitToEmbedFields(text string) []*discordgo.MessageEmbedField {
 
 	return fields
 }
+
+// reasoningTitle は思考過程の見出しを返します。
+func reasoningTitle(tokens int) string {
+	return fmt.Sprintf("💭 思考過程 (%d tokens)", tokens)
+}
+
+// truncateRunes は text を maxLen 文字以内に切り詰め、切り詰めた場合は末尾に省略記号を付けます。
+func truncateRunes(text string, maxLen int) string {
+	const ellipsis = "..."
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	if maxLen <= len(ellipsis) {
+		return string(runes[:maxLen])
+	}
+	return string(runes[:maxLen-len(ellipsis)]) + ellipsis
+}
+
+// reasoningSpoiler は思考過程を折りたたみ表示 (スポイラー) 用に整形します。
+// 結果は maxLen 文字以内に収まり、収まらない場合は空文字列を返します。
+func reasoningSpoiler(reasoning string, tokens int, maxLen int) string {
+	header := reasoningTitle(tokens) + "\n"
+	// 本文中の "||" でスポイラーが途切れないよう、ゼロ幅スペースを挟む
+	body := strings.ReplaceAll(reasoning, "||", "|\u200b|")
+	available := maxLen - len([]rune(header)) - len("||||")
+	if reasoning == "" || available <= 0 {
+		return ""
+	}
+	return header + "||" + truncateRunes(body, available) + "||"
+}
+
+// buildReasoningEmbed は思考過程を表示する Embed を作成します。
+func buildReasoningEmbed(reasoning string, tokens int) *discordgo.MessageEmbed {
+	const maxDescriptionLength = 4096
+	return &discordgo.MessageEmbed{
+		Title:       reasoningTitle(tokens),
+		Description: truncateRunes(reasoning, maxDescriptionLength),
+		Color:       0xcccccc,
+	}
+}
+
+// buildGroundingEmbed は Google 検索によるグラウンディングの出典を、番号付きのリンクとして表示する Embed を作成します。
+func buildGroundingEmbed(grounding *history.Grounding) *discordgo.MessageEmbed {
+	const (
+		maxDescriptionLength = 4096
+		maxFooterLength      = 2048
+	)
+	// タイトル中の角括弧でリンクの書式が崩れないようにエスケープする
+	escaper := strings.NewReplacer("[", "\\[", "]", "\\]")
+
+	var description strings.Builder
+	for i, source := range grounding.Sources {
+		title := source.Title
+		if title == "" {
+			title = source.URI
+		}
+		line := fmt.Sprintf("%d. [%s](%s)\n", i+1, escaper.Replace(title), source.URI)
+		if len([]rune(description.String()))+len([]rune(line)) > maxDescriptionLength {
+			break
+		}
+		description.WriteString(line)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "🔎 出典",
+		Description: strings.TrimSuffix(description.String(), "\n"),
+		Color:       0x4285f4,
+	}
+	if len(grounding.Queries) > 0 {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: truncateRunes("検索: "+strings.Join(grounding.Queries, " / "), maxFooterLength),
+		}
+	}
+	return embed
+}
+
+// buildSummaryEmbed は会話の要約を表示する Embed を作成します。
+func buildSummaryEmbed(summary history.Summary) *discordgo.MessageEmbed {
+	const maxDescriptionLength = 4096
+	return &discordgo.MessageEmbed{
+		Title:       "📝 これまでの会話の要約",
+		Description: truncateRunes(summary.Text, maxDescriptionLength),
+		Color:       0xa8ffee,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "更新: " + summary.UpdatedAt.In(toolTimeZone).Format("2006/01/02 15:04"),
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/history"
+)
+
+// stopGenerationCustomIDPrefix は停止ボタンの CustomID の接頭辞です。後ろに生成のキーが続きます。
+const stopGenerationCustomIDPrefix = "stop_generation:"
+
+var (
+	errGenerationNotFound = errors.New("この生成はすでに終了しています。")
+	errNotGenerationOwner = errors.New("この生成を停止できるのは、依頼したユーザーか管理者のみです。")
+)
+
+// activeGeneration は実行中の生成1件を表します。
+type activeGeneration struct {
+	cancel  context.CancelFunc
+	ownerID string
+}
+
+// generationRegistry は実行中の生成を、停止ボタンから参照できるキー (メッセージIDやインタラクションID) で保持します。
+type generationRegistry struct {
+	mu          sync.Mutex
+	generations map[string]*activeGeneration
+}
+
+func newGenerationRegistry() *generationRegistry {
+	return &generationRegistry{generations: make(map[string]*activeGeneration)}
+}
+
+var generations = newGenerationRegistry()
+
+// start は key の生成を登録し、停止ボタンまたは期限 (timeout > 0 の場合) で終了する context を返します。
+// 生成が終わったら、返された done を必ず呼び出してください。
+func (r *generationRegistry) start(parent context.Context, key, ownerID string, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	genCtx, cancelDeadline := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		genCtx, cancelDeadline = context.WithTimeout(ctx, timeout)
+	}
+
+	r.mu.Lock()
+	r.generations[key] = &activeGeneration{cancel: cancel, ownerID: ownerID}
+	r.mu.Unlock()
+
+	return genCtx, func() {
+		r.mu.Lock()
+		delete(r.generations, key)
+		r.mu.Unlock()
+		cancelDeadline()
+		cancel()
+	}
+}
+
+// stop は key の生成を停止します。停止できるのは依頼したユーザーか管理者のみです。
+func (r *generationRegistry) stop(key, userID string, isAdmin bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gen, ok := r.generations[key]
+	if !ok {
+		return errGenerationNotFound
+	}
+	if gen.ownerID != userID && !isAdmin {
+		return errNotGenerationOwner
+	}
+	gen.cancel()
+	delete(r.generations, key)
+	return nil
+}
+
+// stopButton は key の生成を停止するボタンを返します。
+func stopButton(key string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "停止",
+					Style:    discordgo.DangerButton,
+					CustomID: stopGenerationCustomIDPrefix + key,
+				},
+			},
+		},
+	}
+}
+
+// pendingMessage は生成中に表示する仮メッセージの本文です。
+const pendingMessage = "考え中…"
+
+// startPendingGeneration は m への応答の生成を登録し、停止ボタン付きの仮メッセージを送信します。
+// Bot からのメッセージには停止ボタンを出さず、仮メッセージも送信しません (pending は nil)。
+func startPendingGeneration(s DiscordSession, m *discordgo.MessageCreate, timeout time.Duration, reference *discordgo.MessageReference, isBot bool) (context.Context, *discordgo.Message, func()) {
+	ctx, done := generations.start(context.Background(), m.ID, m.Author.ID, timeout)
+	if isBot {
+		return ctx, nil, done
+	}
+	pending, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:    pendingMessage,
+		Components: stopButton(m.ID),
+		Reference:  reference,
+	})
+	if err != nil {
+		// 仮メッセージを送れなくても応答は通常どおり送信する
+		log.Printf("生成中メッセージの送信に失敗しました: %v", err)
+		return ctx, nil, done
+	}
+	return ctx, pending, done
+}
+
+// markIncomplete は途中で止まった応答の末尾に、止まった理由を書き添えます。
+func markIncomplete(ctx context.Context, resp *chat.ChatResponse) *chat.ChatResponse {
+	if !resp.Incomplete {
+		return resp
+	}
+	marked := *resp
+	marked.Text = fmt.Sprintf("%s\n（%s）", resp.Text, stoppedReason(ctx, resp.StopErr))
+	return &marked
+}
+
+// stoppedReason は生成が止まった理由を、ユーザーによる停止か期限切れかで返します。
+// err は生成を止めたエラーです。コマンドの期限 (ctx) だけでなく、プロバイダごとの期限切れも err から判定します。
+func stoppedReason(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return "時間切れのため途中で打ち切りました"
+	}
+	return "生成を停止しました"
+}
+
+// componentInteractionHandler はボタンなどのメッセージコンポーネントの操作を処理します。
+func componentInteractionHandler(s *discordgo.Session, i *discordgo.InteractionCreate, customPrompts history.CustomPromptStore) {
+	customID := i.MessageComponentData().CustomID
+	if key, ok := strings.CutPrefix(customID, stopGenerationCustomIDPrefix); ok {
+		stopGenerationHandler(s, i, key)
+	} else if id, ok := strings.CutPrefix(customID, approveCustomPromptCustomIDPrefix); ok {
+		customPromptReviewHandler(s, i, customPrompts, id, true)
+	} else if id, ok := strings.CutPrefix(customID, rejectCustomPromptCustomIDPrefix); ok {
+		customPromptReviewHandler(s, i, customPrompts, id, false)
+	}
+}
+
+func stopGenerationHandler(s *discordgo.Session, i *discordgo.InteractionCreate, key string) {
+	var userID string
+	if i.Member != nil && i.Member.User != nil {
+		userID = i.Member.User.ID
+	} else if i.User != nil {
+		userID = i.User.ID
+	}
+
+	if err := generations.stop(key, userID, isAdministrator(i)); err != nil {
+		respErr := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: err.Error(),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if respErr != nil {
+			log.Printf("停止ボタンへの応答に失敗しました: %v", respErr)
+		}
+		return
+	}
+
+	log.Printf("生成を停止しました: key=%s, UserID=%s", key, userID)
+	// メッセージ本体は生成側が停止後の内容で編集するため、ここでは受け付けたことだけを返す
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	}); err != nil {
+		log.Printf("停止ボタンへの応答に失敗しました: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerationRegistry(t *testing.T) {
+	t.Run("owner can stop", func(t *testing.T) {
+		r := newGenerationRegistry()
+		ctx, done := r.start(context.Background(), "key", "owner", 0)
+		defer done()
+
+		assert.NoError(t, r.stop("key", "owner", false))
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+		assert.Equal(t, "生成を停止しました", stoppedReason(ctx, ctx.Err()))
+	})
+
+	t.Run("other users cannot stop unless admin", func(t *testing.T) {
+		r := newGenerationRegistry()
+		ctx, done := r.start(context.Background(), "key", "owner", 0)
+		defer done()
+
+		assert.ErrorIs(t, r.stop("key", "someone", false), errNotGenerationOwner)
+		assert.NoError(t, ctx.Err())
+		assert.NoError(t, r.stop("key", "admin", true))
+		assert.Error(t, ctx.Err())
+	})
+
+	t.Run("finished generation cannot be stopped", func(t *testing.T) {
+		r := newGenerationRegistry()
+		_, done := r.start(context.Background(), "key", "owner", 0)
+		done()
+
+		assert.ErrorIs(t, r.stop("key", "owner", false), errGenerationNotFound)
+	})
+
+	t.Run("command deadline", func(t *testing.T) {
+		r := newGenerationRegistry()
+		ctx, done := r.start(context.Background(), "key", "owner", 10*time.Millisecond)
+		defer done()
+
+		<-ctx.Done()
+		assert.True(t, errors.Is(ctx.Err(), context.DeadlineExceeded))
+		assert.Equal(t, "時間切れのため途中で打ち切りました", stoppedReason(ctx, nil))
+	})
+
+	t.Run("provider deadline", func(t *testing.T) {
+		r := newGenerationRegistry()
+		ctx, done := r.start(context.Background(), "key", "owner", 0)
+		defer done()
+
+		// プロバイダの期限が切れてもコマンドの ctx はまだ有効
+		err := fmt.Errorf("Ollama APIからのエラー: %w", context.DeadlineExceeded)
+		assert.NoError(t, ctx.Err())
+		assert.Equal(t, "時間切れのため途中で打ち切りました", stoppedReason(ctx, err))
+		resp := markIncomplete(ctx, &chat.ChatResponse{Text: "途中まで", Incomplete: true, StopErr: err})
+		assert.Equal(t, "途中まで\n（時間切れのため途中で打ち切りました）", resp.Text)
+	})
+}
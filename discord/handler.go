@@ -1,17 +1,18 @@
 package discord
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/eraiza0816/llm-discord/chat"
 	"github.com/eraiza0816/llm-discord/config"
 	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
 )
 
 var errNoThreadID = errors.New("スレッドIDまたはチャンネルIDの取得に失敗しました")
@@ -20,7 +21,7 @@ var errNoThreadID = errors.New("スレッドIDまたはチャンネルIDの取
 func setupHandlers(s *discordgo.Session, cfg *config.Config, chatSvc chat.Service, historyMgr history.HistoryManager) (history.HistoryManager, chat.Service, error) {
 	var err error
 	if historyMgr == nil {
-		historyMgr, err = history.NewDuckDBHistoryManager()
+		historyMgr, err = history.NewDuckDBHistoryManager(cfg.Model.MaxHistorySize)
 		if err != nil {
 			log.Printf("DuckDBHistoryManager の初期化に失敗しました: %v", err)
 			return nil, nil, fmt.Errorf("DuckDBHistoryManager の初期化に失敗しました: %w", err)
@@ -62,15 +63,32 @@ func setupHandlers(s *discordgo.Session, cfg *config.Config, chatSvc chat.Servic
 	dispatcher := newCommandDispatcher()
 	dispatcher.Register(&chatCommand{chatSvc: chatSvc, cfg: cfg})
 	dispatcher.Register(&resetCommand{historyMgr: historyMgr})
+	dispatcher.Register(&summaryCommand{chatSvc: chatSvc, cfg: cfg})
 	dispatcher.Register(&aboutCommand{cfg: cfg})
-	dispatcher.Register(&editCommand{cfg: cfg})
+	customPromptStore, _ := historyMgr.(history.CustomPromptStore)
+	dispatcher.Register(&editCommand{cfg: cfg, chatSvc: chatSvc, store: customPromptStore})
+	dispatcher.Register(&ollamaCommand{cfg: cfg})
+	personaStore, _ := historyMgr.(history.PersonaStore)
+	dispatcher.Register(&personaCommand{cfg: cfg, store: personaStore})
+	memoryStore, _ := historyMgr.(history.MemoryStore)
+	dispatcher.Register(&memoryCommand{cfg: cfg, store: memoryStore})
 
 	s.AddHandler(onReady)
 	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if i.Type != discordgo.InteractionApplicationCommand {
-			return
+		switch i.Type {
+		case discordgo.InteractionApplicationCommand:
+			dispatcher.Dispatch(s, i)
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if err := dispatcher.DispatchAutocomplete(s, i); err != nil {
+				log.Printf("Autocomplete response error: %v", err)
+			}
+		case discordgo.InteractionModalSubmit:
+			if err := dispatcher.DispatchModal(s, i); err != nil {
+				log.Printf("Modal submit error: %v", err)
+			}
+		case discordgo.InteractionMessageComponent:
+			componentInteractionHandler(s, i, customPromptStore)
 		}
-		dispatcher.Dispatch(s, i)
 	})
 	return historyMgr, chatSvc, nil
 }
@@ -91,19 +109,26 @@ const (
 	MessageTypeReply
 	// MessageTypeSelf is a message from the bot itself.
 	MessageTypeSelf
+	// MessageTypeMention is a message in a guild channel that mentions the bot.
+	MessageTypeMention
 )
 
 // classifyMessageType determines the type of a message.
+// Messages sent through the bot's persona webhooks are treated as the bot's own.
 func classifyMessageType(s *discordgo.Session, m *discordgo.MessageCreate) MessageType {
-	if m.Author.ID == s.State.User.ID {
+	session := &discordgoSession{s}
+	if isOwnMessage(session, m.Message, s.State.User.ID) {
 		return MessageTypeSelf
 	}
 	if m.GuildID == "" {
 		return MessageTypeDM
 	}
-	if m.ReferencedMessage != nil && m.ReferencedMessage.Author != nil && m.ReferencedMessage.Author.ID == s.State.User.ID {
+	if isOwnMessage(session, m.ReferencedMessage, s.State.User.ID) {
 		return MessageTypeReply
 	}
+	if mentionsUser(m.Message, s.State.User.ID) {
+		return MessageTypeMention
+	}
 	return MessageTypeNormal
 }
 
@@ -126,7 +151,7 @@ func messageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreate, chat
 
 	// Resolve thread ID only when necessary
 	var threadID string
-	if messageType == MessageTypeReply {
+	if messageType == MessageTypeReply || messageType == MessageTypeMention {
 		threadID = resolveThreadID(wrappedSession, m.ChannelID)
 	} else {
 		threadID = m.ChannelID // For DMs or other cases
@@ -148,22 +173,34 @@ func handleMessageEvent(s DiscordSession, m *discordgo.MessageCreate, chatSvc ch
 	case MessageTypeReply:
 		log.Printf("Botへの返信を受信: UserID=%s, Username=%s, Content=%s, ReferencedMessageID=%s", m.Author.ID, m.Author.Username, m.Content, m.ReferencedMessage.ID)
 		handleReplyToBot(s, m, chatSvc, cfg, threadID, isBot)
-	case MessageTypeNormal:
-		jst := m.Timestamp
-		attachments := extractAttachmentURLs(m.Attachments)
-		err := history.LogMessageCreate(
-			m.ID,
-			m.ChannelID,
-			m.GuildID,
-			m.Author.ID,
-			m.Author.Username,
-			m.Content,
-			attachments,
-			jst,
-		)
-		if err != nil {
-			log.Printf("Failed to log message create event: %v", err)
+	case MessageTypeMention:
+		if cfg != nil && cfg.Model.Mention.Disabled {
+			logMessageCreate(m)
+			return
 		}
+		log.Printf("Botへのメンションを受信: UserID=%s, Username=%s, Content=%s", m.Author.ID, m.Author.Username, m.Content)
+		handleMention(s, m, chatSvc, cfg, threadID, isBot)
+	case MessageTypeNormal:
+		logMessageCreate(m)
+	}
+}
+
+// logMessageCreate は応答しないメッセージを監査ログに記録します。
+func logMessageCreate(m *discordgo.MessageCreate) {
+	jst := m.Timestamp
+	attachments := extractAttachmentURLs(m.Attachments)
+	err := history.LogMessageCreate(
+		m.ID,
+		m.ChannelID,
+		m.GuildID,
+		m.Author.ID,
+		m.Author.Username,
+		m.Content,
+		attachments,
+		jst,
+	)
+	if err != nil {
+		log.Printf("Failed to log message create event: %v", err)
 	}
 }
 
@@ -220,19 +257,43 @@ func handleDirectMessage(s DiscordSession, m *discordgo.MessageCreate, chatSvc c
 		return
 	}
 
-	responseText, _, _, err := chatSvc.GetResponse(context.Background(), m.Author.ID, m.ChannelID, m.Author.Username, m.Content, m.Timestamp.Format(time.RFC3339), cfg.Model.Prompts["default"], isBot)
+	ctx, pending, done := startPendingGeneration(s, m, cfg.Model.Timeouts.Command("dm"), nil, isBot)
+	defer done()
+
+	resp, err := chatSvc.GetResponse(ctx, chat.ChatParams{
+		UserID:      m.Author.ID,
+		ThreadID:    m.ChannelID,
+		Username:    m.Author.Username,
+		Message:     m.Content,
+		Timestamp:   m.Timestamp.Format(time.RFC3339),
+		Prompt:      cfg.Model.Prompts["default"],
+		IsBot:       isBot,
+		DisplayName: displayName(nil, m.Author),
+	})
 	if err != nil {
 		log.Printf("DM応答生成エラー: %v", err)
-		s.ChannelMessageSend(m.ChannelID, "応答の生成中にエラーが発生しました。")
+		if chat.IsStopped(err) {
+			deliverText(s, m.ChannelID, pending, stoppedReason(ctx, err)+"。")
+			return
+		}
+		if msg, ok := blockedErrorMessage(err); ok {
+			deliverText(s, m.ChannelID, pending, msg)
+			return
+		}
+		deliverText(s, m.ChannelID, pending, "応答の生成中にエラーが発生しました。")
 		return
 	}
-	if responseText == "" {
+	if resp.Text == "" {
+		if isBot {
+			log.Printf("Botからのメッセージへの応答が抑止されました。UserID=%s", m.Author.ID)
+			return
+		}
 		log.Printf("DM応答が空です。")
-		s.ChannelMessageSend(m.ChannelID, "応答がありませんでした。")
+		deliverText(s, m.ChannelID, pending, "応答がありませんでした。")
 		return
 	}
 
-	_, err = s.ChannelMessageSend(m.ChannelID, responseText)
+	_, err = deliverChatResponse(s, m.ChannelID, pending, markIncomplete(ctx, resp), cfg.Model.Reasoning.Display, nil)
 	if err != nil {
 		log.Printf("DM返信エラー: %v", err)
 	}
@@ -272,34 +333,93 @@ func resolveThreadID(s DiscordSession, channelID string) string {
 
 // handleReplyToBot はBotへの返信に対する応答を処理します
 func handleReplyToBot(s DiscordSession, m *discordgo.MessageCreate, chatSvc chat.Service, cfg *config.Config, threadID string, isBot bool) {
+	respondInGuild(s, m, chatSvc, cfg, threadID, isBot, guildTrigger{
+		command: "reply",
+		label:   "Botへの返信",
+		prepare: func(params *chat.ChatParams) {
+			if !cfg.Model.ReplyChain.Disabled {
+				params.ReplyChain = buildReplyChain(s, m.Message, s.BotUserID(), cfg.Model.ReplyChain)
+			}
+		},
+	})
+}
+
+// guildTrigger はサーバー内のメッセージに応答するきっかけ (返信・メンション) ごとの違いです。
+type guildTrigger struct {
+	command string                        // timeouts.command_seconds のキー
+	label   string                        // ログに使う名前
+	prepare func(params *chat.ChatParams) // 返信の連鎖やチャンネルの文脈など、きっかけに固有の情報を params に加える
+}
+
+// respondInGuild はサーバー内のメッセージ m に、生成中の表示と停止ボタンを出しながら返信で応答します。
+func respondInGuild(s DiscordSession, m *discordgo.MessageCreate, chatSvc chat.Service, cfg *config.Config, threadID string, isBot bool, trigger guildTrigger) {
 	if chatSvc == nil {
-		log.Println("Botへの返信処理エラー: chatSvcがnilです")
+		log.Printf("%s処理エラー: chatSvcがnilです", trigger.label)
 		s.ChannelMessageSend(m.ChannelID, "内部エラーにより応答できませんでした。")
 		return
 	}
 	if cfg == nil {
-		log.Println("Botへの返信処理エラー: cfgがnilです")
+		log.Printf("%s処理エラー: cfgがnilです", trigger.label)
 		s.ChannelMessageSend(m.ChannelID, "内部エラーにより応答できませんでした。")
 		return
 	}
 
+	ctx, pending, done := startPendingGeneration(s, m, cfg.Model.Timeouts.Command(trigger.command), m.Reference(), isBot)
+	defer done()
+
+	// Botとの会話ではサーバーを操作するツールを渡さない
+	var tools []chat.Tool
+	if !isBot {
+		tools = discordTools(s, m.GuildID, m.ChannelID, m.Author.ID, m.ID)
+	}
+
 	// 応答を生成
-	responseText, _, _, err := chatSvc.GetResponse(context.Background(), m.Author.ID, threadID, m.Author.Username, m.Content, m.Timestamp.Format(time.RFC3339), cfg.Model.Prompts["default"], isBot)
+	pc := lookupPromptContext(s, m.GuildID, m.ChannelID)
+	params := chat.ChatParams{
+		UserID:          m.Author.ID,
+		ThreadID:        threadID,
+		Username:        m.Author.Username,
+		Message:         m.Content,
+		Timestamp:       m.Timestamp.Format(time.RFC3339),
+		Prompt:          cfg.Model.Prompts["default"],
+		IsBot:           isBot,
+		Tools:           tools,
+		DisplayName:     displayName(m.Member, m.Author),
+		GuildName:       pc.GuildName,
+		ChannelName:     pc.ChannelName,
+		ThreadTitle:     pc.ThreadTitle,
+		ParentChannelID: pc.ParentID,
+		SharedThread:    cfg.Model.SharedThreads.Enabled(m.ChannelID, pc.ParentID),
+	}
+	trigger.prepare(&params)
+	resp, err := chatSvc.GetResponse(ctx, params)
 	if err != nil {
-		log.Printf("Botへの返信応答生成エラー: %v", err)
-		s.ChannelMessageSend(m.ChannelID, "応答の生成中にエラーが発生しました。")
+		log.Printf("%s応答生成エラー: %v", trigger.label, err)
+		if chat.IsStopped(err) {
+			deliverText(s, m.ChannelID, pending, stoppedReason(ctx, err)+"。")
+			return
+		}
+		if msg, ok := blockedErrorMessage(err); ok {
+			deliverText(s, m.ChannelID, pending, msg)
+			return
+		}
+		deliverText(s, m.ChannelID, pending, "応答の生成中にエラーが発生しました。")
 		return
 	}
-	if responseText == "" {
-		log.Printf("Botへの返信応答が空です。")
-		s.ChannelMessageSend(m.ChannelID, "応答がありませんでした。")
+	if resp.Text == "" {
+		if isBot {
+			log.Printf("Botからのメッセージへの応答が抑止されました。UserID=%s", m.Author.ID)
+			return
+		}
+		log.Printf("%s応答が空です。", trigger.label)
+		deliverText(s, m.ChannelID, pending, "応答がありませんでした。")
 		return
 	}
 
 	// 返信としてメッセージを送信
-	_, err = s.ChannelMessageSendReply(m.ChannelID, responseText, m.Reference())
+	_, err = deliverGuildResponse(s, m.ChannelID, pending, markIncomplete(ctx, resp), cfg.Model, m.Reference())
 	if err != nil {
-		log.Printf("Botへの返信送信エラー: %v", err)
+		log.Printf("%s送信エラー: %v", trigger.label, err)
 	}
 
 	// 履歴に記録
@@ -315,6 +435,114 @@ func handleReplyToBot(s DiscordSession, m *discordgo.MessageCreate, chatSvc chat
 		jst,
 	)
 	if err != nil {
-		log.Printf("Failed to log reply message create event: %v", err)
+		log.Printf("Failed to log %s message create event: %v", trigger.command, err)
+	}
+}
+
+// sendChatResponse は LLM の応答をチャンネルに送信します。reference が nil でない場合は返信として送信します。
+// reasoningDisplay に応じて、思考過程をスポイラーまたは別の Embed として添えます。
+func sendChatResponse(s DiscordSession, channelID string, resp *chat.ChatResponse, reasoningDisplay string, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	content, embeds := chatResponseContent(resp, reasoningDisplay)
+	files := responseFiles(resp)
+	if len(embeds) == 0 && len(files) == 0 {
+		if reference != nil {
+			return s.ChannelMessageSendReply(channelID, content, reference)
+		}
+		return s.ChannelMessageSend(channelID, content)
+	}
+	return s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:   content,
+		Embeds:    embeds,
+		Files:     files,
+		Reference: reference,
+	})
+}
+
+// deliverChatResponse は pending (生成中の仮メッセージ) があればそれを応答で置き換え、無ければ sendChatResponse で送信します。
+func deliverChatResponse(s DiscordSession, channelID string, pending *discordgo.Message, resp *chat.ChatResponse, reasoningDisplay string, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	if pending == nil {
+		return sendChatResponse(s, channelID, resp, reasoningDisplay, reference)
+	}
+	content, embeds := chatResponseContent(resp, reasoningDisplay)
+	return s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         pending.ID,
+		Channel:    channelID,
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &[]discordgo.MessageComponent{},
+		Files:      responseFiles(resp),
+	})
+}
+
+// deliverGuildResponse はペルソナでの応答をペルソナの Webhook から送信し、pending を削除します。
+// ペルソナを使わなかった場合や Webhook を使えない場合は、deliverChatResponse で Bot 自身の返信として送信します。
+// Webhook のメッセージは返信にできないため、reference は Bot 自身の返信の場合のみ使います。
+func deliverGuildResponse(s DiscordSession, channelID string, pending *discordgo.Message, resp *chat.ChatResponse, modelCfg *loader.ModelConfig, reference *discordgo.MessageReference) (*discordgo.Message, error) {
+	if resp.PersonaName != "" && !modelCfg.PersonaWebhooks.Disabled {
+		msg, err := sendPersonaResponse(s, channelID, resp, modelCfg.Reasoning.Display)
+		if err == nil {
+			if pending != nil {
+				if err := s.ChannelMessageDelete(channelID, pending.ID); err != nil {
+					log.Printf("生成中メッセージの削除に失敗しました: %v", err)
+				}
+			}
+			return msg, nil
+		}
+		log.Printf("ペルソナの Webhook を使えないため、Bot の返信として送信します: %v", err)
+	}
+	return deliverChatResponse(s, channelID, pending, resp, modelCfg.Reasoning.Display, reference)
+}
+
+// deliverText は pending があればその本文を text に置き換え、無ければ text を送信します。
+func deliverText(s DiscordSession, channelID string, pending *discordgo.Message, text string) {
+	var err error
+	if pending == nil {
+		_, err = s.ChannelMessageSend(channelID, text)
+	} else {
+		_, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         pending.ID,
+			Channel:    channelID,
+			Content:    &text,
+			Components: &[]discordgo.MessageComponent{},
+		})
+	}
+	if err != nil {
+		log.Printf("メッセージの送信に失敗しました: %v", err)
+	}
+}
+
+// chatResponseContent は応答を Discord のメッセージ本文と Embed に変換します。
+func chatResponseContent(resp *chat.ChatResponse, reasoningDisplay string) (string, []*discordgo.MessageEmbed) {
+	const maxContentLength = 2000
+
+	content := resp.Text
+	var embeds []*discordgo.MessageEmbed
+	if resp.Reasoning != "" {
+		switch reasoningDisplay {
+		case loader.ReasoningDisplaySpoiler:
+			available := maxContentLength - len([]rune(content)) - 1
+			if spoiler := reasoningSpoiler(resp.Reasoning, resp.ReasoningTokens, available); spoiler != "" {
+				content = spoiler + "\n" + content
+			}
+		case loader.ReasoningDisplayEmbed:
+			embeds = append(embeds, buildReasoningEmbed(resp.Reasoning, resp.ReasoningTokens))
+		}
+	}
+	if resp.Grounding != nil {
+		embeds = append(embeds, buildGroundingEmbed(resp.Grounding))
+	}
+	return content, embeds
+}
+
+// responseFiles は応答に添付するファイルを Discord の添付ファイルに変換します。
+func responseFiles(resp *chat.ChatResponse) []*discordgo.File {
+	files := make([]*discordgo.File, 0, len(resp.Files))
+	for _, f := range resp.Files {
+		files = append(files, &discordgo.File{
+			Name:        f.Name,
+			ContentType: "text/plain",
+			Reader:      strings.NewReader(f.Content),
+		})
 	}
+	return files
 }
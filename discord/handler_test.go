@@ -2,17 +2,22 @@ package discord
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
 	"github.com/eraiza0816/llm-discord/config"
 	"github.com/eraiza0816/llm-discord/history"
 	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/google/generative-ai-go/genai"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,9 +27,40 @@ type MockChatService struct {
 	mock.Mock
 }
 
-func (m *MockChatService) GetResponse(ctx context.Context, userID, threadID, username, message, timestamp, prompt string, isBot bool) (string, float64, string, error) {
-	args := m.Called(ctx, userID, threadID, username, message, timestamp, prompt, isBot)
-	return args.String(0), args.Get(1).(float64), args.String(2), args.Error(3)
+func (m *MockChatService) GetResponse(ctx context.Context, params chat.ChatParams) (*chat.ChatResponse, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*chat.ChatResponse), args.Error(1)
+}
+
+func (m *MockChatService) GetStructured(ctx context.Context, prompt string, schema map[string]interface{}) (json.RawMessage, error) {
+	args := m.Called(ctx, prompt, schema)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(json.RawMessage), args.Error(1)
+}
+
+func (m *MockChatService) GetSummary(userID, threadID string) (history.Summary, bool, error) {
+	args := m.Called(userID, threadID)
+	return args.Get(0).(history.Summary), args.Bool(1), args.Error(2)
+}
+
+func (m *MockChatService) RegenerateSummary(ctx context.Context, userID, threadID string) (history.Summary, error) {
+	args := m.Called(ctx, userID, threadID)
+	return args.Get(0).(history.Summary), args.Error(1)
+}
+
+func (m *MockChatService) PreviewSystemPrompt(params chat.ChatParams, custom string) (string, error) {
+	args := m.Called(params, custom)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockChatService) CheckCustomPrompt(ctx context.Context, custom string) (bool, string, error) {
+	args := m.Called(ctx, custom)
+	return args.Bool(0), args.String(1), args.Error(2)
 }
 
 func (m *MockChatService) Close() {
@@ -79,6 +115,16 @@ func (m *MockDiscordSession) ChannelMessageSendReply(channelID, content string,
 	return args.Get(0).(*discordgo.Message), args.Error(1)
 }
 
+func (m *MockDiscordSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	args := m.Called(channelID, data)
+	return args.Get(0).(*discordgo.Message), args.Error(1)
+}
+
+func (m *MockDiscordSession) ChannelMessageEditComplex(data *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	args := m.Called(data)
+	return args.Get(0).(*discordgo.Message), args.Error(1)
+}
+
 func (m *MockDiscordSession) StateChannel(channelID string) (*discordgo.Channel, error) {
 	args := m.Called(channelID)
 	if args.Get(0) == nil {
@@ -95,6 +141,114 @@ func (m *MockDiscordSession) Channel(channelID string, options ...discordgo.Requ
 	return args.Get(0).(*discordgo.Channel), args.Error(1)
 }
 
+func (m *MockDiscordSession) StateGuild(guildID string) (*discordgo.Guild, error) {
+	args := m.Called(guildID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Guild), args.Error(1)
+}
+
+func (m *MockDiscordSession) Guild(guildID string, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	args := m.Called(guildID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Guild), args.Error(1)
+}
+
+func (m *MockDiscordSession) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	args := m.Called(channelID, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Message), args.Error(1)
+}
+
+func (m *MockDiscordSession) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	args := m.Called(channelID, messageID)
+	return args.Error(0)
+}
+
+func (m *MockDiscordSession) ChannelWebhooks(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Webhook, error) {
+	args := m.Called(channelID)
+	return args.Get(0).([]*discordgo.Webhook), args.Error(1)
+}
+
+func (m *MockDiscordSession) WebhookCreate(channelID, name, avatar string, options ...discordgo.RequestOption) (*discordgo.Webhook, error) {
+	args := m.Called(channelID, name, avatar)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Webhook), args.Error(1)
+}
+
+func (m *MockDiscordSession) Webhook(webhookID string, options ...discordgo.RequestOption) (*discordgo.Webhook, error) {
+	args := m.Called(webhookID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Webhook), args.Error(1)
+}
+
+func (m *MockDiscordSession) WebhookThreadExecute(webhookID, token string, wait bool, threadID string, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	args := m.Called(webhookID, token, wait, threadID, data)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Message), args.Error(1)
+}
+
+func (m *MockDiscordSession) BotUserID() string {
+	return m.Called().String(0)
+}
+
+func (m *MockDiscordSession) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	args := m.Called(userID, channelID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDiscordSession) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	args := m.Called(channelID, limit, beforeID, afterID, aroundID)
+	return args.Get(0).([]*discordgo.Message), args.Error(1)
+}
+
+func (m *MockDiscordSession) ChannelMessagesPinned(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	args := m.Called(channelID)
+	return args.Get(0).([]*discordgo.Message), args.Error(1)
+}
+
+func (m *MockDiscordSession) GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	args := m.Called(guildID, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Member), args.Error(1)
+}
+
+func (m *MockDiscordSession) GuildMembersSearch(guildID, query string, limit int, options ...discordgo.RequestOption) ([]*discordgo.Member, error) {
+	args := m.Called(guildID, query, limit)
+	return args.Get(0).([]*discordgo.Member), args.Error(1)
+}
+
+func (m *MockDiscordSession) GuildRoles(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Role, error) {
+	args := m.Called(guildID)
+	return args.Get(0).([]*discordgo.Role), args.Error(1)
+}
+
+func (m *MockDiscordSession) ThreadStart(channelID, name string, typ discordgo.ChannelType, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	args := m.Called(channelID, name, typ, archiveDuration)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*discordgo.Channel), args.Error(1)
+}
+
+func (m *MockDiscordSession) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	args := m.Called(channelID, messageID, emojiID)
+	return args.Error(0)
+}
+
 func TestHandleMessageEvent(t *testing.T) {
 	// Common setup
 	mockCfg := &config.Config{
@@ -123,8 +277,15 @@ func TestHandleMessageEvent(t *testing.T) {
 				Timestamp: time.Now(),
 			},
 		}
-		mockChatSvc.On("GetResponse", mock.Anything, "user_id", "dm_channel_id", "user", "hello", mock.Anything, "default prompt", false).Return("response", 1.0, "model", nil).Once()
-		mockSession.On("ChannelMessageSend", "dm_channel_id", "response").Return(&discordgo.Message{}, nil).Once()
+		mockChatSvc.On("GetResponse", mock.Anything, mock.MatchedBy(func(p chat.ChatParams) bool {
+			return p.UserID == "user_id" && p.ThreadID == "dm_channel_id" && p.Username == "user" && p.Message == "hello" && p.Prompt == "default prompt" && !p.IsBot
+		})).Return(&chat.ChatResponse{Text: "response", ElapsedMs: 1.0, ModelName: "model"}, nil).Once()
+		mockSession.On("ChannelMessageSendComplex", "dm_channel_id", mock.MatchedBy(func(data *discordgo.MessageSend) bool {
+			return data.Content == pendingMessage && len(data.Components) == 1 && data.Reference == nil
+		})).Return(&discordgo.Message{ID: "pending_id"}, nil).Once()
+		mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+			return data.ID == "pending_id" && data.Channel == "dm_channel_id" && *data.Content == "response" && len(*data.Components) == 0
+		})).Return(&discordgo.Message{}, nil).Once()
 
 		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeDM, "dm_channel_id", false)
 
@@ -141,6 +302,7 @@ func TestHandleMessageEvent(t *testing.T) {
 				ChannelID: "channel_id",
 				GuildID:   "guild_id",
 				Author:    &discordgo.User{ID: "user_id", Username: "user"},
+				Member:    &discordgo.Member{Nick: "nick"},
 				Content:   "hello again",
 				Timestamp: time.Now(),
 				ReferencedMessage: &discordgo.Message{
@@ -148,8 +310,21 @@ func TestHandleMessageEvent(t *testing.T) {
 				},
 			},
 		}
-		mockChatSvc.On("GetResponse", mock.Anything, "user_id", "thread_id", "user", "hello again", mock.Anything, "default prompt", false).Return("response", 1.0, "model", nil).Once()
-		mockSession.On("ChannelMessageSendReply", "channel_id", "response", m.Reference()).Return(&discordgo.Message{}, nil).Once()
+		mockChatSvc.On("GetResponse", mock.Anything, mock.MatchedBy(func(p chat.ChatParams) bool {
+			return p.UserID == "user_id" && p.ThreadID == "thread_id" && p.Username == "user" && p.Message == "hello again" && p.Prompt == "default prompt" && !p.IsBot && len(p.Tools) == 5 &&
+				p.DisplayName == "nick" && p.GuildName == "guild" && p.ChannelName == "general" && p.ThreadTitle == "thread" && p.ParentChannelID == "parent_id"
+		})).Return(&chat.ChatResponse{Text: "response", ElapsedMs: 1.0, ModelName: "model"}, nil).Once()
+		mockSession.On("BotUserID").Return("bot_id")
+		mockSession.On("StateGuild", "guild_id").Return(&discordgo.Guild{ID: "guild_id", Name: "guild"}, nil).Once()
+		mockSession.On("StateChannel", "channel_id").Return(&discordgo.Channel{ID: "channel_id", Name: "thread", Type: discordgo.ChannelTypeGuildPublicThread, ParentID: "parent_id"}, nil).Once()
+		mockSession.On("StateChannel", "parent_id").Return(nil, errors.New("not in state")).Once()
+		mockSession.On("Channel", "parent_id").Return(&discordgo.Channel{ID: "parent_id", Name: "general"}, nil).Once()
+		mockSession.On("ChannelMessageSendComplex", "channel_id", mock.MatchedBy(func(data *discordgo.MessageSend) bool {
+			return data.Content == pendingMessage && assert.ObjectsAreEqual(m.Reference(), data.Reference)
+		})).Return(&discordgo.Message{ID: "pending_id"}, nil).Once()
+		mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+			return data.ID == "pending_id" && *data.Content == "response"
+		})).Return(&discordgo.Message{}, nil).Once()
 
 		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeReply, "thread_id", false)
 
@@ -157,6 +332,81 @@ func TestHandleMessageEvent(t *testing.T) {
 		mockSession.AssertExpectations(t)
 	})
 
+	t.Run("DM from bot is sent without stop button", func(t *testing.T) {
+		mockChatSvc := new(MockChatService)
+		mockSession := new(MockDiscordSession)
+		m := &discordgo.MessageCreate{
+			Message: &discordgo.Message{
+				ID:        "msg_id",
+				ChannelID: "dm_channel_id",
+				Author:    &discordgo.User{ID: "bot_user_id", Username: "other bot", Bot: true},
+				Content:   "hello",
+				Timestamp: time.Now(),
+			},
+		}
+		mockChatSvc.On("GetResponse", mock.Anything, mock.Anything).Return(&chat.ChatResponse{Text: "response"}, nil).Once()
+		mockSession.On("ChannelMessageSend", "dm_channel_id", "response").Return(&discordgo.Message{}, nil).Once()
+
+		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeDM, "dm_channel_id", true)
+
+		mockChatSvc.AssertExpectations(t)
+		mockSession.AssertExpectations(t)
+		mockSession.AssertNotCalled(t, "ChannelMessageSendComplex", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Stopped DM keeps partial response", func(t *testing.T) {
+		mockChatSvc := new(MockChatService)
+		mockSession := new(MockDiscordSession)
+		m := &discordgo.MessageCreate{
+			Message: &discordgo.Message{
+				ID:        "stopped_msg_id",
+				ChannelID: "dm_channel_id",
+				Author:    &discordgo.User{ID: "user_id", Username: "user"},
+				Content:   "hello",
+				Timestamp: time.Now(),
+			},
+		}
+		mockSession.On("ChannelMessageSendComplex", "dm_channel_id", mock.Anything).Return(&discordgo.Message{ID: "pending_id"}, nil).Once()
+		mockChatSvc.On("GetResponse", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			// 停止ボタンが押されたときと同じく、生成中に所有者が停止する
+			assert.NoError(t, generations.stop("stopped_msg_id", "user_id", false))
+			<-args.Get(0).(context.Context).Done()
+		}).Return(&chat.ChatResponse{Text: "partial", Incomplete: true}, nil).Once()
+		mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+			return *data.Content == "partial\n（生成を停止しました）" && len(*data.Components) == 0
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeDM, "dm_channel_id", false)
+
+		mockChatSvc.AssertExpectations(t)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("Blocked DM explains the reason", func(t *testing.T) {
+		mockChatSvc := new(MockChatService)
+		mockSession := new(MockDiscordSession)
+		m := &discordgo.MessageCreate{
+			Message: &discordgo.Message{
+				ID:        "blocked_msg_id",
+				ChannelID: "dm_channel_id",
+				Author:    &discordgo.User{ID: "user_id", Username: "user"},
+				Content:   "hello",
+				Timestamp: time.Now(),
+			},
+		}
+		blocked := &chat.ContentBlockedError{Kind: loader.BlockKindSafety, Model: "model", Err: &genai.BlockedError{}}
+		mockSession.On("ChannelMessageSendComplex", "dm_channel_id", mock.Anything).Return(&discordgo.Message{ID: "pending_id"}, nil).Once()
+		mockChatSvc.On("GetResponse", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Gemini APIからのエラー: %w", blocked)).Once()
+		mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+			return *data.Content == blocked.UserMessage()
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeDM, "dm_channel_id", false)
+
+		mockChatSvc.AssertExpectations(t)
+		mockSession.AssertExpectations(t)
+	})
+
 	t.Run("Ignore self message", func(t *testing.T) {
 		mockChatSvc := new(MockChatService)
 		mockSession := new(MockDiscordSession)
@@ -166,7 +416,7 @@ func TestHandleMessageEvent(t *testing.T) {
 
 		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeSelf, "any_id", false)
 
-		mockChatSvc.AssertNotCalled(t, "GetResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		mockChatSvc.AssertNotCalled(t, "GetResponse", mock.Anything, mock.Anything)
 	})
 
 	t.Run("Normal message (log only)", func(t *testing.T) {
@@ -187,7 +437,81 @@ func TestHandleMessageEvent(t *testing.T) {
 
 		handleMessageEvent(mockSession, m, mockChatSvc, mockCfg, MessageTypeNormal, "channel_id", false)
 
-		mockChatSvc.AssertNotCalled(t, "GetResponse", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		mockChatSvc.AssertNotCalled(t, "GetResponse", mock.Anything, mock.Anything)
+	})
+}
+
+func TestSendChatResponse(t *testing.T) {
+	resp := &chat.ChatResponse{Text: "answer", Reasoning: "thinking", ReasoningTokens: 2}
+
+	t.Run("hidden reasoning sends answer only", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessageSend", "channel_id", "answer").Return(&discordgo.Message{}, nil).Once()
+
+		_, err := sendChatResponse(mockSession, "channel_id", resp, loader.ReasoningDisplayHidden, nil)
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("spoiler reasoning is prepended", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		ref := &discordgo.MessageReference{MessageID: "msg_id"}
+		mockSession.On("ChannelMessageSendReply", "channel_id", mock.MatchedBy(func(content string) bool {
+			return strings.Contains(content, "||thinking||") && strings.HasSuffix(content, "\nanswer")
+		}), ref).Return(&discordgo.Message{}, nil).Once()
+
+		_, err := sendChatResponse(mockSession, "channel_id", resp, loader.ReasoningDisplaySpoiler, ref)
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("embed reasoning is sent as a separate embed", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessageSendComplex", "channel_id", mock.MatchedBy(func(data *discordgo.MessageSend) bool {
+			return data.Content == "answer" && len(data.Embeds) == 1 && data.Embeds[0].Description == "thinking"
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		_, err := sendChatResponse(mockSession, "channel_id", resp, loader.ReasoningDisplayEmbed, nil)
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("files are attached", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		withFile := &chat.ChatResponse{Text: "answer", Files: []chat.ResponseFile{{Name: "output_1.txt", Content: "long output"}}}
+		mockSession.On("ChannelMessageSendComplex", "channel_id", mock.MatchedBy(func(data *discordgo.MessageSend) bool {
+			if len(data.Files) != 1 || data.Files[0].Name != "output_1.txt" {
+				return false
+			}
+			b, _ := io.ReadAll(data.Files[0].Reader)
+			return data.Content == "answer" && string(b) == "long output"
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		_, err := sendChatResponse(mockSession, "channel_id", withFile, loader.ReasoningDisplayHidden, nil)
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("grounding sources are listed in an extra embed", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		grounded := &chat.ChatResponse{Text: "answer", Grounding: &history.Grounding{
+			Queries: []string{"query"},
+			Sources: []history.GroundingSource{{Title: "a [b]", URI: "https://example.com/a"}, {URI: "https://example.com/c"}},
+		}}
+		mockSession.On("ChannelMessageSendComplex", "channel_id", mock.MatchedBy(func(data *discordgo.MessageSend) bool {
+			return data.Content == "answer" && len(data.Embeds) == 1 &&
+				data.Embeds[0].Description == "1. [a \\[b\\]](https://example.com/a)\n2. [https://example.com/c](https://example.com/c)" &&
+				data.Embeds[0].Footer.Text == "検索: query"
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		_, err := sendChatResponse(mockSession, "channel_id", grounded, loader.ReasoningDisplayHidden, nil)
+
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
 	})
 }
 
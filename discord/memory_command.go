@@ -0,0 +1,143 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// memoryCommand implements the /memory command.
+type memoryCommand struct {
+	cfg   *config.Config
+	store history.MemoryStore // nil の場合は記憶を使えない
+}
+
+func (c *memoryCommand) Name() string { return "memory" }
+
+func (c *memoryCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	memoryCommandHandler(s, i, c.cfg, c.store)
+	return nil
+}
+
+func memoryCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, cfg *config.Config, store history.MemoryStore) {
+	if cfg == nil || cfg.Model == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("モデル設定が読み込まれていません。"))
+		return
+	}
+	if store == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("このBotでは記憶を使えません。"))
+		return
+	}
+	var user *discordgo.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	} else if i.User != nil {
+		user = i.User
+	} else {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("ユーザー情報が取得できませんでした。"))
+		return
+	}
+
+	sub := i.ApplicationCommandData().Options[0]
+	req := memoryRequest{subcommand: sub.Name, userID: user.ID}
+	for _, opt := range sub.Options {
+		if opt.Name == "id" {
+			req.id = opt.IntValue()
+		}
+	}
+	log.Printf("User %s performed /memory %s in channel %s", user.Username, sub.Name, i.ChannelID)
+
+	content, err := runMemoryCommand(store, cfg.Model, req)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		log.Printf("InteractionRespond error: %v", err)
+	}
+}
+
+// memoryRequest は /memory の1回の実行内容です。
+type memoryRequest struct {
+	subcommand string // "list" / "forget" / "off" / "on"
+	id         int64  // "forget" で忘れる記憶の番号。0 の場合は全て忘れる
+	userID     string
+}
+
+// runMemoryCommand は req を実行し、実行したユーザーに表示するメッセージを返します。
+// memory が無効な設定でも、保存済みの記憶を消せるよう "forget" だけは実行できます。
+func runMemoryCommand(store history.MemoryStore, modelCfg *loader.ModelConfig, req memoryRequest) (string, error) {
+	if !modelCfg.Memory.Enabled && req.subcommand != "forget" {
+		return "", errors.New("このBotでは記憶が有効になっていません。")
+	}
+	switch req.subcommand {
+	case "list":
+		return describeMemories(store, req.userID)
+	case "forget":
+		if req.id == 0 {
+			if err := store.ForgetMemories(req.userID); err != nil {
+				return "", fmt.Errorf("記憶の削除に失敗しました: %w", err)
+			}
+			return "あなたについての記憶を全て忘れました。", nil
+		}
+		memories, err := store.ListMemories(req.userID)
+		if err != nil {
+			return "", fmt.Errorf("記憶の取得に失敗しました: %w", err)
+		}
+		for _, memory := range memories {
+			if memory.ID == req.id {
+				if err := store.ForgetMemories(req.userID, req.id); err != nil {
+					return "", fmt.Errorf("記憶の削除に失敗しました: %w", err)
+				}
+				return fmt.Sprintf("「%s」を忘れました。", memory.Fact), nil
+			}
+		}
+		return "", fmt.Errorf("番号 %d の記憶はありません。`/memory list` で番号を確認してください。", req.id)
+	case "off":
+		if err := store.SetMemoryEnabled(req.userID, false); err != nil {
+			return "", fmt.Errorf("記憶の設定の保存に失敗しました: %w", err)
+		}
+		return "これからはあなたについて新しく覚えず、覚えていることも会話に使いません。保存済みの記憶を消すには `/memory forget` を使ってください。", nil
+	case "on":
+		if err := store.SetMemoryEnabled(req.userID, true); err != nil {
+			return "", fmt.Errorf("記憶の設定の保存に失敗しました: %w", err)
+		}
+		return "これからは会話の中であなたについて覚えたことを使います。", nil
+	}
+	return "", fmt.Errorf("不明なサブコマンドです: %s", req.subcommand)
+}
+
+// describeMemories は userID の記憶の一覧を、番号付きで返します。
+func describeMemories(store history.MemoryStore, userID string) (string, error) {
+	enabled, err := store.MemoryEnabled(userID)
+	if err != nil {
+		return "", fmt.Errorf("記憶の設定の取得に失敗しました: %w", err)
+	}
+	memories, err := store.ListMemories(userID)
+	if err != nil {
+		return "", fmt.Errorf("記憶の取得に失敗しました: %w", err)
+	}
+
+	var b strings.Builder
+	if !enabled {
+		b.WriteString("記憶は無効になっています (`/memory on` で有効にできます)。\n")
+	}
+	if len(memories) == 0 {
+		b.WriteString("あなたについて覚えていることはありません。")
+		return b.String(), nil
+	}
+	b.WriteString("あなたについて覚えていること (番号は `/memory forget` で使います):\n")
+	for _, memory := range memories {
+		fmt.Fprintf(&b, "- `%d` %s\n", memory.ID, memory.Fact)
+	}
+	return truncateRunes(strings.TrimRight(b.String(), "\n"), 2000), nil
+}
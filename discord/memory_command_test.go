@@ -0,0 +1,56 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMemoryCommand(t *testing.T) {
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.MemoryStore)
+	modelCfg := &loader.ModelConfig{Memory: loader.MemoryConfig{Enabled: true}}
+	run := func(subcommand string, id int64) (string, error) {
+		return runMemoryCommand(store, modelCfg, memoryRequest{subcommand: subcommand, id: id, userID: "u1"})
+	}
+
+	content, err := run("list", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "あなたについて覚えていることはありません。", content)
+
+	store.AddMemories("u1", "名前は太郎", "猫が好き")
+	memories, _ := store.ListMemories("u1")
+	content, err = run("list", 0)
+	assert.NoError(t, err)
+	assert.Contains(t, content, "名前は太郎")
+	assert.Contains(t, content, "猫が好き")
+
+	content, err = run("forget", memories[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "「名前は太郎」を忘れました。", content)
+	_, err = run("forget", memories[0].ID)
+	assert.Error(t, err, "already forgotten memory")
+	remaining, _ := store.ListMemories("u1")
+	assert.Len(t, remaining, 1)
+
+	_, err = run("off", 0)
+	assert.NoError(t, err)
+	enabled, _ := store.MemoryEnabled("u1")
+	assert.False(t, enabled)
+	content, _ = run("list", 0)
+	assert.Contains(t, content, "記憶は無効になっています")
+	run("on", 0)
+	enabled, _ = store.MemoryEnabled("u1")
+	assert.True(t, enabled)
+
+	// 設定で無効になっていても、保存済みの記憶は消せる
+	modelCfg.Memory.Enabled = false
+	_, err = run("list", 0)
+	assert.Error(t, err)
+	_, err = run("forget", 0)
+	assert.NoError(t, err)
+	remaining, _ = store.ListMemories("u1")
+	assert.Empty(t, remaining)
+}
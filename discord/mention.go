@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/config"
+)
+
+// handleMention はBotへのメンションに、チャンネルの最近の会話を踏まえて返信で応答します
+func handleMention(s DiscordSession, m *discordgo.MessageCreate, chatSvc chat.Service, cfg *config.Config, threadID string, isBot bool) {
+	respondInGuild(s, m, chatSvc, cfg, threadID, isBot, guildTrigger{
+		command: "mention",
+		label:   "メンション",
+		prepare: func(params *chat.ChatParams) {
+			botID := s.BotUserID()
+			params.Message = stripUserMention(m.Content, botID)
+			params.ChannelContext = recentChannelContext(s, m.Message, botID, cfg.Model.Mention.ContextLimit(), cfg.Model.Location())
+		},
+	})
+}
+
+// mentionsUser は msg が userID のユーザーをメンションしているかを返します。
+func mentionsUser(msg *discordgo.Message, userID string) bool {
+	for _, user := range msg.Mentions {
+		if user != nil && user.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// stripUserMention は content から userID へのメンションを取り除きます。メンションしか無い場合はそのまま返します。
+func stripUserMention(content, userID string) string {
+	stripped := strings.NewReplacer("<@"+userID+">", "", "<@!"+userID+">", "").Replace(content)
+	stripped = strings.Join(strings.Fields(stripped), " ")
+	if stripped == "" {
+		return content
+	}
+	return stripped
+}
+
+// recentChannelContext は m より前のチャンネルのメッセージを最大 limit 件、古い順のテキストにします。
+// Bot がメッセージ履歴を読めないチャンネルでは空文字列を返し、本文を読めないメッセージ (システムメッセージや
+// Message Content Intent が無く本文が空のものなど) は飛ばします。
+func recentChannelContext(s DiscordSession, m *discordgo.Message, botID string, limit int, loc *time.Location) string {
+	if limit <= 0 {
+		return ""
+	}
+	const required = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory
+	perms, err := s.UserChannelPermissions(botID, m.ChannelID)
+	if err != nil {
+		log.Printf("チャンネル %s での Bot の権限を確認できなかったため、最近の会話を含めません: %v", m.ChannelID, err)
+		return ""
+	}
+	if perms&required != required {
+		log.Printf("Bot はチャンネル %s のメッセージ履歴を読めないため、最近の会話を含めません", m.ChannelID)
+		return ""
+	}
+
+	messages, err := s.ChannelMessages(m.ChannelID, limit, m.ID, "", "")
+	if err != nil {
+		log.Printf("チャンネル %s の最近のメッセージの取得に失敗しました: %v", m.ChannelID, err)
+		return ""
+	}
+	// API は新しい順に返すため、会話として読みやすい古い順に並べ替える
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+
+	var b strings.Builder
+	for _, msg := range messages {
+		if msg.Type != discordgo.MessageTypeDefault && msg.Type != discordgo.MessageTypeReply {
+			continue
+		}
+		text := messageText(msg)
+		if len(msg.Attachments) > 0 {
+			text = strings.TrimSpace(fmt.Sprintf("%s (添付ファイル %d 件)", text, len(msg.Attachments)))
+		}
+		if text == "" || msg.Author == nil {
+			continue
+		}
+		name := displayName(msg.Member, msg.Author)
+		if isOwnMessage(s, msg, botID) {
+			name += " (あなた)"
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.In(loc).Format("01/02 15:04"), name, text)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
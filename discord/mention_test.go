@@ -0,0 +1,114 @@
+package discord
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStripUserMention(t *testing.T) {
+	assert.Equal(t, "今の話どう思う？", stripUserMention("<@bot> 今の話どう思う？", "bot"))
+	assert.Equal(t, "ねえ どう？", stripUserMention("ねえ <@!bot> どう？", "bot"))
+	assert.Equal(t, "<@bot>", stripUserMention("<@bot>", "bot"))
+}
+
+func TestRecentChannelContext(t *testing.T) {
+	const readable = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory
+	base := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	mention := &discordgo.Message{ID: "m9", ChannelID: "channel"}
+
+	t.Run("formats visible messages oldest first", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "bot", "channel").Return(int64(readable), nil).Once()
+		mockSession.On("ChannelMessages", "channel", 5, "m9", "", "").Return([]*discordgo.Message{
+			{ID: "m4", Author: &discordgo.User{ID: "bot", Username: "bot"}, Content: "こんにちは", Timestamp: base.Add(4 * time.Minute)},
+			{ID: "m3", Author: &discordgo.User{ID: "u2", Username: "bob"}, Content: "", Timestamp: base.Add(3 * time.Minute)},
+			{ID: "m2", Author: &discordgo.User{ID: "u1", Username: "alice"}, Type: discordgo.MessageTypeChannelPinnedMessage, Timestamp: base.Add(2 * time.Minute)},
+			{ID: "m1", Author: &discordgo.User{ID: "u1", Username: "alice"}, Member: &discordgo.Member{Nick: "アリス"}, Content: "ランチどこ行く？",
+				Attachments: []*discordgo.MessageAttachment{{}}, Timestamp: base.Add(time.Minute)},
+		}, nil).Once()
+
+		got := recentChannelContext(mockSession, mention, "bot", 5, time.UTC)
+
+		assert.Equal(t, "[10/18 03:01] アリス: ランチどこ行く？ (添付ファイル 1 件)\n[10/18 03:04] bot (あなた): こんにちは", got)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("skips the channel when the bot cannot read history", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "bot", "channel").Return(int64(discordgo.PermissionViewChannel), nil).Once()
+
+		assert.Empty(t, recentChannelContext(mockSession, mention, "bot", 5, time.UTC))
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("skips the channel when permissions are unknown", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("UserChannelPermissions", "bot", "channel").Return(int64(0), errors.New("no state")).Once()
+
+		assert.Empty(t, recentChannelContext(mockSession, mention, "bot", 5, time.UTC))
+	})
+
+	t.Run("disabled by a zero limit", func(t *testing.T) {
+		assert.Empty(t, recentChannelContext(new(MockDiscordSession), mention, "bot", 0, time.UTC))
+	})
+}
+
+func TestHandleMessageEventMention(t *testing.T) {
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(originalOutput) })
+
+	cfg := &config.Config{Model: &loader.ModelConfig{
+		Prompts: map[string]string{"default": "default prompt"},
+		Mention: loader.MentionConfig{ContextMessages: 3},
+	}}
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "msg_id",
+		ChannelID: "channel_id",
+		GuildID:   "guild_id",
+		Author:    &discordgo.User{ID: "user_id", Username: "user"},
+		Content:   "<@bot_id> どう思う？",
+		Mentions:  []*discordgo.User{{ID: "bot_id"}},
+		Timestamp: time.Now(),
+	}}
+
+	mockChatSvc := new(MockChatService)
+	mockSession := new(MockDiscordSession)
+	mockSession.On("BotUserID").Return("bot_id")
+	mockSession.On("StateGuild", "guild_id").Return(&discordgo.Guild{Name: "guild"}, nil).Once()
+	mockSession.On("StateChannel", "channel_id").Return(&discordgo.Channel{ID: "channel_id", Name: "general"}, nil).Once()
+	mockSession.On("UserChannelPermissions", "bot_id", "channel_id").Return(int64(discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory), nil).Once()
+	mockSession.On("ChannelMessages", "channel_id", 3, "msg_id", "", "").Return([]*discordgo.Message{
+		{ID: "older", Author: &discordgo.User{ID: "u2", Username: "bob"}, Content: "週末は雨らしい", Timestamp: time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)},
+	}, nil).Once()
+	mockSession.On("ChannelMessageSendComplex", "channel_id", mock.Anything).Return(&discordgo.Message{ID: "pending_id"}, nil).Once()
+	mockChatSvc.On("GetResponse", mock.Anything, mock.MatchedBy(func(p chat.ChatParams) bool {
+		return p.Message == "どう思う？" && p.ThreadID == "channel_id" && p.ChannelName == "general" &&
+			p.ChannelContext == "[10/18 12:00] bob: 週末は雨らしい" && len(p.ReplyChain) == 0
+	})).Return(&chat.ChatResponse{Text: "傘を持っていきましょう"}, nil).Once()
+	mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+		return data.ID == "pending_id" && *data.Content == "傘を持っていきましょう"
+	})).Return(&discordgo.Message{}, nil).Once()
+
+	handleMessageEvent(mockSession, m, mockChatSvc, cfg, MessageTypeMention, "channel_id", false)
+
+	mockChatSvc.AssertExpectations(t)
+	mockSession.AssertExpectations(t)
+
+	t.Run("disabled mentions are only logged", func(t *testing.T) {
+		disabled := &config.Config{Model: &loader.ModelConfig{Mention: loader.MentionConfig{Disabled: true}}}
+		mockChatSvc := new(MockChatService)
+		handleMessageEvent(new(MockDiscordSession), m, mockChatSvc, disabled, MessageTypeMention, "channel_id", false)
+		mockChatSvc.AssertNotCalled(t, "GetResponse", mock.Anything, mock.Anything)
+	})
+}
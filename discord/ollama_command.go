@@ -0,0 +1,209 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// pullProgressEditInterval は /ollama pull の進捗でメッセージを編集する最小間隔です。
+const pullProgressEditInterval = 2 * time.Second
+
+// maxOllamaCommandTimeout は /ollama の1回の実行の期限の上限です。
+// インタラクションのトークンは15分で失効し、それ以降は応答を編集できないため、それより短くする。
+const maxOllamaCommandTimeout = 14 * time.Minute
+
+// ollamaCommand implements the /ollama command.
+type ollamaCommand struct {
+	cfg *config.Config
+}
+
+func (c *ollamaCommand) Name() string { return "ollama" }
+
+func (c *ollamaCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	ollamaCommandHandler(s, i, c.cfg)
+	return nil
+}
+
+// isAdministrator はインタラクションを実行したメンバーが管理者権限を持つかを判定します。DMでは常に false です。
+func isAdministrator(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
+func ollamaCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, cfg *config.Config) {
+	if !isAdministrator(i) {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("このコマンドは管理者のみ実行できます。"))
+		return
+	}
+	if cfg == nil || cfg.Model == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("モデル設定が読み込まれていません。"))
+		return
+	}
+	admin, err := chat.NewOllamaAdmin(cfg.Model.Ollama.APIEndpoint)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+
+	sub := i.ApplicationCommandData().Options[0]
+	model := ""
+	for _, opt := range sub.Options {
+		if opt.Name == "model" {
+			model = opt.StringValue()
+		}
+	}
+	if sub.Name == "unload" || sub.Name == "warm" {
+		if model, err = resolveOllamaModel(model, cfg.Model.Ollama); err != nil {
+			sendEphemeralErrorResponse(s, i, err)
+			return
+		}
+	}
+	log.Printf("User %s performed /ollama %s %s", i.Member.User.Username, sub.Name, model)
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), ollamaCommandTimeout(cfg.Model.Timeouts))
+	defer cancel()
+	var content string
+	switch sub.Name {
+	case "list":
+		content, err = formatOllamaModels(ctx, admin)
+	case "ps":
+		content, err = formatOllamaRunningModels(ctx, admin)
+	case "pull":
+		content, err = pullOllamaModel(ctx, s, i, admin, model)
+	case "unload":
+		err = admin.Unload(ctx, model)
+		content = fmt.Sprintf("`%s` をメモリから解放しました。", model)
+	case "warm":
+		err = admin.Warm(ctx, model, cfg.Model.Ollama.KeepAlive)
+		content = fmt.Sprintf("`%s` をメモリにロードしました。", model)
+	default:
+		err = fmt.Errorf("不明なサブコマンドです: %s", sub.Name)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("時間内に完了しませんでした (%v): %w", ollamaCommandTimeout(cfg.Model.Timeouts), err)
+	}
+	if err != nil {
+		sendErrorResponse(s, i, err)
+		return
+	}
+
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Printf("InteractionResponseEdit error: %v", err)
+	}
+}
+
+// resolveOllamaModel は /ollama の対象のモデル名を返します。option が空の場合は ollama.model_name を使い、どちらも無い場合はエラーです。
+func resolveOllamaModel(option string, ollamaCfg loader.OllamaConfig) (string, error) {
+	if option != "" {
+		return option, nil
+	}
+	if ollamaCfg.ModelName != "" {
+		return ollamaCfg.ModelName, nil
+	}
+	return "", errors.New("モデル名を指定してください (ollama.model_name も設定されていません)。")
+}
+
+// ollamaCommandTimeout は /ollama の1回の実行の期限を返します。
+// timeouts.command_seconds.ollama が未設定、または maxOllamaCommandTimeout を超える場合は maxOllamaCommandTimeout です。
+func ollamaCommandTimeout(t loader.TimeoutConfig) time.Duration {
+	if timeout := t.Command("ollama"); timeout > 0 && timeout < maxOllamaCommandTimeout {
+		return timeout
+	}
+	return maxOllamaCommandTimeout
+}
+
+func formatOllamaModels(ctx context.Context, admin *chat.OllamaAdmin) (string, error) {
+	models, err := admin.ListModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "ローカルにモデルがありません。", nil
+	}
+	var sb strings.Builder
+	sb.WriteString("**ローカルのモデル**\n")
+	for _, m := range models {
+		fmt.Fprintf(&sb, "- `%s` %s %s (%s)\n", m.Name, m.Details.ParameterSize, m.Details.QuantizationLevel, formatBytes(m.Size))
+	}
+	return truncateRunes(sb.String(), 2000), nil
+}
+
+func formatOllamaRunningModels(ctx context.Context, admin *chat.OllamaAdmin) (string, error) {
+	models, err := admin.RunningModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(models) == 0 {
+		return "ロード中のモデルはありません。", nil
+	}
+	var sb strings.Builder
+	sb.WriteString("**ロード中のモデル**\n")
+	for _, m := range models {
+		fmt.Fprintf(&sb, "- `%s` %s (VRAM %s) 解放予定: %s\n", m.Name, formatBytes(m.Size), formatBytes(m.SizeVRAM), m.ExpiresAt.In(time.FixedZone("JST", 9*60*60)).Format("2006-01-02 15:04"))
+	}
+	return truncateRunes(sb.String(), 2000), nil
+}
+
+// pullOllamaModel はモデルをダウンロードし、進捗を一定間隔で応答メッセージに反映します。
+func pullOllamaModel(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, admin *chat.OllamaAdmin, model string) (string, error) {
+	var lastEdit time.Time
+	err := admin.Pull(ctx, model, func(p chat.OllamaPullProgress) {
+		if time.Since(lastEdit) < pullProgressEditInterval {
+			return
+		}
+		lastEdit = time.Now()
+		progress := fmt.Sprintf("`%s` をダウンロード中: %s", model, p.Status)
+		if p.Total > 0 {
+			progress += fmt.Sprintf(" %d%% (%s / %s)", p.Completed*100/p.Total, formatBytes(p.Completed), formatBytes(p.Total))
+		}
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &progress}); err != nil {
+			log.Printf("InteractionResponseEdit error while pulling: %v", err)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("`%s` のダウンロードが完了しました。", model), nil
+}
+
+// warmUpOllama は設定されたOllamaモデルを起動時にメモリへロードします。
+func warmUpOllama(cfg *config.Config) {
+	ollamaCfg := cfg.Model.Ollama
+	admin, err := chat.NewOllamaAdmin(ollamaCfg.APIEndpoint)
+	if err != nil {
+		log.Printf("Ollamaのウォームアップをスキップします: %v", err)
+		return
+	}
+	start := time.Now()
+	if err := admin.Warm(context.Background(), ollamaCfg.ModelName, ollamaCfg.KeepAlive); err != nil {
+		log.Printf("Ollamaモデル %s のウォームアップに失敗しました: %v", ollamaCfg.ModelName, err)
+		return
+	}
+	log.Printf("Ollamaモデル %s をウォームアップしました (%v)", ollamaCfg.ModelName, time.Since(start))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
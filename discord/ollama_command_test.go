@@ -0,0 +1,40 @@
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+func TestResolveOllamaModel(t *testing.T) {
+	if model, err := resolveOllamaModel("llama3", loader.OllamaConfig{ModelName: "gemma"}); err != nil || model != "llama3" {
+		t.Errorf("option should take precedence: %q, %v", model, err)
+	}
+	if model, err := resolveOllamaModel("", loader.OllamaConfig{ModelName: "gemma"}); err != nil || model != "gemma" {
+		t.Errorf("should fall back to ollama.model_name: %q, %v", model, err)
+	}
+	if _, err := resolveOllamaModel("", loader.OllamaConfig{}); err == nil {
+		t.Error("expected an error when no model name is given")
+	}
+}
+
+func TestOllamaCommandTimeout(t *testing.T) {
+	cases := []struct {
+		name    string
+		seconds map[string]int
+		want    time.Duration
+	}{
+		{"unset", nil, maxOllamaCommandTimeout},
+		{"configured", map[string]int{"ollama": 300}, 5 * time.Minute},
+		{"beyond token lifetime", map[string]int{"ollama": 3600}, maxOllamaCommandTimeout},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ollamaCommandTimeout(loader.TimeoutConfig{CommandSeconds: c.seconds})
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// maxAutocompleteChoices は Discord が一度に受け付ける候補の上限です。
+const maxAutocompleteChoices = 25
+
+// personaCommand implements the /persona command.
+type personaCommand struct {
+	cfg   *config.Config
+	store history.PersonaStore // nil の場合はペルソナを選べない
+}
+
+func (c *personaCommand) Name() string { return "persona" }
+
+func (c *personaCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	personaCommandHandler(s, i, c.cfg, c.store)
+	return nil
+}
+
+// Autocomplete は入力中のペルソナ名に合う候補を返します。
+func (c *personaCommand) Autocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	if c.cfg != nil && c.cfg.Model != nil {
+		query := ""
+		if options := i.ApplicationCommandData().Options; len(options) > 0 {
+			for _, opt := range options[0].Options {
+				if opt.Focused {
+					query = opt.StringValue()
+				}
+			}
+		}
+		choices = personaChoices(c.cfg.Model, query)
+	}
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+}
+
+// personaChoices は ID か表示名に query を含むペルソナを、名前順に最大 maxAutocompleteChoices 件返します。
+func personaChoices(modelCfg *loader.ModelConfig, query string) []*discordgo.ApplicationCommandOptionChoice {
+	query = strings.ToLower(strings.TrimSpace(query))
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, id := range modelCfg.PersonaIDs() {
+		persona := modelCfg.Personas[id]
+		name := persona.Name(id)
+		if query != "" && !strings.Contains(strings.ToLower(id), query) && !strings.Contains(strings.ToLower(name), query) {
+			continue
+		}
+		label := name
+		if persona.Description != "" {
+			label += " - " + persona.Description
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncateRunes(label, 100), Value: id})
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+	}
+	return choices
+}
+
+// canManageChannel はインタラクションを実行したメンバーがチャンネルの管理権限を持つかを判定します。DMでは常に false です。
+func canManageChannel(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&(discordgo.PermissionManageChannels|discordgo.PermissionAdministrator) != 0
+}
+
+func personaCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, cfg *config.Config, store history.PersonaStore) {
+	if cfg == nil || cfg.Model == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("モデル設定が読み込まれていません。"))
+		return
+	}
+	if len(cfg.Model.Personas) == 0 || store == nil {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("このBotではペルソナが設定されていません。"))
+		return
+	}
+	var user *discordgo.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	} else if i.User != nil {
+		user = i.User
+	} else {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("ユーザー情報が取得できませんでした。"))
+		return
+	}
+
+	sub := i.ApplicationCommandData().Options[0]
+	name := ""
+	for _, opt := range sub.Options {
+		if opt.Name == "name" {
+			name = opt.StringValue()
+		}
+	}
+	log.Printf("User %s performed /persona %s %s in channel %s", user.Username, sub.Name, name, i.ChannelID)
+
+	channelIDs := []string{i.ChannelID}
+	if ch := lookupChannel(&discordgoSession{s}, i.ChannelID); ch != nil && ch.IsThread() {
+		channelIDs = append(channelIDs, ch.ParentID)
+	}
+	req := personaRequest{
+		subcommand: sub.Name,
+		name:       name,
+		userID:     user.ID,
+		channelIDs: channelIDs,
+		inGuild:    i.GuildID != "",
+		canManage:  canManageChannel(i),
+	}
+	content, err := runPersonaCommand(store, cfg.Model, req)
+	if err != nil {
+		sendEphemeralErrorResponse(s, i, err)
+		return
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral},
+	}); err != nil {
+		log.Printf("InteractionRespond error: %v", err)
+	}
+}
+
+// personaRequest は /persona の1回の実行内容です。
+type personaRequest struct {
+	subcommand string // "set" / "clear" / "channel" / "list"
+	name       string // 選んだペルソナのID ("channel" では空の場合に既定を取り消す)
+	userID     string
+	channelIDs []string // 実行したチャンネルと、スレッドの場合はその親チャンネル
+	inGuild    bool
+	canManage  bool // チャンネルの管理権限を持つか
+}
+
+// runPersonaCommand は req を実行し、実行したユーザーに表示するメッセージを返します。
+func runPersonaCommand(store history.PersonaStore, modelCfg *loader.ModelConfig, req personaRequest) (string, error) {
+	switch req.subcommand {
+	case "set":
+		persona, ok := modelCfg.Persona(req.name)
+		if !ok {
+			return "", fmt.Errorf("ペルソナ「%s」はありません。", req.name)
+		}
+		if err := store.SetPersona(history.PersonaScopeUser, req.userID, req.name); err != nil {
+			return "", fmt.Errorf("ペルソナの保存に失敗しました: %w", err)
+		}
+		return fmt.Sprintf("これからは **%s** として応答します。", persona.Name(req.name)), nil
+	case "clear":
+		if err := store.SetPersona(history.PersonaScopeUser, req.userID, ""); err != nil {
+			return "", fmt.Errorf("ペルソナの保存に失敗しました: %w", err)
+		}
+		return "ペルソナの選択を取り消しました。チャンネルの既定のペルソナ (無ければ通常の設定) で応答します。", nil
+	case "channel":
+		if !req.inGuild {
+			return "", errors.New("チャンネルの既定のペルソナはサーバーのチャンネルでのみ設定できます。")
+		}
+		if !req.canManage {
+			return "", errors.New("チャンネルの既定のペルソナを設定するには、チャンネルの管理権限が必要です。")
+		}
+		if req.name == "" {
+			if err := store.SetPersona(history.PersonaScopeChannel, req.channelIDs[0], ""); err != nil {
+				return "", fmt.Errorf("ペルソナの保存に失敗しました: %w", err)
+			}
+			return "このチャンネルの既定のペルソナを取り消しました。", nil
+		}
+		persona, ok := modelCfg.Persona(req.name)
+		if !ok {
+			return "", fmt.Errorf("ペルソナ「%s」はありません。", req.name)
+		}
+		if err := store.SetPersona(history.PersonaScopeChannel, req.channelIDs[0], req.name); err != nil {
+			return "", fmt.Errorf("ペルソナの保存に失敗しました: %w", err)
+		}
+		return fmt.Sprintf("このチャンネルの既定のペルソナを **%s** にしました。", persona.Name(req.name)), nil
+	case "list":
+		return describePersonas(store, modelCfg, req), nil
+	}
+	return "", fmt.Errorf("不明なサブコマンドです: %s", req.subcommand)
+}
+
+// describePersonas は選択中のペルソナと、選べるペルソナの一覧を返します。
+func describePersonas(store history.PersonaStore, modelCfg *loader.ModelConfig, req personaRequest) string {
+	nameOf := func(scope, targetID string) string {
+		id, ok, err := store.GetPersona(scope, targetID)
+		if err != nil {
+			log.Printf("%s %s のペルソナの取得に失敗しました: %v", scope, targetID, err)
+			return ""
+		}
+		if persona, exists := modelCfg.Persona(id); ok && exists {
+			return persona.Name(id)
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	if name := nameOf(history.PersonaScopeUser, req.userID); name != "" {
+		fmt.Fprintf(&b, "あなたのペルソナ: **%s**\n", name)
+	} else {
+		b.WriteString("あなたのペルソナ: 未選択\n")
+	}
+	if req.inGuild {
+		channelDefault := "なし"
+		for _, channelID := range req.channelIDs {
+			if name := nameOf(history.PersonaScopeChannel, channelID); name != "" {
+				channelDefault = "**" + name + "**"
+				break
+			}
+		}
+		fmt.Fprintf(&b, "このチャンネルの既定: %s\n", channelDefault)
+	}
+	b.WriteString("\n選べるペルソナ:\n")
+	for _, id := range modelCfg.PersonaIDs() {
+		persona := modelCfg.Personas[id]
+		fmt.Fprintf(&b, "- **%s** (`%s`)", persona.Name(id), id)
+		if persona.Description != "" {
+			b.WriteString(": " + persona.Description)
+		}
+		b.WriteString("\n")
+	}
+	return truncateRunes(strings.TrimRight(b.String(), "\n"), 2000)
+}
@@ -0,0 +1,109 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPersonaConfig() *loader.ModelConfig {
+	return &loader.ModelConfig{Personas: map[string]loader.PersonaConfig{
+		"butler": {DisplayName: "執事", Description: "丁寧な言葉づかい", Prompt: "執事"},
+		"otaku":  {Prompt: "オタク"},
+		"zunda":  {DisplayName: "ずんだもん", Prompt: "ずんだもん"},
+	}}
+}
+
+func TestPersonaChoices(t *testing.T) {
+	modelCfg := testPersonaConfig()
+
+	all := personaChoices(modelCfg, "")
+	if !assert.Len(t, all, 3) {
+		return
+	}
+	assert.Equal(t, "執事 - 丁寧な言葉づかい", all[0].Name)
+	assert.Equal(t, "butler", all[0].Value)
+	assert.Equal(t, "otaku", all[1].Name)
+
+	byName := personaChoices(modelCfg, "ずんだ")
+	if !assert.Len(t, byName, 1) {
+		return
+	}
+	assert.Equal(t, "zunda", byName[0].Value)
+	byID := personaChoices(modelCfg, "BUT")
+	if !assert.Len(t, byID, 1) {
+		return
+	}
+	assert.Equal(t, "butler", byID[0].Value)
+	assert.Empty(t, personaChoices(modelCfg, "none"))
+}
+
+func TestCanManageChannel(t *testing.T) {
+	assert.False(t, canManageChannel(&discordgo.InteractionCreate{Interaction: &discordgo.Interaction{User: &discordgo.User{ID: "u1"}}}))
+	assert.False(t, canManageChannel(&discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{Permissions: discordgo.PermissionSendMessages}}}))
+	assert.True(t, canManageChannel(&discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Member: &discordgo.Member{Permissions: discordgo.PermissionManageChannels}}}))
+}
+
+func TestRunPersonaCommand(t *testing.T) {
+	modelCfg := testPersonaConfig()
+	historyMgr, _ := history.NewInMemoryHistoryManager(10)
+	store := historyMgr.(history.PersonaStore)
+	base := personaRequest{userID: "u1", channelIDs: []string{"thread", "parent"}, inGuild: true}
+	run := func(subcommand, name string, canManage bool) (string, error) {
+		req := base
+		req.subcommand, req.name, req.canManage = subcommand, name, canManage
+		return runPersonaCommand(store, modelCfg, req)
+	}
+
+	t.Run("set and clear the user's persona", func(t *testing.T) {
+		content, err := run("set", "butler", false)
+		assert.NoError(t, err)
+		assert.Contains(t, content, "執事")
+		persona, ok, _ := store.GetPersona(history.PersonaScopeUser, "u1")
+		assert.True(t, ok)
+		assert.Equal(t, "butler", persona)
+
+		_, err = run("set", "missing", false)
+		assert.EqualError(t, err, "ペルソナ「missing」はありません。")
+
+		_, err = run("clear", "", false)
+		assert.NoError(t, err)
+		_, ok, _ = store.GetPersona(history.PersonaScopeUser, "u1")
+		assert.False(t, ok)
+	})
+
+	t.Run("channel default requires the manage channels permission", func(t *testing.T) {
+		_, err := run("channel", "otaku", false)
+		assert.Error(t, err)
+		_, ok, _ := store.GetPersona(history.PersonaScopeChannel, "thread")
+		assert.False(t, ok)
+
+		_, err = run("channel", "otaku", true)
+		assert.NoError(t, err)
+		persona, _, _ := store.GetPersona(history.PersonaScopeChannel, "thread")
+		assert.Equal(t, "otaku", persona)
+
+		dm := base
+		dm.subcommand, dm.name, dm.inGuild, dm.canManage = "channel", "otaku", false, true
+		_, err = runPersonaCommand(store, modelCfg, dm)
+		assert.Error(t, err)
+	})
+
+	t.Run("list shows the current selections", func(t *testing.T) {
+		store.SetPersona(history.PersonaScopeUser, "u1", "zunda")
+		store.SetPersona(history.PersonaScopeChannel, "thread", "")
+		store.SetPersona(history.PersonaScopeChannel, "parent", "butler")
+
+		content, err := run("list", "", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "あなたのペルソナ: **ずんだもん**\n"+
+			"このチャンネルの既定: **執事**\n\n"+
+			"選べるペルソナ:\n"+
+			"- **執事** (`butler`): 丁寧な言葉づかい\n"+
+			"- **otaku** (`otaku`)\n"+
+			"- **ずんだもん** (`zunda`)", content)
+	})
+}
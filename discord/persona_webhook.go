@@ -0,0 +1,139 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+)
+
+// personaWebhookName は Bot がペルソナの発言に使う Webhook の名前です。再起動後に既存の Webhook を見つけるのにも使います。
+const personaWebhookName = "llm-discord persona"
+
+// maxWebhookUsernameLength は Webhook で送信する際の表示名の上限です。
+const maxWebhookUsernameLength = 80
+
+var errNoWebhookPermission = errors.New("Webhook の管理権限がありません")
+
+// webhookRegistry はチャンネルごとにペルソナの発言に使う Webhook と、Webhook が Bot のものかの判定結果を保持します。
+type webhookRegistry struct {
+	mu        sync.Mutex
+	byChannel map[string]*discordgo.Webhook // キーは Webhook を作成したチャンネル (スレッドの場合は親チャンネル) のID
+	owned     map[string]bool               // キーは Webhook のID。Bot が作成したものなら true
+}
+
+func newWebhookRegistry() *webhookRegistry {
+	return &webhookRegistry{byChannel: make(map[string]*discordgo.Webhook), owned: make(map[string]bool)}
+}
+
+var personaWebhooks = newWebhookRegistry()
+
+// forChannel は channelID でペルソナの発言に使う Webhook を返します。
+// キャッシュに無ければ Bot が以前作成したものを探し、それも無ければ作成します。
+// Bot に Webhook の管理権限が無い場合は errNoWebhookPermission を返します。
+func (r *webhookRegistry) forChannel(s DiscordSession, channelID, botID string) (*discordgo.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if webhook, ok := r.byChannel[channelID]; ok {
+		return webhook, nil
+	}
+
+	perms, err := s.UserChannelPermissions(botID, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("チャンネル %s での Bot の権限を確認できませんでした: %w", channelID, err)
+	}
+	if perms&discordgo.PermissionManageWebhooks == 0 {
+		return nil, errNoWebhookPermission
+	}
+
+	existing, err := s.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("チャンネル %s の Webhook の取得に失敗しました: %w", channelID, err)
+	}
+	for _, webhook := range existing {
+		if isPersonaWebhook(webhook, botID) && webhook.Token != "" {
+			r.byChannel[channelID], r.owned[webhook.ID] = webhook, true
+			return webhook, nil
+		}
+	}
+
+	webhook, err := s.WebhookCreate(channelID, personaWebhookName, "")
+	if err != nil {
+		return nil, fmt.Errorf("チャンネル %s の Webhook の作成に失敗しました: %w", channelID, err)
+	}
+	log.Printf("チャンネル %s にペルソナ用の Webhook %s を作成しました", channelID, webhook.ID)
+	r.byChannel[channelID], r.owned[webhook.ID] = webhook, true
+	return webhook, nil
+}
+
+// forget は channelID の Webhook をキャッシュから外します。Webhook が削除された場合などに、次回作り直すために使います。
+func (r *webhookRegistry) forget(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byChannel, channelID)
+}
+
+// owns は webhookID が Bot の作成したペルソナ用の Webhook かを返します。
+// 初めて見る Webhook は API で確認し、結果をキャッシュします。
+func (r *webhookRegistry) owns(s DiscordSession, webhookID, botID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if owned, ok := r.owned[webhookID]; ok {
+		return owned
+	}
+	webhook, err := s.Webhook(webhookID)
+	owned := err == nil && isPersonaWebhook(webhook, botID)
+	r.owned[webhookID] = owned
+	return owned
+}
+
+// isPersonaWebhook は webhook が botID の Bot が作成したペルソナ用の Webhook かを返します。
+func isPersonaWebhook(webhook *discordgo.Webhook, botID string) bool {
+	if webhook == nil || webhook.Name != personaWebhookName {
+		return false
+	}
+	return webhook.ApplicationID == botID || (webhook.User != nil && webhook.User.ID == botID)
+}
+
+// isOwnMessage は msg が Bot 自身、または Bot のペルソナ用 Webhook の発言かを返します。
+func isOwnMessage(s DiscordSession, msg *discordgo.Message, botID string) bool {
+	if msg == nil || msg.Author == nil {
+		return false
+	}
+	if msg.Author.ID == botID {
+		return true
+	}
+	return msg.WebhookID != "" && personaWebhooks.owns(s, msg.WebhookID, botID)
+}
+
+// sendPersonaResponse は resp をペルソナの名前とアイコンで、channelID の Webhook から送信します。
+// スレッドでは親チャンネルの Webhook を thread_id 付きで使います。
+// Webhook を使えない場合はエラーを返すため、呼び出し側は通常のメッセージで送信してください。
+func sendPersonaResponse(s DiscordSession, channelID string, resp *chat.ChatResponse, reasoningDisplay string) (*discordgo.Message, error) {
+	webhookChannelID, threadID := channelID, ""
+	if ch := lookupChannel(s, channelID); ch != nil && ch.IsThread() {
+		webhookChannelID, threadID = ch.ParentID, ch.ID
+	}
+	webhook, err := personaWebhooks.forChannel(s, webhookChannelID, s.BotUserID())
+	if err != nil {
+		return nil, err
+	}
+
+	content, embeds := chatResponseContent(resp, reasoningDisplay)
+	msg, err := s.WebhookThreadExecute(webhook.ID, webhook.Token, true, threadID, &discordgo.WebhookParams{
+		Content:   content,
+		Username:  truncateRunes(resp.PersonaName, maxWebhookUsernameLength),
+		AvatarURL: resp.PersonaIcon,
+		Embeds:    embeds,
+		Files:     responseFiles(resp),
+	})
+	if err != nil {
+		// 削除された Webhook を使い続けないよう、次回は探し直す
+		personaWebhooks.forget(webhookChannelID)
+		return nil, fmt.Errorf("Webhook %s での送信に失敗しました: %w", webhook.ID, err)
+	}
+	return msg, nil
+}
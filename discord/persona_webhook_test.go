@@ -0,0 +1,107 @@
+package discord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeliverGuildResponseWithPersona(t *testing.T) {
+	modelCfg := &loader.ModelConfig{}
+	resp := &chat.ChatResponse{Text: "かしこまりました", PersonaName: "執事", PersonaIcon: "https://example.com/butler.png"}
+	pending := &discordgo.Message{ID: "pending_id"}
+	reference := &discordgo.MessageReference{MessageID: "msg_id", ChannelID: "thread_id"}
+
+	t.Run("sends through the parent channel's webhook in threads", func(t *testing.T) {
+		personaWebhooks = newWebhookRegistry()
+		mockSession := new(MockDiscordSession)
+		mockSession.On("BotUserID").Return("bot_id")
+		mockSession.On("StateChannel", "thread_id").Return(&discordgo.Channel{ID: "thread_id", Type: discordgo.ChannelTypeGuildPublicThread, ParentID: "parent_id"}, nil)
+		mockSession.On("UserChannelPermissions", "bot_id", "parent_id").Return(int64(discordgo.PermissionManageWebhooks), nil).Once()
+		mockSession.On("ChannelWebhooks", "parent_id").Return([]*discordgo.Webhook{
+			{ID: "other", Name: personaWebhookName, Token: "t", User: &discordgo.User{ID: "someone"}},
+		}, nil).Once()
+		mockSession.On("WebhookCreate", "parent_id", personaWebhookName, "").Return(&discordgo.Webhook{ID: "hook_id", Token: "token"}, nil).Once()
+		mockSession.On("WebhookThreadExecute", "hook_id", "token", true, "thread_id", mock.MatchedBy(func(data *discordgo.WebhookParams) bool {
+			return data.Content == "かしこまりました" && data.Username == "執事" && data.AvatarURL == "https://example.com/butler.png"
+		})).Return(&discordgo.Message{ID: "sent_id", WebhookID: "hook_id"}, nil).Twice()
+		mockSession.On("ChannelMessageDelete", "thread_id", "pending_id").Return(nil).Twice()
+
+		msg, err := deliverGuildResponse(mockSession, "thread_id", pending, resp, modelCfg, reference)
+		assert.NoError(t, err)
+		assert.Equal(t, "sent_id", msg.ID)
+		// 2回目はキャッシュした Webhook を使う
+		_, err = deliverGuildResponse(mockSession, "thread_id", pending, resp, modelCfg, reference)
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+
+		assert.True(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "hook_id"}, WebhookID: "hook_id"}, "bot_id"))
+	})
+
+	t.Run("reuses the bot's existing webhook", func(t *testing.T) {
+		personaWebhooks = newWebhookRegistry()
+		mockSession := new(MockDiscordSession)
+		mockSession.On("BotUserID").Return("bot_id")
+		mockSession.On("StateChannel", "channel_id").Return(&discordgo.Channel{ID: "channel_id", Type: discordgo.ChannelTypeGuildText}, nil)
+		mockSession.On("UserChannelPermissions", "bot_id", "channel_id").Return(int64(discordgo.PermissionManageWebhooks), nil).Once()
+		mockSession.On("ChannelWebhooks", "channel_id").Return([]*discordgo.Webhook{
+			{ID: "hook_id", Name: personaWebhookName, Token: "token", User: &discordgo.User{ID: "bot_id"}},
+		}, nil).Once()
+		mockSession.On("WebhookThreadExecute", "hook_id", "token", true, "", mock.Anything).Return(&discordgo.Message{ID: "sent_id"}, nil).Once()
+
+		_, err := deliverGuildResponse(mockSession, "channel_id", nil, resp, modelCfg, reference)
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("falls back to a reply without the manage webhooks permission", func(t *testing.T) {
+		personaWebhooks = newWebhookRegistry()
+		mockSession := new(MockDiscordSession)
+		mockSession.On("BotUserID").Return("bot_id")
+		mockSession.On("StateChannel", "channel_id").Return(&discordgo.Channel{ID: "channel_id", Type: discordgo.ChannelTypeGuildText}, nil)
+		mockSession.On("UserChannelPermissions", "bot_id", "channel_id").Return(int64(discordgo.PermissionSendMessages), nil).Once()
+		mockSession.On("ChannelMessageEditComplex", mock.MatchedBy(func(data *discordgo.MessageEdit) bool {
+			return data.ID == "pending_id" && *data.Content == "かしこまりました"
+		})).Return(&discordgo.Message{}, nil).Once()
+
+		_, err := deliverGuildResponse(mockSession, "channel_id", pending, resp, modelCfg, reference)
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("sends as the bot when disabled or without a persona", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessageSendReply", "channel_id", "かしこまりました", reference).Return(&discordgo.Message{}, nil).Twice()
+
+		disabled := &loader.ModelConfig{PersonaWebhooks: loader.PersonaWebhooksConfig{Disabled: true}}
+		_, err := deliverGuildResponse(mockSession, "channel_id", nil, resp, disabled, reference)
+		assert.NoError(t, err)
+		_, err = deliverGuildResponse(mockSession, "channel_id", nil, &chat.ChatResponse{Text: "かしこまりました"}, modelCfg, reference)
+		assert.NoError(t, err)
+		mockSession.AssertExpectations(t)
+	})
+}
+
+func TestIsOwnMessage(t *testing.T) {
+	personaWebhooks = newWebhookRegistry()
+	mockSession := new(MockDiscordSession)
+	mockSession.On("Webhook", "mine").Return(&discordgo.Webhook{ID: "mine", Name: personaWebhookName, ApplicationID: "bot_id"}, nil).Once()
+	mockSession.On("Webhook", "integration").Return(&discordgo.Webhook{ID: "integration", Name: "GitHub"}, nil).Once()
+	mockSession.On("Webhook", "deleted").Return(nil, errors.New("unknown webhook")).Once()
+
+	assert.True(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "bot_id"}}, "bot_id"))
+	assert.False(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "user_id"}}, "bot_id"))
+	assert.False(t, isOwnMessage(mockSession, nil, "bot_id"))
+	for range 2 {
+		// 2回目は API を呼ばずにキャッシュを使う
+		assert.True(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "mine"}, WebhookID: "mine"}, "bot_id"))
+		assert.False(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "integration"}, WebhookID: "integration"}, "bot_id"))
+		assert.False(t, isOwnMessage(mockSession, &discordgo.Message{Author: &discordgo.User{ID: "deleted"}, WebhookID: "deleted"}, "bot_id"))
+	}
+	mockSession.AssertExpectations(t)
+}
@@ -0,0 +1,78 @@
+package discord
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// promptContext はシステムプロンプトのテンプレートに渡す、会話の場所の情報です。
+type promptContext struct {
+	GuildName   string
+	ChannelName string
+	ThreadTitle string
+	ParentID    string // スレッドの場合は親チャンネルのID
+}
+
+// lookupPromptContext は guildID と channelID の名前を、可能な限り State から取得します。
+// DM の場合や取得に失敗した場合は、その項目を空のままにします。
+func lookupPromptContext(s DiscordSession, guildID, channelID string) promptContext {
+	var pc promptContext
+	if guildID == "" {
+		return pc
+	}
+
+	guild, err := s.StateGuild(guildID)
+	if err != nil {
+		guild, err = s.Guild(guildID)
+	}
+	if err != nil {
+		log.Printf("サーバー %s の情報を取得できませんでした: %v", guildID, err)
+	} else {
+		pc.GuildName = guild.Name
+	}
+
+	ch := lookupChannel(s, channelID)
+	if ch == nil {
+		return pc
+	}
+	if !ch.IsThread() {
+		pc.ChannelName = ch.Name
+		return pc
+	}
+	pc.ThreadTitle = ch.Name
+	pc.ParentID = ch.ParentID
+	if parent := lookupChannel(s, ch.ParentID); parent != nil {
+		pc.ChannelName = parent.Name
+	}
+	return pc
+}
+
+// lookupChannel は State、無ければ API からチャンネルを取得します。取得できない場合は nil です。
+func lookupChannel(s DiscordSession, channelID string) *discordgo.Channel {
+	if channelID == "" {
+		return nil
+	}
+	ch, err := s.StateChannel(channelID)
+	if err != nil {
+		if ch, err = s.Channel(channelID); err != nil {
+			log.Printf("チャンネル %s の情報を取得できませんでした: %v", channelID, err)
+			return nil
+		}
+	}
+	return ch
+}
+
+// displayName はサーバーでのニックネーム、グローバルな表示名、ユーザー名の順に、最初に設定されているものを返します。
+func displayName(member *discordgo.Member, user *discordgo.User) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	}
+	if user == nil {
+		return ""
+	}
+	if user.GlobalName != "" {
+		return user.GlobalName
+	}
+	return user.Username
+}
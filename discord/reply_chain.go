@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+)
+
+// buildReplyChain は m が返信しているメッセージから MessageReference をたどり、古い順の会話にします。
+// botID の Bot (とそのペルソナ用 Webhook) の発言はモデルの発言、m の送信者以外のユーザーの発言は名前付きのユーザーの発言として扱います。
+// cfg の最大数とトークン数に達するか、参照先を取得できなくなった時点でたどるのをやめます。
+func buildReplyChain(s DiscordSession, m *discordgo.Message, botID string, cfg loader.ReplyChainConfig) []history.HistoryMessage {
+	maxDepth, maxTokens := cfg.Limits()
+	var chain []history.HistoryMessage
+	tokens := 0
+	current, ref := m.ReferencedMessage, m.MessageReference
+	for depth := 0; depth < maxDepth; depth++ {
+		if current == nil {
+			if ref == nil || ref.MessageID == "" {
+				break
+			}
+			channelID := ref.ChannelID
+			if channelID == "" {
+				channelID = m.ChannelID
+			}
+			fetched, err := s.ChannelMessage(channelID, ref.MessageID)
+			if err != nil {
+				log.Printf("返信先のメッセージ %s を取得できなかったため、返信の連鎖をここで打ち切ります: %v", ref.MessageID, err)
+				break
+			}
+			current = fetched
+		}
+
+		if text := messageText(current); text != "" {
+			tokens += chat.EstimateTokens(text)
+			if tokens > maxTokens && len(chain) > 0 {
+				break
+			}
+			chain = append(chain, chainMessage(current, text, isOwnMessage(s, current, botID), m.Author))
+		}
+		// REST で取得したメッセージには1段先の返信先が含まれるため、あればそれを使って取得を1回省く
+		current, ref = current.ReferencedMessage, current.MessageReference
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// chainMessage は返信の連鎖の1件を履歴の形にします。
+// own は Bot 自身の発言かどうかです。
+func chainMessage(msg *discordgo.Message, text string, own bool, requester *discordgo.User) history.HistoryMessage {
+	if own {
+		return history.HistoryMessage{Role: "model", Content: text, CreatedAt: msg.Timestamp}
+	}
+	hm := history.HistoryMessage{Role: "user", Content: text, CreatedAt: msg.Timestamp}
+	if msg.Author != nil && (requester == nil || msg.Author.ID != requester.ID) {
+		hm.Name = displayName(msg.Member, msg.Author)
+	}
+	return hm
+}
+
+// messageText はメッセージの本文を返します。本文が無い場合 (/chat の応答など) は埋め込みの説明と項目をつなげます。
+func messageText(msg *discordgo.Message) string {
+	if text := strings.TrimSpace(msg.Content); text != "" {
+		return text
+	}
+	var parts []string
+	for _, embed := range msg.Embeds {
+		if embed.Description != "" {
+			parts = append(parts, embed.Description)
+		}
+		for _, field := range embed.Fields {
+			if field.Value != "" {
+				parts = append(parts, field.Value)
+			}
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/history"
+	"github.com/eraiza0816/llm-discord/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildReplyChain(t *testing.T) {
+	bot := &discordgo.User{ID: "bot"}
+	alice := &discordgo.User{ID: "alice", Username: "alice"}
+	bob := &discordgo.User{ID: "bob", Username: "bob", GlobalName: "ボブ"}
+	ref := func(id string) *discordgo.MessageReference {
+		return &discordgo.MessageReference{MessageID: id, ChannelID: "old_channel"}
+	}
+
+	// m1 (bob) <- m2 (bot) <- m3 (alice) <- m4 (bot, /chat の埋め込み) <- 今回のメッセージ (alice)
+	m1 := &discordgo.Message{ID: "m1", Author: bob, Content: "おすすめの本は？"}
+	m2 := &discordgo.Message{ID: "m2", Author: bot, Content: "『坊っちゃん』です", MessageReference: ref("m1")}
+	m3 := &discordgo.Message{ID: "m3", Author: alice, Content: "作者は？", MessageReference: ref("m2"), ReferencedMessage: m2}
+	m4 := &discordgo.Message{ID: "m4", Author: bot, MessageReference: ref("m3"),
+		Embeds: []*discordgo.MessageEmbed{{Description: "夏目漱石です"}}}
+	current := &discordgo.Message{ID: "m5", ChannelID: "channel", Author: alice, Content: "他の作品は？", MessageReference: ref("m4"), ReferencedMessage: m4}
+
+	t.Run("walks the whole chain", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessage", "old_channel", "m3").Return(m3, nil).Once()
+		mockSession.On("ChannelMessage", "old_channel", "m1").Return(m1, nil).Once()
+
+		chain := buildReplyChain(mockSession, current, "bot", loader.ReplyChainConfig{})
+
+		assert.Equal(t, []history.HistoryMessage{
+			{Role: "user", Content: "おすすめの本は？", Name: "ボブ"},
+			{Role: "model", Content: "『坊っちゃん』です"},
+			{Role: "user", Content: "作者は？"},
+			{Role: "model", Content: "夏目漱石です"},
+		}, chain)
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("stops at the depth limit", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessage", "old_channel", "m3").Return(m3, nil).Once()
+
+		chain := buildReplyChain(mockSession, current, "bot", loader.ReplyChainConfig{MaxDepth: 2})
+
+		assert.Equal(t, []string{"作者は？", "夏目漱石です"}, chainContents(chain))
+		mockSession.AssertExpectations(t)
+	})
+
+	t.Run("stops at the token limit", func(t *testing.T) {
+		long := &discordgo.Message{ID: "long", Author: bob, Content: strings.Repeat("長", 100)}
+		reply := &discordgo.Message{ID: "r", Author: bot, Content: "短い応答", MessageReference: ref("long"), ReferencedMessage: long}
+		msg := &discordgo.Message{ChannelID: "channel", Author: alice, Content: "続き", ReferencedMessage: reply}
+
+		chain := buildReplyChain(new(MockDiscordSession), msg, "bot", loader.ReplyChainConfig{MaxTokens: 50})
+
+		assert.Equal(t, []string{"短い応答"}, chainContents(chain))
+	})
+
+	t.Run("stops when a message cannot be fetched", func(t *testing.T) {
+		mockSession := new(MockDiscordSession)
+		mockSession.On("ChannelMessage", "old_channel", "m3").Return(nil, errors.New("deleted")).Once()
+
+		chain := buildReplyChain(mockSession, current, "bot", loader.ReplyChainConfig{})
+
+		assert.Equal(t, []string{"夏目漱石です"}, chainContents(chain))
+		mockSession.AssertExpectations(t)
+	})
+}
+
+func chainContents(chain []history.HistoryMessage) []string {
+	var contents []string
+	for _, msg := range chain {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
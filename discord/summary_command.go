@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
+	"github.com/eraiza0816/llm-discord/config"
+	"github.com/eraiza0816/llm-discord/history"
+)
+
+// summaryCommand implements the /summary command.
+type summaryCommand struct {
+	chatSvc chat.Service
+	cfg     *config.Config
+}
+
+func (c *summaryCommand) Name() string { return "summary" }
+
+func (c *summaryCommand) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	threadID := resolveThreadIDForInteraction(s, i)
+	if threadID == "" {
+		return nil
+	}
+	historyUserID := ""
+	if c.cfg != nil && c.cfg.Model != nil && i.GuildID != "" {
+		// 共有スレッドの要約は参加者全員で1つ
+		if ch := lookupChannel(&discordgoSession{s}, i.ChannelID); ch != nil && c.cfg.Model.SharedThreads.Enabled(ch.ID, ch.ParentID) {
+			historyUserID = history.SharedUserID
+		}
+	}
+	summaryCommandHandler(s, i, c.chatSvc, threadID, historyUserID)
+	return nil
+}
+
+// summaryCommandHandler は実行したユーザーとこのスレッドでの会話の要約を、本人にだけ表示します。
+// regenerate が指定された場合は、保存されている履歴から要約を作り直してから表示します。
+// historyUserID が空でない場合は、実行したユーザーの代わりにそのキーの履歴 (共有スレッド) の要約を使います。
+func summaryCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate, chatSvc chat.Service, threadID, historyUserID string) {
+	var user *discordgo.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	} else if i.User != nil {
+		user = i.User
+	} else {
+		sendEphemeralErrorResponse(s, i, fmt.Errorf("ユーザー情報が取得できませんでした。"))
+		return
+	}
+	if historyUserID == "" {
+		historyUserID = user.ID
+	}
+	regenerate := false
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "regenerate" {
+			regenerate = opt.BoolValue()
+		}
+	}
+	log.Printf("User %s performed /summary (regenerate=%v) for thread/channel ID: %s.", user.Username, regenerate, threadID)
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+
+	summary, ok, err := chatSvc.GetSummary(historyUserID, threadID)
+	if err == nil && regenerate {
+		summary, err = chatSvc.RegenerateSummary(context.Background(), historyUserID, threadID)
+		ok = err == nil
+	}
+	if err != nil {
+		if errors.Is(err, chat.ErrSummaryUnavailable) {
+			sendErrorResponse(s, i, errors.New("このBotでは会話の要約が有効になっていません。"))
+			return
+		}
+		sendErrorResponse(s, i, fmt.Errorf("要約の取得に失敗しました: %w", err))
+		return
+	}
+	if !ok {
+		content := "まだ要約はありません。会話が長くなると、古い部分が自動で要約されます。`regenerate` を指定すると今すぐ作成できます。"
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("InteractionResponseEdit error: %v", err)
+		}
+		return
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{buildSummaryEmbed(summary)},
+	}); err != nil {
+		log.Printf("InteractionResponseEdit error: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eraiza0816/llm-discord/history"
+)
+
+func TestBuildSummaryEmbed(t *testing.T) {
+	summary := history.Summary{
+		Text:      "- 旅行の計画について相談\n- 予算は5万円",
+		UpdatedAt: time.Date(2026, 10, 18, 3, 4, 0, 0, time.UTC),
+	}
+	embed := buildSummaryEmbed(summary)
+	if embed.Description != summary.Text {
+		t.Errorf("unexpected description: %q", embed.Description)
+	}
+	if embed.Footer == nil || embed.Footer.Text != "更新: 2026/10/18 12:04" {
+		t.Errorf("unexpected footer: %+v", embed.Footer)
+	}
+}
@@ -1,10 +1,12 @@
 package discord
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/eraiza0816/llm-discord/chat"
 )
 
 var errorLogger *log.Logger
@@ -27,8 +29,9 @@ func sendErrorResponse(s *discordgo.Session, i *discordgo.InteractionCreate, err
 	}
 
 	_, editErr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &content,
-		Embeds:  &[]*discordgo.MessageEmbed{},
+		Content:    &content,
+		Embeds:     &[]*discordgo.MessageEmbed{},
+		Components: &[]discordgo.MessageComponent{},
 	})
 	if editErr != nil {
 		if errorLogger != nil {
@@ -61,3 +64,28 @@ func sendEphemeralErrorResponse(s *discordgo.Session, i *discordgo.InteractionCr
 		}
 	}
 }
+
+// blockedErrorMessage は err が Gemini による応答のブロックであれば、その理由を説明する文を返します。
+func blockedErrorMessage(err error) (string, bool) {
+	var blocked *chat.ContentBlockedError
+	if errors.As(err, &blocked) {
+		return blocked.UserMessage(), true
+	}
+	return "", false
+}
+
+// modalTextValue はモーダルの送信内容から、CustomID が customID のテキスト入力の値を返します。
+func modalTextValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range row.Components {
+			if input, ok := c.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
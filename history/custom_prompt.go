@@ -0,0 +1,241 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// カスタムプロンプトの版の状態。
+const (
+	CustomPromptApproved   = "approved"   // 反映された (審査が無い場合は設定した時点で反映される)
+	CustomPromptPending    = "pending"    // 管理者の承認待ち
+	CustomPromptRejected   = "rejected"   // 管理者が却下した
+	CustomPromptSuperseded = "superseded" // 承認待ちの間に、同じユーザーが新しい変更を出した
+)
+
+// ErrCustomPromptNotPending は承認待ちでない版を審査しようとした場合に返されます。
+var ErrCustomPromptNotPending = errors.New("この変更は既に審査済みか、存在しません")
+
+// CustomPromptVersion はカスタムプロンプトの変更の1件です。
+type CustomPromptVersion struct {
+	ID         int64
+	UserID     string
+	Prompt     string // 空の場合は削除
+	Status     string
+	CreatedAt  time.Time
+	ReviewedBy string // 承認・却下した管理者のユーザーID (審査を経ていない場合は空)
+}
+
+// CustomPromptStore はユーザーが /edit で設定したシステムプロンプトを、ユーザーIDごとに保存します。
+// 変更は全て版として記録します。
+type CustomPromptStore interface {
+	// GetCustomPrompt は userID の反映中のカスタムプロンプトを返します。無い場合は ok が false です。
+	GetCustomPrompt(userID string) (prompt string, ok bool, err error)
+	// SetCustomPrompt は userID のカスタムプロンプトを設定し、反映済みの版として記録します。prompt が空の場合は削除します。
+	SetCustomPrompt(userID, prompt string) error
+	// SubmitCustomPrompt は prompt を承認待ちの版として記録します。同じユーザーの以前の承認待ちの版は superseded になります。
+	SubmitCustomPrompt(userID, prompt string) (CustomPromptVersion, error)
+	// ReviewCustomPrompt は承認待ちの版 id を承認して反映するか、却下します。承認待ちでない場合は ErrCustomPromptNotPending です。
+	ReviewCustomPrompt(id int64, approve bool, reviewerID string) (CustomPromptVersion, error)
+	// CustomPromptVersions は userID の版を新しい順に返します。
+	CustomPromptVersions(userID string) ([]CustomPromptVersion, error)
+}
+
+const createCustomPromptTableSQL = `
+	CREATE TABLE IF NOT EXISTS custom_prompts (
+		user_id VARCHAR PRIMARY KEY,
+		prompt TEXT NOT NULL,
+		updated_at TIMESTAMP
+	);
+	CREATE SEQUENCE IF NOT EXISTS custom_prompt_versions_id_seq;
+	CREATE TABLE IF NOT EXISTS custom_prompt_versions (
+		id BIGINT PRIMARY KEY DEFAULT nextval('custom_prompt_versions_id_seq'),
+		user_id VARCHAR NOT NULL,
+		prompt TEXT NOT NULL,
+		status VARCHAR NOT NULL,
+		created_at TIMESTAMP,
+		reviewed_by VARCHAR NOT NULL DEFAULT ''
+	);`
+
+var (
+	_ CustomPromptStore = (*DuckDBHistoryManager)(nil)
+	_ CustomPromptStore = (*InMemoryHistoryManager)(nil)
+)
+
+func (m *DuckDBHistoryManager) GetCustomPrompt(userID string) (string, bool, error) {
+	var prompt string
+	err := m.db.QueryRow("SELECT prompt FROM custom_prompts WHERE user_id = ?;", userID).Scan(&prompt)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("カスタムプロンプトのクエリ実行に失敗しました: %w", err)
+	}
+	return prompt, true, nil
+}
+
+func (m *DuckDBHistoryManager) SetCustomPrompt(userID, prompt string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, err := m.insertCustomPromptVersion(userID, prompt, CustomPromptApproved); err != nil {
+		return err
+	}
+	return m.applyCustomPrompt(userID, prompt)
+}
+
+func (m *DuckDBHistoryManager) SubmitCustomPrompt(userID, prompt string) (CustomPromptVersion, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, err := m.db.Exec("UPDATE custom_prompt_versions SET status = ? WHERE user_id = ? AND status = ?;", CustomPromptSuperseded, userID, CustomPromptPending); err != nil {
+		return CustomPromptVersion{}, fmt.Errorf("以前の承認待ちの変更の更新に失敗しました: %w", err)
+	}
+	return m.insertCustomPromptVersion(userID, prompt, CustomPromptPending)
+}
+
+func (m *DuckDBHistoryManager) ReviewCustomPrompt(id int64, approve bool, reviewerID string) (CustomPromptVersion, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var v CustomPromptVersion
+	err := m.db.QueryRow("SELECT id, user_id, prompt, status, created_at, reviewed_by FROM custom_prompt_versions WHERE id = ?;", id).
+		Scan(&v.ID, &v.UserID, &v.Prompt, &v.Status, &v.CreatedAt, &v.ReviewedBy)
+	if err == sql.ErrNoRows || (err == nil && v.Status != CustomPromptPending) {
+		return CustomPromptVersion{}, ErrCustomPromptNotPending
+	}
+	if err != nil {
+		return CustomPromptVersion{}, fmt.Errorf("カスタムプロンプトの変更のクエリ実行に失敗しました: %w", err)
+	}
+	v.Status, v.ReviewedBy = reviewedStatus(approve), reviewerID
+	if _, err := m.db.Exec("UPDATE custom_prompt_versions SET status = ?, reviewed_by = ? WHERE id = ?;", v.Status, v.ReviewedBy, v.ID); err != nil {
+		return CustomPromptVersion{}, fmt.Errorf("カスタムプロンプトの変更の審査結果の保存に失敗しました: %w", err)
+	}
+	if approve {
+		if err := m.applyCustomPrompt(v.UserID, v.Prompt); err != nil {
+			return CustomPromptVersion{}, err
+		}
+	}
+	return v, nil
+}
+
+func (m *DuckDBHistoryManager) CustomPromptVersions(userID string) ([]CustomPromptVersion, error) {
+	rows, err := m.db.Query("SELECT id, user_id, prompt, status, created_at, reviewed_by FROM custom_prompt_versions WHERE user_id = ? ORDER BY id DESC;", userID)
+	if err != nil {
+		return nil, fmt.Errorf("カスタムプロンプトの変更のクエリ実行に失敗しました: %w", err)
+	}
+	defer rows.Close()
+	var versions []CustomPromptVersion
+	for rows.Next() {
+		var v CustomPromptVersion
+		if err := rows.Scan(&v.ID, &v.UserID, &v.Prompt, &v.Status, &v.CreatedAt, &v.ReviewedBy); err != nil {
+			return nil, fmt.Errorf("カスタムプロンプトの変更の読み込みに失敗しました: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// insertCustomPromptVersion は版を1件記録します。呼び出し側で m.mutex を取得してください。
+func (m *DuckDBHistoryManager) insertCustomPromptVersion(userID, prompt, status string) (CustomPromptVersion, error) {
+	v := CustomPromptVersion{UserID: userID, Prompt: prompt, Status: status, CreatedAt: time.Now()}
+	err := m.db.QueryRow("INSERT INTO custom_prompt_versions (user_id, prompt, status, created_at) VALUES (?, ?, ?, ?) RETURNING id;",
+		userID, prompt, status, v.CreatedAt).Scan(&v.ID)
+	if err != nil {
+		return CustomPromptVersion{}, fmt.Errorf("カスタムプロンプトの変更の記録に失敗しました: %w", err)
+	}
+	return v, nil
+}
+
+// applyCustomPrompt は userID の反映中のカスタムプロンプトを prompt にします。呼び出し側で m.mutex を取得してください。
+func (m *DuckDBHistoryManager) applyCustomPrompt(userID, prompt string) error {
+	if prompt == "" {
+		if _, err := m.db.Exec("DELETE FROM custom_prompts WHERE user_id = ?;", userID); err != nil {
+			return fmt.Errorf("カスタムプロンプトの削除に失敗しました: %w", err)
+		}
+		return nil
+	}
+	upsertSQL := `
+	INSERT INTO custom_prompts (user_id, prompt, updated_at) VALUES (?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET prompt = excluded.prompt, updated_at = excluded.updated_at;`
+	if _, err := m.db.Exec(upsertSQL, userID, prompt, time.Now()); err != nil {
+		return fmt.Errorf("カスタムプロンプトの保存に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (m *InMemoryHistoryManager) GetCustomPrompt(userID string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	prompt, ok := m.customPrompts[userID]
+	return prompt, ok, nil
+}
+
+func (m *InMemoryHistoryManager) SetCustomPrompt(userID, prompt string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addCustomPromptVersion(userID, prompt, CustomPromptApproved)
+	m.applyCustomPrompt(userID, prompt)
+	return nil
+}
+
+func (m *InMemoryHistoryManager) SubmitCustomPrompt(userID, prompt string) (CustomPromptVersion, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, v := range m.customPromptVersions {
+		if v.UserID == userID && v.Status == CustomPromptPending {
+			m.customPromptVersions[i].Status = CustomPromptSuperseded
+		}
+	}
+	return m.addCustomPromptVersion(userID, prompt, CustomPromptPending), nil
+}
+
+func (m *InMemoryHistoryManager) ReviewCustomPrompt(id int64, approve bool, reviewerID string) (CustomPromptVersion, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := slices.IndexFunc(m.customPromptVersions, func(v CustomPromptVersion) bool { return v.ID == id })
+	if i < 0 || m.customPromptVersions[i].Status != CustomPromptPending {
+		return CustomPromptVersion{}, ErrCustomPromptNotPending
+	}
+	v := &m.customPromptVersions[i]
+	v.Status, v.ReviewedBy = reviewedStatus(approve), reviewerID
+	if approve {
+		m.applyCustomPrompt(v.UserID, v.Prompt)
+	}
+	return *v, nil
+}
+
+func (m *InMemoryHistoryManager) CustomPromptVersions(userID string) ([]CustomPromptVersion, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var versions []CustomPromptVersion
+	for _, v := range slices.Backward(m.customPromptVersions) {
+		if v.UserID == userID {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *InMemoryHistoryManager) addCustomPromptVersion(userID, prompt, status string) CustomPromptVersion {
+	m.lastCustomPromptVersionID++
+	v := CustomPromptVersion{ID: m.lastCustomPromptVersionID, UserID: userID, Prompt: prompt, Status: status, CreatedAt: time.Now()}
+	m.customPromptVersions = append(m.customPromptVersions, v)
+	return v
+}
+
+func (m *InMemoryHistoryManager) applyCustomPrompt(userID, prompt string) {
+	if prompt == "" {
+		delete(m.customPrompts, userID)
+		return
+	}
+	m.customPrompts[userID] = prompt
+}
+
+// reviewedStatus は審査の結果を版の状態にします。
+func reviewedStatus(approve bool) string {
+	if approve {
+		return CustomPromptApproved
+	}
+	return CustomPromptRejected
+}
@@ -0,0 +1,108 @@
+package history
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCustomPromptStore(t *testing.T) {
+	duckdb, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 10)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer duckdb.Close()
+	inMemory, _ := NewInMemoryHistoryManager(10)
+
+	stores := map[string]CustomPromptStore{"duckdb": duckdb, "in-memory": inMemory.(CustomPromptStore)}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, ok, err := store.GetCustomPrompt("u1"); err != nil || ok {
+				t.Fatalf("expected no prompt, got ok=%v err=%v", ok, err)
+			}
+			if err := store.SetCustomPrompt("u1", "あなたは猫です。"); err != nil {
+				t.Fatalf("SetCustomPrompt failed: %v", err)
+			}
+			if err := store.SetCustomPrompt("u1", "あなたは犬です。"); err != nil {
+				t.Fatalf("SetCustomPrompt (update) failed: %v", err)
+			}
+			if prompt, ok, err := store.GetCustomPrompt("u1"); err != nil || !ok || prompt != "あなたは犬です。" {
+				t.Errorf("expected the updated prompt, got %q ok=%v err=%v", prompt, ok, err)
+			}
+			if _, ok, _ := store.GetCustomPrompt("u2"); ok {
+				t.Errorf("prompts should be per user")
+			}
+			if err := store.SetCustomPrompt("u1", ""); err != nil {
+				t.Fatalf("SetCustomPrompt (delete) failed: %v", err)
+			}
+			if _, ok, _ := store.GetCustomPrompt("u1"); ok {
+				t.Errorf("prompt should be deleted")
+			}
+		})
+	}
+}
+
+func TestCustomPromptVersions(t *testing.T) {
+	duckdb, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 10)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer duckdb.Close()
+	inMemory, _ := NewInMemoryHistoryManager(10)
+
+	stores := map[string]CustomPromptStore{"duckdb": duckdb, "in-memory": inMemory.(CustomPromptStore)}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := store.SetCustomPrompt("u1", "あなたは猫です。"); err != nil {
+				t.Fatalf("SetCustomPrompt failed: %v", err)
+			}
+			first, err := store.SubmitCustomPrompt("u1", "あなたは犬です。")
+			if err != nil {
+				t.Fatalf("SubmitCustomPrompt failed: %v", err)
+			}
+			second, err := store.SubmitCustomPrompt("u1", "あなたは鳥です。")
+			if err != nil {
+				t.Fatalf("SubmitCustomPrompt failed: %v", err)
+			}
+			if prompt, _, _ := store.GetCustomPrompt("u1"); prompt != "あなたは猫です。" {
+				t.Errorf("pending prompts should not be applied, got %q", prompt)
+			}
+			if _, err := store.ReviewCustomPrompt(first.ID, true, "mod"); err != ErrCustomPromptNotPending {
+				t.Errorf("superseded version should not be reviewable, got %v", err)
+			}
+			v, err := store.ReviewCustomPrompt(second.ID, true, "mod")
+			if err != nil || v.Status != CustomPromptApproved || v.ReviewedBy != "mod" {
+				t.Fatalf("ReviewCustomPrompt failed: %+v %v", v, err)
+			}
+			if prompt, _, _ := store.GetCustomPrompt("u1"); prompt != "あなたは鳥です。" {
+				t.Errorf("approved prompt should be applied, got %q", prompt)
+			}
+			if _, err := store.ReviewCustomPrompt(second.ID, false, "mod"); err != ErrCustomPromptNotPending {
+				t.Errorf("reviewed version should not be reviewed twice, got %v", err)
+			}
+			rejected, _ := store.SubmitCustomPrompt("u1", "あなたは魚です。")
+			if _, err := store.ReviewCustomPrompt(rejected.ID, false, "mod"); err != nil {
+				t.Fatalf("ReviewCustomPrompt (reject) failed: %v", err)
+			}
+			if prompt, _, _ := store.GetCustomPrompt("u1"); prompt != "あなたは鳥です。" {
+				t.Errorf("rejected prompt should not be applied, got %q", prompt)
+			}
+
+			versions, err := store.CustomPromptVersions("u1")
+			if err != nil {
+				t.Fatalf("CustomPromptVersions failed: %v", err)
+			}
+			var statuses []string
+			for _, v := range versions {
+				statuses = append(statuses, v.Status)
+			}
+			want := []string{CustomPromptRejected, CustomPromptApproved, CustomPromptSuperseded, CustomPromptApproved}
+			if !slices.Equal(statuses, want) {
+				t.Errorf("expected statuses %v (newest first), got %v", want, statuses)
+			}
+			if others, _ := store.CustomPromptVersions("u2"); len(others) != 0 {
+				t.Errorf("versions should be per user, got %v", others)
+			}
+		})
+	}
+}
@@ -14,12 +14,18 @@ import (
 )
 
 type DuckDBHistoryManager struct {
-	db    *sql.DB
-	mutex sync.Mutex
+	db             *sql.DB
+	mutex          sync.Mutex
+	maxHistorySize int // Get で返す最大ペア数。0 以下の場合は全件
 }
 
-func NewDuckDBHistoryManager() (*DuckDBHistoryManager, error) {
-	dbPath := filepath.Join("data", "chat_history.duckdb")
+// NewDuckDBHistoryManager は data/chat_history.duckdb に履歴を保存する HistoryManager を作成します。
+// maxHistorySize は Get で返す最大ペア数です (model.json の max_history_size)。
+func NewDuckDBHistoryManager(maxHistorySize int) (*DuckDBHistoryManager, error) {
+	return newDuckDBHistoryManager(filepath.Join("data", "chat_history.duckdb"), maxHistorySize)
+}
+
+func newDuckDBHistoryManager(dbPath string, maxHistorySize int) (*DuckDBHistoryManager, error) {
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, fmt.Errorf("データディレクトリの作成に失敗しました: %w", err)
 	}
@@ -40,12 +46,36 @@ func NewDuckDBHistoryManager() (*DuckDBHistoryManager, error) {
 	if _, err := db.Exec(createTableSQL); err != nil {
 		return nil, fmt.Errorf("thread_historiesテーブルの作成に失敗しました: %w", err)
 	}
+	if _, err := db.Exec(createSummaryTableSQL); err != nil {
+		return nil, fmt.Errorf("thread_summariesテーブルの作成に失敗しました: %w", err)
+	}
+	if _, err := db.Exec(createURLCacheTableSQL); err != nil {
+		return nil, fmt.Errorf("url_cacheテーブルの作成に失敗しました: %w", err)
+	}
+	if _, err := db.Exec(createPersonaTableSQL); err != nil {
+		return nil, fmt.Errorf("persona_selectionsテーブルの作成に失敗しました: %w", err)
+	}
+	if _, err := db.Exec(createMemoryTablesSQL); err != nil {
+		return nil, fmt.Errorf("user_memoriesテーブルの作成に失敗しました: %w", err)
+	}
+	if _, err := db.Exec(createCustomPromptTableSQL); err != nil {
+		return nil, fmt.Errorf("custom_promptsテーブルの作成に失敗しました: %w", err)
+	}
 
 	log.Println("DuckDB HistoryManagerが正常に初期化されました。データベースパス:", dbPath)
-	return &DuckDBHistoryManager{db: db}, nil
+	return &DuckDBHistoryManager{db: db, maxHistorySize: maxHistorySize}, nil
 }
 
 func (m *DuckDBHistoryManager) Add(userID, threadID, message, response string) error {
+	return m.AddMessages(userID, threadID, HistoryMessage{Role: "user", Content: message}, HistoryMessage{Role: "model", Content: response})
+}
+
+// AddMessages は任意のメッセージを履歴に追加します。
+// 共有スレッドでは複数の参加者が同じ行に追加するため、読み込みから書き込みまでを m.mutex で保護します。
+func (m *DuckDBHistoryManager) AddMessages(userID, threadID string, messages ...HistoryMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	var currentHistoryJSON string
 	querySQL := "SELECT history_json FROM thread_histories WHERE user_id = ? AND thread_id = ?;"
 	err := m.db.QueryRow(querySQL, userID, threadID).Scan(&currentHistoryJSON)
@@ -63,8 +93,12 @@ func (m *DuckDBHistoryManager) Add(userID, threadID, message, response string) e
 		}
 	}
 
-	history = append(history, HistoryMessage{Role: "user", Content: message})
-	history = append(history, HistoryMessage{Role: "model", Content: response})
+	// Get で返す範囲より古い履歴は、要約に含まれたものを削除する
+	summary, _, err := m.GetSummary(userID, threadID)
+	if err != nil {
+		return err
+	}
+	history = pruneHistory(append(history, stampCreatedAt(messages, time.Now())...), summary, m.maxHistorySize)
 
 	historyJSON, err := json.Marshal(history)
 	if err != nil {
@@ -87,8 +121,24 @@ func (m *DuckDBHistoryManager) Add(userID, threadID, message, response string) e
 }
 
 // Get は指定されたユーザーとスレッドの履歴を取得します。
-// データベースからは全ての履歴を取得し、最新20ペアを返します。
+// 最新 maxHistorySize ペアを返します。プロンプトに収まる量への調整は chat パッケージがトークン数で行います。
 func (m *DuckDBHistoryManager) Get(userID, threadID string) ([]HistoryMessage, error) {
+	recent, _, err := m.GetWithOverflow(userID, threadID)
+	return recent, err
+}
+
+// GetWithOverflow は最新 maxHistorySize ペアと、それより古く保存されている (まだ要約されていない) 履歴を取得します。
+func (m *DuckDBHistoryManager) GetWithOverflow(userID, threadID string) ([]HistoryMessage, []HistoryMessage, error) {
+	fullHistory, err := m.getStored(userID, threadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	recent, overflow := splitHistory(fullHistory, m.maxHistorySize)
+	return recent, overflow, nil
+}
+
+// getStored は指定されたユーザーとスレッドの保存されている全ての履歴を取得します。
+func (m *DuckDBHistoryManager) getStored(userID, threadID string) ([]HistoryMessage, error) {
 	var historyJSON string
 	querySQL := "SELECT history_json FROM thread_histories WHERE user_id = ? AND thread_id = ?;"
 	err := m.db.QueryRow(querySQL, userID, threadID).Scan(&historyJSON)
@@ -104,13 +154,6 @@ func (m *DuckDBHistoryManager) Get(userID, threadID string) ([]HistoryMessage, e
 	if err := json.Unmarshal([]byte(historyJSON), &fullHistory); err != nil {
 		return nil, fmt.Errorf("履歴のJSONデシリアライズに失敗しました: %w", err)
 	}
-
-	// 最新20ペアを抽出する
-	maxHistorySizeToReturn := 20
-	if len(fullHistory) > maxHistorySizeToReturn*2 {
-		return fullHistory[len(fullHistory)-maxHistorySizeToReturn*2:], nil
-	}
-
 	return fullHistory, nil
 }
 
@@ -127,6 +170,9 @@ func (m *DuckDBHistoryManager) Clear(userID, threadID string) error {
 	if rowsAffected == 0 {
 		log.Printf("ユーザー %s のスレッド %s の履歴は見つからなかったため、削除されませんでした。", userID, threadID)
 	}
+	if _, err := m.db.Exec("DELETE FROM thread_summaries WHERE user_id = ? AND thread_id = ?;", userID, threadID); err != nil {
+		return fmt.Errorf("要約の削除に失敗しました: %w", err)
+	}
 	return nil
 }
 
@@ -145,6 +191,9 @@ func (m *DuckDBHistoryManager) ClearAllByThreadID(threadID string) error {
 	} else {
 		log.Printf("スレッド %s の履歴 %d 件を削除しました。", threadID, rowsAffected)
 	}
+	if _, err := m.db.Exec("DELETE FROM thread_summaries WHERE thread_id = ?;", threadID); err != nil {
+		return fmt.Errorf("スレッド %s の要約の削除に失敗しました: %w", threadID, err)
+	}
 	return nil
 }
 
@@ -153,30 +202,3 @@ func (m *DuckDBHistoryManager) Close() error {
 	defer m.mutex.Unlock()
 	return m.db.Close()
 }
-
-func (m *DuckDBHistoryManager) GetBotConversationCount(threadID, userID string) (int, error) {
-	var historyJSON string
-	querySQL := "SELECT history_json FROM thread_histories WHERE user_id = ? AND thread_id = ?;"
-	err := m.db.QueryRow(querySQL, userID, threadID).Scan(&historyJSON)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, nil
-		}
-		return 0, fmt.Errorf("履歴のクエリ実行に失敗しました: %w", err)
-	}
-
-	var history []HistoryMessage
-	if err := json.Unmarshal([]byte(historyJSON), &history); err != nil {
-		return 0, fmt.Errorf("履歴のJSONデシリアライズに失敗しました: %w", err)
-	}
-
-	// ユーザーのメッセージ数をカウントする
-	userMessageCount := 0
-	for _, msg := range history {
-		if msg.Role == "user" {
-			userMessageCount++
-		}
-	}
-
-	return userMessageCount, nil
-}
@@ -0,0 +1,98 @@
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDuckDBHistoryManagerMaxHistorySize(t *testing.T) {
+	for _, tc := range []struct {
+		maxHistorySize int
+		want           int
+		stored         int
+	}{
+		{maxHistorySize: 2, want: 4, stored: 8},   // 要約されていない古い履歴も max_history_size ペアまで残す
+		{maxHistorySize: 0, want: 10, stored: 10}, // 0 は全件
+	} {
+		t.Run(fmt.Sprintf("max_history_size=%d", tc.maxHistorySize), func(t *testing.T) {
+			m, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), tc.maxHistorySize)
+			if err != nil {
+				t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+			}
+			defer m.Close()
+			for i := 0; i < 5; i++ {
+				if err := m.Add("user1", "thread1", fmt.Sprintf("q%d", i), fmt.Sprintf("a%d", i)); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+			}
+
+			msgs, err := m.Get("user1", "thread1")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if len(msgs) != tc.want || msgs[len(msgs)-1].Content != "a4" {
+				t.Errorf("expected the latest %d messages, got %+v", tc.want, msgs)
+			}
+			if recent, overflow, err := m.GetWithOverflow("user1", "thread1"); err != nil || len(recent) != tc.want || len(recent)+len(overflow) != tc.stored {
+				t.Errorf("expected %d stored messages, got %d (err=%v)", tc.stored, len(recent)+len(overflow), err)
+			}
+		})
+	}
+}
+
+func TestDuckDBHistoryManagerPrunesSummarizedOverflow(t *testing.T) {
+	m, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 1)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer m.Close()
+	for i := 0; i < 3; i++ {
+		m.Add("user1", "thread1", fmt.Sprintf("q%d", i), fmt.Sprintf("a%d", i))
+	}
+	_, overflow, _ := m.GetWithOverflow("user1", "thread1")
+	if len(overflow) != 2 || overflow[0].Content != "q1" {
+		t.Fatalf("unsummarized overflow should be capped to max_history_size pairs, got %+v", overflow)
+	}
+	m.SaveSummary("user1", "thread1", Summary{Text: "要約", CoveredUntil: overflow[1].CreatedAt, UpdatedAt: time.Now()})
+
+	m.Add("user1", "thread1", "q3", "a3")
+	recent, overflow, err := m.GetWithOverflow("user1", "thread1")
+	if err != nil {
+		t.Fatalf("GetWithOverflow failed: %v", err)
+	}
+	if len(overflow) != 2 || overflow[0].Content != "q2" || len(recent) != 2 || recent[0].Content != "q3" {
+		t.Errorf("summarized overflow should be pruned, got %+v / %+v", overflow, recent)
+	}
+}
+
+func TestDuckDBHistoryManagerConcurrentAddMessages(t *testing.T) {
+	m, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 0)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer m.Close()
+
+	// 共有スレッドでは参加者全員が同じ行に追加する
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Add(SharedUserID, "thread1", fmt.Sprintf("q%d", i), fmt.Sprintf("a%d", i)); err != nil {
+				t.Errorf("Add failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	msgs, err := m.Get(SharedUserID, "thread1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(msgs) != 20 {
+		t.Errorf("concurrent additions should not overwrite each other, got %d messages", len(msgs))
+	}
+}
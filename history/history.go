@@ -1,51 +1,111 @@
 package history
 
 import (
+	"slices"
 	"sync"
+	"time"
 )
 
 // HistoryMessage はチャット履歴の単一のメッセージを表します。
 type HistoryMessage struct {
 	Role    string `json:"role"`    // "user" または "model"
 	Content string `json:"content"` // メッセージの内容
+	// Name は共有スレッド (shared_threads) での発言者の表示名です。個別の履歴では空です。
+	Name string `json:"name,omitempty"`
+	// Incomplete は停止ボタンや期限切れで生成が途中で止まった応答であることを表します。
+	Incomplete bool `json:"incomplete,omitempty"`
+	// Grounding は Google 検索によるグラウンディングで参照した出典です。
+	Grounding *Grounding `json:"grounding,omitempty"`
+	// CreatedAt は履歴に追加された時刻です。要約済みの範囲の判定に使います (この項目より前の履歴はゼロ値)。
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// SharedUserID は、スレッドの参加者全員で1つの履歴を共有する場合に userID の代わりに使うキーです。
+// Discord のユーザーIDは数字のみのため、実在のユーザーと重なることはありません。
+const SharedUserID = "shared"
+
+// Speaker はプロンプトでの発言者の表記を返します。モデルの発言は "assistant"、名前の無いユーザーの発言は "user" です。
+func (m HistoryMessage) Speaker() string {
+	if m.Role == "model" || m.Role == "assistant" {
+		return "assistant"
+	}
+	if m.Name != "" {
+		return m.Name
+	}
+	return "user"
+}
+
+// stampCreatedAt は CreatedAt が未設定のメッセージに now を設定したコピーを返します。
+func stampCreatedAt(messages []HistoryMessage, now time.Time) []HistoryMessage {
+	stamped := make([]HistoryMessage, len(messages))
+	for i, msg := range messages {
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = now
+		}
+		stamped[i] = msg
+	}
+	return stamped
+}
+
+// Grounding は応答の生成時に実行された検索クエリと、参照したWebページを表します。
+type Grounding struct {
+	Queries []string          `json:"queries,omitempty"`
+	Sources []GroundingSource `json:"sources,omitempty"`
+}
+
+// GroundingSource はグラウンディングで参照したWebページです。
+type GroundingSource struct {
+	Title string `json:"title"`
+	URI   string `json:"uri"`
 }
 
 type HistoryManager interface {
 	Add(userID string, threadID string, message string, response string) error // errorを返すように変更
-	Get(userID string, threadID string) ([]HistoryMessage, error)             // 戻り値を []HistoryMessage, error に変更
-	Clear(userID string, threadID string) error                               // errorを返すように変更
-	ClearAllByThreadID(threadID string) error                                 // errorを返すように変更
-	GetBotConversationCount(threadID, userID string) (int, error)
+	AddMessages(userID string, threadID string, messages ...HistoryMessage) error
+	Get(userID string, threadID string) ([]HistoryMessage, error) // 戻り値を []HistoryMessage, error に変更
+	Clear(userID string, threadID string) error                   // errorを返すように変更
+	ClearAllByThreadID(threadID string) error                     // errorを返すように変更
 	Close() error
 }
 
 type InMemoryHistoryManager struct {
-	histories      map[string][]HistoryMessage // ユーザーIDをキーにした履歴のスライス ([]HistoryMessage に変更)
-	mutex          sync.Mutex
-	maxHistorySize int
+	histories                 map[string][]HistoryMessage // ユーザーIDをキーにした履歴のスライス ([]HistoryMessage に変更)
+	summaries                 map[string]Summary          // summaryKey をキーにした要約
+	personas                  map[string]string           // "scope/targetID" をキーにした選択中のペルソナ
+	memories                  map[string][]UserMemory     // ユーザーIDをキーにした長期的な記憶
+	memoryDisabled            map[string]bool             // /memory off で記憶を無効にしたユーザー
+	lastMemoryID              int64
+	customPrompts             map[string]string     // ユーザーIDをキーにした /edit のカスタムプロンプト
+	customPromptVersions      []CustomPromptVersion // カスタムプロンプトの変更 (古い順)
+	lastCustomPromptVersionID int64
+	mutex                     sync.Mutex
+	maxHistorySize            int
 }
 
 func NewInMemoryHistoryManager(maxSize int) (HistoryManager, error) { // 戻り値に error を追加
 	return &InMemoryHistoryManager{
 		histories:      make(map[string][]HistoryMessage),
+		summaries:      make(map[string]Summary),
+		personas:       make(map[string]string),
+		memories:       make(map[string][]UserMemory),
+		memoryDisabled: make(map[string]bool),
+		customPrompts:  make(map[string]string),
 		maxHistorySize: maxSize,
 	}, nil
 }
 
 func (m *InMemoryHistoryManager) Add(userID string, threadID string, message string, response string) error {
+	return m.AddMessages(userID, threadID, HistoryMessage{Role: "user", Content: message}, HistoryMessage{Role: "model", Content: response})
+}
+
+// AddMessages は任意のメッセージを履歴に追加します。
+func (m *InMemoryHistoryManager) AddMessages(userID string, threadID string, messages ...HistoryMessage) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	userHistory := m.histories[userID]
-	userHistory = append(userHistory, HistoryMessage{Role: "user", Content: message})
-	userHistory = append(userHistory, HistoryMessage{Role: "model", Content: response})
-
-	// 履歴の最大サイズを超えた場合、古いものから削除 (ペアで考慮)
-	if len(userHistory) > m.maxHistorySize*2 {
-		removeCount := len(userHistory) - m.maxHistorySize*2
-		userHistory = userHistory[removeCount:]
-	}
-	m.histories[userID] = userHistory
+	// DuckDB 版と同じく、Get で返す範囲より古い履歴も要約されるまで残す
+	history := append(m.histories[userID], stampCreatedAt(messages, time.Now())...)
+	m.histories[userID] = pruneHistory(history, m.summaries[summaryKey(userID, threadID)], m.maxHistorySize)
 	return nil
 }
 
@@ -57,14 +117,55 @@ func (m *InMemoryHistoryManager) Get(userID string, threadID string) ([]HistoryM
 	if !ok {
 		return []HistoryMessage{}, nil // 履歴がない場合は空のスライスを返す
 	}
+	return slices.Clone(capHistory(history, m.maxHistorySize)), nil
+}
+
+func (m *InMemoryHistoryManager) GetWithOverflow(userID string, threadID string) ([]HistoryMessage, []HistoryMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	recent, overflow := splitHistory(m.histories[userID], m.maxHistorySize)
+	return append([]HistoryMessage{}, recent...), slices.Clone(overflow), nil
+}
+
+// capHistory は history の最新 maxHistorySize ペアを返します。maxHistorySize が 0 以下の場合は全件です。
+func capHistory(history []HistoryMessage, maxHistorySize int) []HistoryMessage {
+	recent, _ := splitHistory(history, maxHistorySize)
+	return recent
+}
+
+// splitHistory は history を、最新 maxHistorySize ペア (recent) とそれより古い履歴 (overflow) に分けます。
+func splitHistory(history []HistoryMessage, maxHistorySize int) (recent, overflow []HistoryMessage) {
+	if maxHistorySize > 0 && len(history) > maxHistorySize*2 {
+		split := len(history) - maxHistorySize*2
+		return history[split:], history[:split]
+	}
 	return history, nil
 }
 
+// pruneHistory は、最新 maxHistorySize ペアより古い履歴のうち summary に含まれたものを取り除きます。
+// 要約されずに残る古い履歴も、要約が無効な場合などに増え続けないよう maxHistorySize ペアまでにします。
+func pruneHistory(history []HistoryMessage, summary Summary, maxHistorySize int) []HistoryMessage {
+	recent, overflow := splitHistory(history, maxHistorySize)
+	if len(overflow) == 0 {
+		return history
+	}
+	var kept []HistoryMessage
+	for _, msg := range overflow {
+		if !summary.Covers(msg) {
+			kept = append(kept, msg)
+		}
+	}
+	kept = capHistory(kept, maxHistorySize)
+	return append(kept, recent...)
+}
+
 func (m *InMemoryHistoryManager) Clear(userID string, threadID string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	delete(m.histories, userID)
+	delete(m.summaries, summaryKey(userID, threadID))
 	return nil
 }
 
@@ -75,11 +176,6 @@ func (m *InMemoryHistoryManager) ClearAllByThreadID(threadID string) error {
 	return nil
 }
 
-func (m *InMemoryHistoryManager) GetBotConversationCount(threadID, userID string) (int, error) {
-	// InMemoryHistoryManagerではこの機能は未実装とする
-	return 0, nil
-}
-
 func (m *InMemoryHistoryManager) Close() error {
 	return nil
 }
@@ -2,6 +2,7 @@ package history
 
 import (
 	"testing"
+	"time"
 )
 
 func TestInMemoryHistoryManager(t *testing.T) {
@@ -37,6 +38,21 @@ func TestInMemoryHistoryManager(t *testing.T) {
 		}
 	})
 
+	t.Run("AddMessages keeps incomplete flag", func(t *testing.T) {
+		mgr, _ := NewInMemoryHistoryManager(10)
+		err := mgr.AddMessages("user1", "thread1",
+			HistoryMessage{Role: "user", Content: "hello"},
+			HistoryMessage{Role: "model", Content: "wor", Incomplete: true},
+		)
+		if err != nil {
+			t.Fatalf("AddMessages failed: %v", err)
+		}
+		msgs, _ := mgr.Get("user1", "thread1")
+		if len(msgs) != 2 || !msgs[1].Incomplete || msgs[0].Incomplete {
+			t.Errorf("Unexpected messages: %+v", msgs)
+		}
+	})
+
 	t.Run("Get returns empty slice for unknown user", func(t *testing.T) {
 		mgr, _ := NewInMemoryHistoryManager(10)
 		msgs, err := mgr.Get("unknown", "thread1")
@@ -73,6 +89,25 @@ func TestInMemoryHistoryManager(t *testing.T) {
 		if len(msgs) > 4 {
 			t.Errorf("Expected at most 4 messages (2 pairs), got %d", len(msgs))
 		}
+		// 要約されていない古い履歴は、max_history_size ペアまで残す
+		if recent, overflow, _ := mgr.(SummaryStore).GetWithOverflow("user1", "thread1"); len(recent) != 4 || len(overflow) != 4 {
+			t.Errorf("expected 4 recent and 4 overflow messages, got %d and %d", len(recent), len(overflow))
+		}
+	})
+
+	t.Run("Summarized overflow is pruned", func(t *testing.T) {
+		mgr, _ := NewInMemoryHistoryManager(1)
+		store := mgr.(SummaryStore)
+		for i := 0; i < 3; i++ {
+			mgr.Add("user1", "thread1", "msg", "resp")
+		}
+		_, overflow, _ := store.GetWithOverflow("user1", "thread1")
+		store.SaveSummary("user1", "thread1", Summary{Text: "要約", CoveredUntil: overflow[len(overflow)-1].CreatedAt, UpdatedAt: time.Now()})
+		mgr.Add("user1", "thread1", "new", "resp")
+		recent, overflow, _ := store.GetWithOverflow("user1", "thread1")
+		if len(overflow) != 2 || len(recent) != 2 || recent[0].Content != "new" {
+			t.Errorf("only the turn pushed out after the summary should remain, got %+v / %+v", overflow, recent)
+		}
 	})
 
 	t.Run("Different users have separate histories", func(t *testing.T) {
@@ -107,18 +142,6 @@ func TestInMemoryHistoryManager(t *testing.T) {
 		}
 	})
 
-	t.Run("GetBotConversationCount returns 0 for in-memory", func(t *testing.T) {
-		mgr, _ := NewInMemoryHistoryManager(10)
-		mgr.Add("user1", "thread1", "msg", "resp")
-		count, err := mgr.GetBotConversationCount("thread1", "user1")
-		if err != nil {
-			t.Fatalf("GetBotConversationCount failed: %v", err)
-		}
-		if count != 0 {
-			t.Errorf("Expected 0, got %d", count)
-		}
-	})
-
 	t.Run("Close returns nil", func(t *testing.T) {
 		mgr, _ := NewInMemoryHistoryManager(10)
 		err := mgr.Close()
@@ -0,0 +1,186 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UserMemory はユーザーについて長期的に覚えておく事実の1件です。
+type UserMemory struct {
+	ID        int64
+	Fact      string
+	CreatedAt time.Time
+}
+
+// MemoryStore はユーザーごとの長期的な記憶と、記憶を使うかどうかの設定を保存します。
+type MemoryStore interface {
+	// ListMemories は userID の記憶を古い順に返します。
+	ListMemories(userID string) ([]UserMemory, error)
+	// AddMemories は facts を userID の記憶に追加します。同じ内容の記憶が既にあるものは追加しません。
+	AddMemories(userID string, facts ...string) error
+	// ForgetMemories は userID の記憶のうち ids のものを削除します。ids が空の場合は全て削除します。
+	ForgetMemories(userID string, ids ...int64) error
+	// MemoryEnabled は userID が記憶を使う設定かを返します。/memory off で無効にしていなければ true です。
+	MemoryEnabled(userID string) (bool, error)
+	SetMemoryEnabled(userID string, enabled bool) error
+}
+
+const createMemoryTablesSQL = `
+	CREATE SEQUENCE IF NOT EXISTS user_memories_id_seq;
+	CREATE TABLE IF NOT EXISTS user_memories (
+		id BIGINT PRIMARY KEY DEFAULT nextval('user_memories_id_seq'),
+		user_id VARCHAR NOT NULL,
+		fact TEXT NOT NULL,
+		created_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS user_memory_settings (
+		user_id VARCHAR PRIMARY KEY,
+		disabled BOOLEAN NOT NULL
+	);`
+
+var (
+	_ MemoryStore = (*DuckDBHistoryManager)(nil)
+	_ MemoryStore = (*InMemoryHistoryManager)(nil)
+)
+
+func (m *DuckDBHistoryManager) ListMemories(userID string) ([]UserMemory, error) {
+	rows, err := m.db.Query("SELECT id, fact, created_at FROM user_memories WHERE user_id = ? ORDER BY created_at, id;", userID)
+	if err != nil {
+		return nil, fmt.Errorf("記憶のクエリ実行に失敗しました: %w", err)
+	}
+	defer rows.Close()
+	var memories []UserMemory
+	for rows.Next() {
+		var memory UserMemory
+		if err := rows.Scan(&memory.ID, &memory.Fact, &memory.CreatedAt); err != nil {
+			return nil, fmt.Errorf("記憶の読み込みに失敗しました: %w", err)
+		}
+		memories = append(memories, memory)
+	}
+	return memories, rows.Err()
+}
+
+func (m *DuckDBHistoryManager) AddMemories(userID string, facts ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	existing, err := m.ListMemories(userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, fact := range newFacts(existing, facts) {
+		if _, err := m.db.Exec("INSERT INTO user_memories (user_id, fact, created_at) VALUES (?, ?, ?);", userID, fact, now); err != nil {
+			return fmt.Errorf("記憶の保存に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *DuckDBHistoryManager) ForgetMemories(userID string, ids ...int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(ids) == 0 {
+		if _, err := m.db.Exec("DELETE FROM user_memories WHERE user_id = ?;", userID); err != nil {
+			return fmt.Errorf("記憶の削除に失敗しました: %w", err)
+		}
+		return nil
+	}
+	for _, id := range ids {
+		if _, err := m.db.Exec("DELETE FROM user_memories WHERE user_id = ? AND id = ?;", userID, id); err != nil {
+			return fmt.Errorf("記憶の削除に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *DuckDBHistoryManager) MemoryEnabled(userID string) (bool, error) {
+	var disabled bool
+	err := m.db.QueryRow("SELECT disabled FROM user_memory_settings WHERE user_id = ?;", userID).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("記憶の設定のクエリ実行に失敗しました: %w", err)
+	}
+	return !disabled, nil
+}
+
+func (m *DuckDBHistoryManager) SetMemoryEnabled(userID string, enabled bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	upsertSQL := `
+	INSERT INTO user_memory_settings (user_id, disabled) VALUES (?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET disabled = excluded.disabled;`
+	if _, err := m.db.Exec(upsertSQL, userID, !enabled); err != nil {
+		return fmt.Errorf("記憶の設定の保存に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (m *InMemoryHistoryManager) ListMemories(userID string) ([]UserMemory, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Clone(m.memories[userID]), nil
+}
+
+func (m *InMemoryHistoryManager) AddMemories(userID string, facts ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	for _, fact := range newFacts(m.memories[userID], facts) {
+		m.lastMemoryID++
+		m.memories[userID] = append(m.memories[userID], UserMemory{ID: m.lastMemoryID, Fact: fact, CreatedAt: now})
+	}
+	return nil
+}
+
+func (m *InMemoryHistoryManager) ForgetMemories(userID string, ids ...int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(ids) == 0 {
+		delete(m.memories, userID)
+		return nil
+	}
+	m.memories[userID] = slices.DeleteFunc(m.memories[userID], func(memory UserMemory) bool {
+		return slices.Contains(ids, memory.ID)
+	})
+	return nil
+}
+
+func (m *InMemoryHistoryManager) MemoryEnabled(userID string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return !m.memoryDisabled[userID], nil
+}
+
+func (m *InMemoryHistoryManager) SetMemoryEnabled(userID string, enabled bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if enabled {
+		delete(m.memoryDisabled, userID)
+	} else {
+		m.memoryDisabled[userID] = true
+	}
+	return nil
+}
+
+// newFacts は facts のうち、空でなく existing と重複しないものを前後の空白を除いて返します。
+func newFacts(existing []UserMemory, facts []string) []string {
+	seen := make(map[string]bool, len(existing)+len(facts))
+	for _, memory := range existing {
+		seen[memory.Fact] = true
+	}
+	var added []string
+	for _, fact := range facts {
+		fact = strings.TrimSpace(fact)
+		if fact == "" || seen[fact] {
+			continue
+		}
+		seen[fact] = true
+		added = append(added, fact)
+	}
+	return added
+}
@@ -0,0 +1,68 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	duckdb, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 10)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer duckdb.Close()
+	inMemory, _ := NewInMemoryHistoryManager(10)
+
+	stores := map[string]MemoryStore{"duckdb": duckdb, "in-memory": inMemory.(MemoryStore)}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := store.AddMemories("u1", "名前は太郎", " 猫が好き ", "", "名前は太郎"); err != nil {
+				t.Fatalf("AddMemories failed: %v", err)
+			}
+			if err := store.AddMemories("u1", "猫が好き", "Go を書いている"); err != nil {
+				t.Fatalf("AddMemories (duplicate) failed: %v", err)
+			}
+			store.AddMemories("u2", "名前は花子")
+
+			memories, err := store.ListMemories("u1")
+			if err != nil {
+				t.Fatalf("ListMemories failed: %v", err)
+			}
+			var facts []string
+			for _, memory := range memories {
+				facts = append(facts, memory.Fact)
+			}
+			if len(facts) != 3 || facts[0] != "名前は太郎" || facts[1] != "猫が好き" || facts[2] != "Go を書いている" {
+				t.Fatalf("expected trimmed, deduplicated facts in order, got %q", facts)
+			}
+
+			if err := store.ForgetMemories("u1", memories[1].ID); err != nil {
+				t.Fatalf("ForgetMemories failed: %v", err)
+			}
+			if memories, _ := store.ListMemories("u1"); len(memories) != 2 || memories[1].Fact != "Go を書いている" {
+				t.Errorf("expected the selected memory to be forgotten, got %+v", memories)
+			}
+			if err := store.ForgetMemories("u1"); err != nil {
+				t.Fatalf("ForgetMemories (all) failed: %v", err)
+			}
+			if memories, _ := store.ListMemories("u1"); len(memories) != 0 {
+				t.Errorf("expected all memories to be forgotten, got %+v", memories)
+			}
+			if memories, _ := store.ListMemories("u2"); len(memories) != 1 {
+				t.Errorf("other users' memories should be kept, got %+v", memories)
+			}
+
+			if enabled, err := store.MemoryEnabled("u1"); err != nil || !enabled {
+				t.Fatalf("memory should be enabled by default, got %v err=%v", enabled, err)
+			}
+			store.SetMemoryEnabled("u1", false)
+			if enabled, _ := store.MemoryEnabled("u1"); enabled {
+				t.Errorf("memory should be disabled")
+			}
+			store.SetMemoryEnabled("u1", true)
+			if enabled, _ := store.MemoryEnabled("u1"); !enabled {
+				t.Errorf("memory should be enabled again")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ペルソナを選んだ範囲。PersonaStore の scope に使用します。
+const (
+	PersonaScopeUser    = "user"    // ユーザー自身が /persona set で選んだペルソナ (全てのチャンネルで有効)
+	PersonaScopeChannel = "channel" // チャンネルの管理者が /persona channel で設定した既定のペルソナ
+)
+
+// PersonaStore はユーザーとチャンネルごとに選ばれたペルソナのIDを保存します。
+type PersonaStore interface {
+	// GetPersona は scope の targetID (ユーザーIDまたはチャンネルID) に選ばれているペルソナを返します。無い場合は ok が false です。
+	GetPersona(scope, targetID string) (persona string, ok bool, err error)
+	// SetPersona は scope の targetID にペルソナを設定します。persona が空の場合は設定を取り消します。
+	SetPersona(scope, targetID, persona string) error
+}
+
+const createPersonaTableSQL = `
+	CREATE TABLE IF NOT EXISTS persona_selections (
+		scope VARCHAR NOT NULL,
+		target_id VARCHAR NOT NULL,
+		persona VARCHAR NOT NULL,
+		updated_at TIMESTAMP,
+		PRIMARY KEY (scope, target_id)
+	);`
+
+var (
+	_ PersonaStore = (*DuckDBHistoryManager)(nil)
+	_ PersonaStore = (*InMemoryHistoryManager)(nil)
+)
+
+func (m *DuckDBHistoryManager) GetPersona(scope, targetID string) (string, bool, error) {
+	var persona string
+	err := m.db.QueryRow("SELECT persona FROM persona_selections WHERE scope = ? AND target_id = ?;", scope, targetID).Scan(&persona)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("ペルソナのクエリ実行に失敗しました: %w", err)
+	}
+	return persona, true, nil
+}
+
+func (m *DuckDBHistoryManager) SetPersona(scope, targetID, persona string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if persona == "" {
+		if _, err := m.db.Exec("DELETE FROM persona_selections WHERE scope = ? AND target_id = ?;", scope, targetID); err != nil {
+			return fmt.Errorf("ペルソナの設定の削除に失敗しました: %w", err)
+		}
+		return nil
+	}
+	upsertSQL := `
+	INSERT INTO persona_selections (scope, target_id, persona, updated_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT (scope, target_id) DO UPDATE SET persona = excluded.persona, updated_at = excluded.updated_at;`
+	if _, err := m.db.Exec(upsertSQL, scope, targetID, persona, time.Now()); err != nil {
+		return fmt.Errorf("ペルソナの保存に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func (m *InMemoryHistoryManager) GetPersona(scope, targetID string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	persona, ok := m.personas[scope+"/"+targetID]
+	return persona, ok, nil
+}
+
+func (m *InMemoryHistoryManager) SetPersona(scope, targetID, persona string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if persona == "" {
+		delete(m.personas, scope+"/"+targetID)
+		return nil
+	}
+	m.personas[scope+"/"+targetID] = persona
+	return nil
+}
@@ -0,0 +1,46 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPersonaStore(t *testing.T) {
+	duckdb, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 10)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer duckdb.Close()
+	inMemory, _ := NewInMemoryHistoryManager(10)
+
+	stores := map[string]PersonaStore{"duckdb": duckdb, "in-memory": inMemory.(PersonaStore)}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, ok, err := store.GetPersona(PersonaScopeUser, "u1"); err != nil || ok {
+				t.Fatalf("expected no persona, got ok=%v err=%v", ok, err)
+			}
+			if err := store.SetPersona(PersonaScopeUser, "u1", "otaku"); err != nil {
+				t.Fatalf("SetPersona failed: %v", err)
+			}
+			if err := store.SetPersona(PersonaScopeUser, "u1", "butler"); err != nil {
+				t.Fatalf("SetPersona (update) failed: %v", err)
+			}
+			if err := store.SetPersona(PersonaScopeChannel, "u1", "otaku"); err != nil {
+				t.Fatalf("SetPersona (channel) failed: %v", err)
+			}
+			if persona, ok, err := store.GetPersona(PersonaScopeUser, "u1"); err != nil || !ok || persona != "butler" {
+				t.Errorf("expected butler, got %q ok=%v err=%v", persona, ok, err)
+			}
+			if persona, _, _ := store.GetPersona(PersonaScopeChannel, "u1"); persona != "otaku" {
+				t.Errorf("scopes should be independent, got %q", persona)
+			}
+
+			if err := store.SetPersona(PersonaScopeUser, "u1", ""); err != nil {
+				t.Fatalf("SetPersona (clear) failed: %v", err)
+			}
+			if _, ok, _ := store.GetPersona(PersonaScopeUser, "u1"); ok {
+				t.Errorf("persona should be cleared")
+			}
+		})
+	}
+}
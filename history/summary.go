@@ -0,0 +1,90 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Summary はプロンプトに収まらなくなった古い会話を要約したものです。
+type Summary struct {
+	Text string
+	// CoveredUntil は要約に含めた最後のメッセージの CreatedAt です。これ以前のメッセージは要約済みです。
+	CoveredUntil time.Time
+	UpdatedAt    time.Time
+}
+
+// Covers は msg がこの要約に含まれているかを返します。CreatedAt が無い古い履歴は、要約があれば含まれているものとします。
+func (s Summary) Covers(msg HistoryMessage) bool {
+	return !s.UpdatedAt.IsZero() && !msg.CreatedAt.After(s.CoveredUntil)
+}
+
+// SummaryStore はユーザーとスレッドごとの会話の要約を保存します。
+type SummaryStore interface {
+	// GetSummary は要約を返します。まだ要約が無い場合は ok が false です。
+	GetSummary(userID, threadID string) (summary Summary, ok bool, err error)
+	SaveSummary(userID, threadID string, summary Summary) error
+	// GetWithOverflow は Get と同じ最新 max_history_size ペア (recent) と、それより古くまだ要約されていない履歴 (overflow) を返します。
+	// 要約に含まれた古い履歴は、次に履歴を追加する際に削除されます。
+	GetWithOverflow(userID, threadID string) (recent, overflow []HistoryMessage, err error)
+}
+
+const createSummaryTableSQL = `
+	CREATE TABLE IF NOT EXISTS thread_summaries (
+		thread_id VARCHAR NOT NULL,
+		user_id VARCHAR NOT NULL,
+		summary TEXT,
+		covered_until TIMESTAMP,
+		updated_at TIMESTAMP,
+		PRIMARY KEY (thread_id, user_id)
+	);`
+
+var (
+	_ SummaryStore = (*DuckDBHistoryManager)(nil)
+	_ SummaryStore = (*InMemoryHistoryManager)(nil)
+)
+
+func (m *DuckDBHistoryManager) GetSummary(userID, threadID string) (Summary, bool, error) {
+	var summary Summary
+	querySQL := "SELECT summary, covered_until, updated_at FROM thread_summaries WHERE user_id = ? AND thread_id = ?;"
+	err := m.db.QueryRow(querySQL, userID, threadID).Scan(&summary.Text, &summary.CoveredUntil, &summary.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Summary{}, false, nil
+	}
+	if err != nil {
+		return Summary{}, false, fmt.Errorf("要約のクエリ実行に失敗しました: %w", err)
+	}
+	return summary, true, nil
+}
+
+func (m *DuckDBHistoryManager) SaveSummary(userID, threadID string, summary Summary) error {
+	upsertSQL := `
+	INSERT INTO thread_summaries (thread_id, user_id, summary, covered_until, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (thread_id, user_id) DO UPDATE SET
+		summary = excluded.summary,
+		covered_until = excluded.covered_until,
+		updated_at = excluded.updated_at;`
+	if _, err := m.db.Exec(upsertSQL, threadID, userID, summary.Text, summary.CoveredUntil, summary.UpdatedAt); err != nil {
+		return fmt.Errorf("要約の保存に失敗しました: %w", err)
+	}
+	return nil
+}
+
+func summaryKey(userID, threadID string) string {
+	return userID + "/" + threadID
+}
+
+func (m *InMemoryHistoryManager) GetSummary(userID, threadID string) (Summary, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	summary, ok := m.summaries[summaryKey(userID, threadID)]
+	return summary, ok, nil
+}
+
+func (m *InMemoryHistoryManager) SaveSummary(userID, threadID string, summary Summary) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.summaries[summaryKey(userID, threadID)] = summary
+	return nil
+}
@@ -0,0 +1,51 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// URLCache は取得したWebページの本文をURLごとに保存します。
+type URLCache interface {
+	// GetURLContent は maxAge 以内に保存された url の本文を返します。無い場合は ok が false です。
+	GetURLContent(url string, maxAge time.Duration) (content string, ok bool, err error)
+	PutURLContent(url, content string) error
+}
+
+const createURLCacheTableSQL = `
+	CREATE TABLE IF NOT EXISTS url_cache (
+		url VARCHAR PRIMARY KEY,
+		content TEXT,
+		fetched_at TIMESTAMP
+	);`
+
+var _ URLCache = (*DuckDBHistoryManager)(nil)
+
+func (m *DuckDBHistoryManager) GetURLContent(url string, maxAge time.Duration) (string, bool, error) {
+	var content string
+	var fetchedAt time.Time
+	err := m.db.QueryRow("SELECT content, fetched_at FROM url_cache WHERE url = ?;", url).Scan(&content, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("URLキャッシュのクエリ実行に失敗しました: %w", err)
+	}
+	if time.Since(fetchedAt) > maxAge {
+		return "", false, nil
+	}
+	return content, true, nil
+}
+
+func (m *DuckDBHistoryManager) PutURLContent(url, content string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	upsertSQL := `
+	INSERT INTO url_cache (url, content, fetched_at) VALUES (?, ?, ?)
+	ON CONFLICT (url) DO UPDATE SET content = excluded.content, fetched_at = excluded.fetched_at;`
+	if _, err := m.db.Exec(upsertSQL, url, content, time.Now()); err != nil {
+		return fmt.Errorf("URLキャッシュの保存に失敗しました: %w", err)
+	}
+	return nil
+}
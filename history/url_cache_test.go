@@ -0,0 +1,32 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuckDBURLCache(t *testing.T) {
+	m, err := newDuckDBHistoryManager(filepath.Join(t.TempDir(), "test.duckdb"), 10)
+	if err != nil {
+		t.Fatalf("newDuckDBHistoryManager failed: %v", err)
+	}
+	defer m.Close()
+
+	if _, ok, err := m.GetURLContent("https://example.com/", time.Hour); err != nil || ok {
+		t.Fatalf("expected cache miss, got ok=%v err=%v", ok, err)
+	}
+	if err := m.PutURLContent("https://example.com/", "old"); err != nil {
+		t.Fatalf("PutURLContent failed: %v", err)
+	}
+	if err := m.PutURLContent("https://example.com/", "new"); err != nil {
+		t.Fatalf("PutURLContent (update) failed: %v", err)
+	}
+	content, ok, err := m.GetURLContent("https://example.com/", time.Hour)
+	if err != nil || !ok || content != "new" {
+		t.Errorf("expected updated content, got %q ok=%v err=%v", content, ok, err)
+	}
+	if _, ok, _ := m.GetURLContent("https://example.com/", 0); ok {
+		t.Errorf("expired content should not be returned")
+	}
+}
@@ -3,25 +3,64 @@ package loader
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// プロバイダ名。設定ファイルでLLMを明示的に指定する際に使用します。
+const (
+	ProviderGemini    = "gemini"
+	ProviderOllama    = "ollama"
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
 )
 
 type ModelConfig struct {
-	Name               string            `json:"name"`
-	ModelName          string            `json:"model_name"`
-	SecondaryModelName string            `json:"secondary_model_name,omitempty"`
-	Icon               string            `json:"icon"`
-	MaxHistorySize     int               `json:"max_history_size"`
-	Prompts            map[string]string `json:"prompts"`
-	About         About             `json:"about"`
-	Ollama        OllamaConfig      `json:"ollama"`
-	OpenAI        OpenAIConfig      `json:"openai"`
+	Name               string                   `json:"name"`
+	ModelName          string                   `json:"model_name"`
+	SecondaryModelName string                   `json:"secondary_model_name,omitempty"`
+	Icon               string                   `json:"icon"`
+	MaxHistorySize     int                      `json:"max_history_size"`
+	Prompts            map[string]string        `json:"prompts"`
+	About              About                    `json:"about"`
+	Ollama             OllamaConfig             `json:"ollama"`
+	OpenAI             OpenAIConfig             `json:"openai"`
+	Anthropic          AnthropicConfig          `json:"anthropic"`
+	BotPolicy          BotPolicyConfig          `json:"bot_policy"`
+	Reasoning          ReasoningConfig          `json:"reasoning"`
+	Timeouts           TimeoutConfig            `json:"timeouts"`
+	BlockFallback      BlockFallbackConfig      `json:"block_fallback"`
+	GeminiTools        GeminiToolsConfig        `json:"gemini_tools"`
+	WebFetch           WebFetchConfig           `json:"web_fetch"`
+	Context            ContextConfig            `json:"context"`
+	Summary            SummaryConfig            `json:"summary"`
+	SharedThreads      SharedThreadsConfig      `json:"shared_threads"`
+	ReplyChain         ReplyChainConfig         `json:"reply_chain"`
+	Mention            MentionConfig            `json:"mention"`
+	Personas           map[string]PersonaConfig `json:"personas,omitempty"` // キーはペルソナのID (/persona で選ぶ値)
+	PersonaWebhooks    PersonaWebhooksConfig    `json:"persona_webhooks"`
+	Memory             MemoryConfig             `json:"memory"`
+	PromptInjection    PromptInjectionConfig    `json:"prompt_injection"`
+	CustomPrompts      CustomPromptsConfig      `json:"custom_prompts"`
+	TimeZone           string                   `json:"time_zone,omitempty"` // プロンプトの日付に使うタイムゾーン (IANA 名)。既定は DefaultTimeZone
+
+	location         *time.Location             // LoadModelConfig で TimeZone から解決したタイムゾーン
+	promptTemplates  map[string]*PromptTemplate // LoadModelConfig で解析した Prompts。キーは Prompts と同じ
+	personaTemplates map[string]*PromptTemplate // LoadModelConfig で解析した Personas の Prompt。キーはペルソナのID
 }
 
 type OllamaConfig struct {
-	Enabled     bool   `json:"enabled"`
-	APIEndpoint string `json:"api_endpoint"`
-	ModelName   string `json:"model_name"`
+	Enabled         bool   `json:"enabled"`
+	APIEndpoint     string `json:"api_endpoint"`
+	ModelName       string `json:"model_name"`
+	WarmUpOnStartup bool   `json:"warm_up_on_startup,omitempty"` // 起動時に ModelName をメモリにロードする
+	KeepAlive       string `json:"keep_alive,omitempty"`         // ウォームアップ時に指定する keep_alive (例: "30m")
 }
 
 type OpenAIConfig struct {
@@ -31,6 +70,366 @@ type OpenAIConfig struct {
 	APIKey      string `json:"api_key,omitempty"`
 }
 
+// AnthropicConfig は Anthropic Messages API の設定を表します。APIキーは環境変数 ANTHROPIC_API_KEY から読み込みます。
+type AnthropicConfig struct {
+	Enabled     bool   `json:"enabled"`
+	APIEndpoint string `json:"api_endpoint,omitempty"` // 省略時は DefaultAnthropicEndpoint
+	ModelName   string `json:"model_name"`
+	MaxTokens   int    `json:"max_tokens,omitempty"` // 省略時は DefaultAnthropicMaxTokens
+}
+
+const (
+	DefaultAnthropicEndpoint  = "https://api.anthropic.com/v1/messages"
+	DefaultAnthropicMaxTokens = 4096
+)
+
+// BotPolicyConfig は他のBotとの会話に関するポリシーを表します。
+// 未設定の項目は DefaultBotMaxTurns などの既定値で補われます。
+type BotPolicyConfig struct {
+	Disabled          bool     `json:"disabled,omitempty"`            // true の場合、Botからのメッセージには一切応答しない
+	AllowedBotIDs     []string `json:"allowed_bot_ids,omitempty"`     // 空の場合は全てのBotを許可
+	MaxTurns          int      `json:"max_turns,omitempty"`           // ウィンドウ内で応答する最大回数
+	WindowSeconds     int      `json:"window_seconds,omitempty"`      // 回数カウントをリセットするまでの秒数
+	CooldownSeconds   int      `json:"cooldown_seconds,omitempty"`    // 同じBotへの応答間隔の最小秒数
+	Provider          string   `json:"provider,omitempty"`            // "gemini" / "ollama" / "openai" / "anthropic"。空の場合は通常の選択に従う
+	ModelName         string   `json:"model_name,omitempty"`          // Provider で使用するモデル名の上書き
+	AllowedChannelIDs []string `json:"allowed_channel_ids,omitempty"` // 空の場合は全てのチャンネルを許可
+	DeniedChannelIDs  []string `json:"denied_channel_ids,omitempty"`
+}
+
+const (
+	DefaultBotMaxTurns      = 3
+	DefaultBotWindowSeconds = 600
+)
+
+// ReasoningConfig は推論モデルが出力する思考過程の扱いを表します。
+type ReasoningConfig struct {
+	Display string `json:"display,omitempty"` // "hidden" (既定) / "spoiler" / "embed"
+}
+
+const (
+	ReasoningDisplayHidden  = "hidden"
+	ReasoningDisplaySpoiler = "spoiler"
+	ReasoningDisplayEmbed   = "embed"
+)
+
+// TimeoutConfig は生成全体にかける期限を秒単位で表します。
+// ProviderSeconds はLLMへの1回の問い合わせごと、CommandSeconds は /chat・DM・返信・メンションなどの1回の応答ごとに適用されます。
+type TimeoutConfig struct {
+	ProviderSeconds map[string]int `json:"provider_seconds,omitempty"` // キーはプロバイダ名 ("gemini" など)。未設定は DefaultProviderTimeoutSeconds
+	CommandSeconds  map[string]int `json:"command_seconds,omitempty"`  // キーは "chat" / "dm" / "reply" / "mention" / "ollama"。未設定は期限なし (プロバイダの期限のみ)。"ollama" は最大14分
+}
+
+const DefaultProviderTimeoutSeconds = 120
+
+// Provider は provider への問い合わせの期限を返します。
+func (t TimeoutConfig) Provider(provider string) time.Duration {
+	if seconds := t.ProviderSeconds[provider]; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return DefaultProviderTimeoutSeconds * time.Second
+}
+
+// Command は command の応答全体の期限を返します。期限が無い場合は 0 です。
+func (t TimeoutConfig) Command(command string) time.Duration {
+	if seconds := t.CommandSeconds[command]; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// BlockFallbackConfig は Gemini が応答をブロックした理由ごとに、自動で再試行するモデルを指定します。
+// 空の場合は再試行せず、ブロックされた理由をユーザーに伝えます。
+type BlockFallbackConfig struct {
+	Safety     string `json:"safety,omitempty"`     // FinishReasonSafety (安全性フィルタ) で停止した場合
+	Recitation string `json:"recitation,omitempty"` // FinishReasonRecitation (既存コンテンツの引用) で停止した場合
+	Prompt     string `json:"prompt,omitempty"`     // PromptFeedback.BlockReason (入力のブロック) の場合
+}
+
+// 応答がブロックされた理由の種類。BlockFallbackConfig.Model の引数に使用します。
+const (
+	BlockKindSafety     = "safety"
+	BlockKindRecitation = "recitation"
+	BlockKindPrompt     = "prompt"
+)
+
+// Model は kind のブロック時に再試行するモデル名を返します。設定が無い場合は空文字列です。
+func (b BlockFallbackConfig) Model(kind string) string {
+	switch kind {
+	case BlockKindSafety:
+		return b.Safety
+	case BlockKindRecitation:
+		return b.Recitation
+	case BlockKindPrompt:
+		return b.Prompt
+	}
+	return ""
+}
+
+// GeminiToolsConfig は Gemini の組み込みツールを有効にするチャンネルを表します。
+// スレッドでは、スレッド自身と親チャンネルのどちらかが含まれていれば有効です。
+type GeminiToolsConfig struct {
+	GroundingChannelIDs     []string `json:"grounding_channel_ids,omitempty"`      // Google 検索によるグラウンディングを使うチャンネル
+	CodeExecutionChannelIDs []string `json:"code_execution_channel_ids,omitempty"` // サーバー側での Python 実行 (コード実行ツール) を使うチャンネル
+}
+
+// GroundingEnabled は channelIDs (スレッドとその親チャンネルなど) のいずれかで Google 検索によるグラウンディングが有効かを返します。
+func (g GeminiToolsConfig) GroundingEnabled(channelIDs ...string) bool {
+	return containsChannel(g.GroundingChannelIDs, channelIDs)
+}
+
+// containsChannel は channelIDs の空でないいずれかが configured に含まれているかを返します。
+func containsChannel(configured, channelIDs []string) bool {
+	for _, id := range channelIDs {
+		if id != "" && slices.Contains(configured, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// CodeExecutionEnabled は channelIDs (スレッドとその親チャンネルなど) のいずれかでコード実行ツールが有効かを返します。
+func (g GeminiToolsConfig) CodeExecutionEnabled(channelIDs ...string) bool {
+	return containsChannel(g.CodeExecutionChannelIDs, channelIDs)
+}
+
+// WebFetchConfig はLLMがWebページを読むツール (fetch_url) の設定です。0 の項目は既定値を使います。
+type WebFetchConfig struct {
+	Enabled         bool `json:"enabled"`
+	MaxBytes        int  `json:"max_bytes,omitempty"`         // 読み込む本文の上限 (バイト)。既定は DefaultWebFetchMaxBytes
+	TimeoutSeconds  int  `json:"timeout_seconds,omitempty"`   // リダイレクトを含めた取得全体の期限。既定は DefaultWebFetchTimeoutSeconds
+	MaxRedirects    int  `json:"max_redirects,omitempty"`     // 追従するリダイレクトの回数。既定は DefaultWebFetchMaxRedirects
+	CacheTTLMinutes int  `json:"cache_ttl_minutes,omitempty"` // 取得結果をキャッシュする時間。既定は DefaultWebFetchCacheTTLMinutes
+}
+
+const (
+	DefaultWebFetchMaxBytes        = 2 << 20
+	DefaultWebFetchTimeoutSeconds  = 15
+	DefaultWebFetchMaxRedirects    = 5
+	DefaultWebFetchCacheTTLMinutes = 60
+)
+
+// Limits は本文の上限、取得の期限、リダイレクトの回数を既定値で補って返します。
+func (w WebFetchConfig) Limits() (maxBytes int64, timeout time.Duration, maxRedirects int) {
+	maxBytes, timeout, maxRedirects = DefaultWebFetchMaxBytes, DefaultWebFetchTimeoutSeconds*time.Second, DefaultWebFetchMaxRedirects
+	if w.MaxBytes > 0 {
+		maxBytes = int64(w.MaxBytes)
+	}
+	if w.TimeoutSeconds > 0 {
+		timeout = time.Duration(w.TimeoutSeconds) * time.Second
+	}
+	if w.MaxRedirects > 0 {
+		maxRedirects = w.MaxRedirects
+	}
+	return maxBytes, timeout, maxRedirects
+}
+
+// CacheTTL は取得結果をキャッシュする時間を返します。
+func (w WebFetchConfig) CacheTTL() time.Duration {
+	if w.CacheTTLMinutes > 0 {
+		return time.Duration(w.CacheTTLMinutes) * time.Minute
+	}
+	return DefaultWebFetchCacheTTLMinutes * time.Minute
+}
+
+// ContextConfig はLLMに渡すプロンプト全体 (システムプロンプト・会話履歴・今回のメッセージ) のトークン数の上限です。
+// 上限を超える場合は古い会話履歴から削ります。
+type ContextConfig struct {
+	MaxTokens map[string]int `json:"max_tokens,omitempty"` // キーはモデル名、またはプロバイダ名 ("ollama" など)。モデル名の指定が優先
+}
+
+// DefaultContextTokens はプロバイダごとの既定の上限です。応答の生成に使う分を残した値にしています。
+var DefaultContextTokens = map[string]int{
+	ProviderGemini:    100000,
+	ProviderOpenAI:    32000,
+	ProviderAnthropic: 100000,
+	ProviderOllama:    3000,
+}
+
+// Budget は provider の modelName に渡すプロンプトのトークン数の上限を返します。
+func (c ContextConfig) Budget(provider, modelName string) int {
+	if tokens := c.MaxTokens[modelName]; tokens > 0 {
+		return tokens
+	}
+	if tokens := c.MaxTokens[provider]; tokens > 0 {
+		return tokens
+	}
+	return DefaultContextTokens[provider]
+}
+
+// SummaryConfig は、プロンプトに収まらなくなった古い会話を要約して残す設定です。
+type SummaryConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Provider  string `json:"provider,omitempty"`   // 要約に使うプロバイダ。空の場合は応答と同じプロバイダ
+	ModelName string `json:"model_name,omitempty"` // 要約に使うモデル。空の場合はプロバイダの設定のモデル
+	MaxChars  int    `json:"max_chars,omitempty"`  // 要約の最大文字数。既定は DefaultSummaryMaxChars
+}
+
+const DefaultSummaryMaxChars = 1500
+
+// Limit は要約の最大文字数を返します。
+func (s SummaryConfig) Limit() int {
+	if s.MaxChars > 0 {
+		return s.MaxChars
+	}
+	return DefaultSummaryMaxChars
+}
+
+// SharedThreadsConfig は、参加者ごとではなくスレッド全体で1つの会話履歴を共有するチャンネルを表します。
+type SharedThreadsConfig struct {
+	ChannelIDs []string `json:"channel_ids,omitempty"` // 対象のチャンネル。親チャンネルを指定すると、その中のスレッドも対象になる
+}
+
+// Enabled は channelIDs (スレッドとその親チャンネルなど) のいずれかで履歴の共有が有効かを返します。
+func (s SharedThreadsConfig) Enabled(channelIDs ...string) bool {
+	return containsChannel(s.ChannelIDs, channelIDs)
+}
+
+// ReplyChainConfig は、Botへの返信に応答する際に Discord 上の返信の連鎖をたどって会話を組み立てる設定です。
+// 0 の項目は既定値を使います。
+type ReplyChainConfig struct {
+	Disabled  bool `json:"disabled,omitempty"`   // true の場合は連鎖をたどらず、保存されている履歴を使う
+	MaxDepth  int  `json:"max_depth,omitempty"`  // たどるメッセージの最大数。既定は DefaultReplyChainMaxDepth
+	MaxTokens int  `json:"max_tokens,omitempty"` // たどったメッセージの合計トークン数 (見積もり) の上限。既定は DefaultReplyChainMaxTokens
+}
+
+const (
+	DefaultReplyChainMaxDepth  = 20
+	DefaultReplyChainMaxTokens = 8000
+)
+
+// Limits はたどるメッセージの最大数とトークン数の上限を、既定値で補って返します。
+func (r ReplyChainConfig) Limits() (maxDepth, maxTokens int) {
+	maxDepth, maxTokens = DefaultReplyChainMaxDepth, DefaultReplyChainMaxTokens
+	if r.MaxDepth > 0 {
+		maxDepth = r.MaxDepth
+	}
+	if r.MaxTokens > 0 {
+		maxTokens = r.MaxTokens
+	}
+	return maxDepth, maxTokens
+}
+
+// MentionConfig はサーバーのチャンネルで Bot がメンションされた場合の応答の設定です。
+type MentionConfig struct {
+	Disabled        bool `json:"disabled,omitempty"`         // true の場合はメンションに応答しない
+	ContextMessages int  `json:"context_messages,omitempty"` // プロンプトに含めるチャンネルの最近のメッセージ数。0 は既定値、負の値は含めない
+}
+
+const (
+	DefaultMentionContextMessages = 20
+	MaxMentionContextMessages     = 100 // Discord の API で一度に取得できる上限
+)
+
+// ContextLimit はプロンプトに含めるチャンネルの最近のメッセージ数を返します。
+func (m MentionConfig) ContextLimit() int {
+	switch {
+	case m.ContextMessages < 0:
+		return 0
+	case m.ContextMessages == 0:
+		return DefaultMentionContextMessages
+	default:
+		return min(m.ContextMessages, MaxMentionContextMessages)
+	}
+}
+
+// PersonaConfig は /persona で選べるペルソナ (Bot のキャラクター) です。
+// 選ばれている間は prompts の代わりに Prompt をシステムプロンプトとして使います。
+type PersonaConfig struct {
+	DisplayName string           `json:"display_name"`          // 応答に表示する名前。空の場合はID
+	Icon        string           `json:"icon,omitempty"`        // 応答に表示するアイコンのURL。空の場合は Bot の icon
+	Description string           `json:"description,omitempty"` // /persona の候補と一覧に表示する説明
+	Prompt      string           `json:"prompt"`                // システムプロンプト。prompts と同じくテンプレートとして解析する
+	Examples    []PersonaExample `json:"examples,omitempty"`    // 口調を示す会話の例 (few-shot)
+	Provider    string           `json:"provider,omitempty"`    // 使用するプロバイダ。空の場合は通常の選択に従う
+	ModelName   string           `json:"model_name,omitempty"`  // Provider で使用するモデル名の上書き
+}
+
+// PersonaWebhooksConfig は、サーバーでのペルソナの応答をチャンネルの Webhook からペルソナの名前とアイコンで送信する設定です。
+// Bot に Webhook の管理権限が無いチャンネルでは、設定に関わらず Bot 自身の返信として送信します。
+type PersonaWebhooksConfig struct {
+	Disabled bool `json:"disabled,omitempty"` // true の場合は常に Bot 自身の返信として送信する
+}
+
+// MemoryConfig は、会話からユーザーについての事実 (名前や好み、取り組んでいることなど) を抽出して長期的に覚えておく設定です。
+// ユーザーは /memory off で自分の記憶を無効にできます。
+type MemoryConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Provider    string `json:"provider,omitempty"`     // 事実の抽出に使うプロバイダ。空の場合は応答と同じプロバイダ
+	ModelName   string `json:"model_name,omitempty"`   // 事実の抽出に使うモデル。空の場合はプロバイダの設定のモデル
+	MaxFacts    int    `json:"max_facts,omitempty"`    // ユーザーごとに覚えておく事実の最大数。超えた分は古いものから忘れる。既定は DefaultMemoryMaxFacts
+	PromptFacts int    `json:"prompt_facts,omitempty"` // プロンプトに含める事実の最大数。既定は DefaultMemoryPromptFacts
+}
+
+const (
+	DefaultMemoryMaxFacts    = 50
+	DefaultMemoryPromptFacts = 10
+)
+
+// Limits は覚えておく事実とプロンプトに含める事実の最大数を、既定値で補って返します。
+func (m MemoryConfig) Limits() (maxFacts, promptFacts int) {
+	maxFacts, promptFacts = DefaultMemoryMaxFacts, DefaultMemoryPromptFacts
+	if m.MaxFacts > 0 {
+		maxFacts = m.MaxFacts
+	}
+	if m.PromptFacts > 0 {
+		promptFacts = m.PromptFacts
+	}
+	return maxFacts, promptFacts
+}
+
+// PromptInjectionConfig は、ユーザーのメッセージに含まれるよくあるプロンプトインジェクションの検出の設定です。
+// 既定のパターン (指示の無視の依頼、システムプロンプトの開示の要求、会話の記録やロールの偽装など) に Patterns を加えて検出します。
+type PromptInjectionConfig struct {
+	Action   string   `json:"action,omitempty"`   // 検出した場合の動作 ("log" / "refuse" / "off")。既定は "log"
+	Patterns []string `json:"patterns,omitempty"` // 追加で検出する正規表現 (Go の regexp の構文)
+	Message  string   `json:"message,omitempty"`  // "refuse" で返すメッセージ。既定は DefaultInjectionRefusal
+
+	compiled []*regexp.Regexp // LoadModelConfig で解析した Patterns
+}
+
+// CustomPromptsConfig は、ユーザーが /edit で設定するカスタムプロンプトを受け付ける前の確認と、管理者による承認の設定です。
+type CustomPromptsConfig struct {
+	ModChannelID string   `json:"mod_channel_id,omitempty"` // 設定すると、変更はこのチャンネルで管理者が承認するまで反映されない
+	Blocklist    []string `json:"blocklist,omitempty"`      // 含まれている場合に受け付けない語句 (大文字・小文字は区別しない)
+	LLMCheck     bool     `json:"llm_check,omitempty"`      // 受け付ける前に LLM で不適切な内容でないかを確認する
+	Provider     string   `json:"provider,omitempty"`       // LLM での確認に使うプロバイダ。空の場合は応答と同じプロバイダ
+	ModelName    string   `json:"model_name,omitempty"`     // LLM での確認に使うモデル。空の場合はプロバイダの設定のモデル
+}
+
+// RequiresApproval はカスタムプロンプトの変更に管理者の承認が必要かを返します。
+func (c CustomPromptsConfig) RequiresApproval() bool {
+	return c.ModChannelID != ""
+}
+
+// Blocked は prompt に Blocklist の語句が含まれているかを判定し、含まれていた語句を返します。
+func (c CustomPromptsConfig) Blocked(prompt string) (term string, ok bool) {
+	lower := strings.ToLower(prompt)
+	for _, term := range c.Blocklist {
+		if term != "" && strings.Contains(lower, strings.ToLower(term)) {
+			return term, true
+		}
+	}
+	return "", false
+}
+
+// PersonaExample はペルソナの会話の例の1往復です。
+type PersonaExample struct {
+	User      string `json:"user"`
+	Assistant string `json:"assistant"`
+}
+
+// MaxPersonaIDLength は Discord の選択肢の値の上限に合わせた、ペルソナのIDの最大文字数です。
+const MaxPersonaIDLength = 100
+
+// Name はペルソナの表示名を返します。display_name が空の場合は id です。
+func (p PersonaConfig) Name(id string) string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return id
+}
+
 type About struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
@@ -49,6 +448,125 @@ func (m *ModelConfig) GetPromptByUser(username string) string {
 	return "You are a helpful assistant."
 }
 
+// promptKey は username に使う Prompts のキーを返します。該当するプロンプトが無い場合は空文字列です。
+func (m *ModelConfig) promptKey(username string) string {
+	if _, exists := m.Prompts[username]; exists {
+		return username
+	}
+	if m.Prompts["default"] != "" {
+		return "default"
+	}
+	return ""
+}
+
+// PromptTemplate は username に使うシステムプロンプトを、テンプレートとして解析したものを返します。
+// LoadModelConfig で解析済みのものがあればそれを使います。
+func (m *ModelConfig) PromptTemplate(username string) (*PromptTemplate, error) {
+	key := m.promptKey(username)
+	if tmpl, ok := m.promptTemplates[key]; ok {
+		return tmpl, nil
+	}
+	return ParsePromptTemplate(key, m.GetPromptByUser(username))
+}
+
+// Persona は id のペルソナを返します。存在しない場合は ok が false です。
+func (m *ModelConfig) Persona(id string) (PersonaConfig, bool) {
+	persona, ok := m.Personas[id]
+	return persona, ok && id != ""
+}
+
+// PersonaIDs は設定されているペルソナのIDを名前順に返します。
+func (m *ModelConfig) PersonaIDs() []string {
+	return slices.Sorted(maps.Keys(m.Personas))
+}
+
+// PersonaTemplate は id のペルソナのシステムプロンプトを、テンプレートとして解析したものを返します。
+func (m *ModelConfig) PersonaTemplate(id string) (*PromptTemplate, error) {
+	if tmpl, ok := m.personaTemplates[id]; ok {
+		return tmpl, nil
+	}
+	persona, ok := m.Persona(id)
+	if !ok {
+		return nil, fmt.Errorf("persona %q not defined", id)
+	}
+	return parsePromptTemplate("personas", id, persona.Prompt)
+}
+
+// Location はプロンプトの日付に使うタイムゾーンを返します。
+func (m *ModelConfig) Location() *time.Location {
+	if m.location != nil {
+		return m.location
+	}
+	if m.TimeZone != "" {
+		if loc, err := time.LoadLocation(m.TimeZone); err == nil {
+			return loc
+		}
+	}
+	loc, _ := time.LoadLocation(DefaultTimeZone)
+	return loc
+}
+
+// ActiveModel は応答の生成に使うプロバイダとモデル名を返します。
+// 複数のプロバイダが有効な場合は Ollama, OpenAI, Anthropic の順で優先し、いずれも無効なら Gemini を使います。
+func (m *ModelConfig) ActiveModel() (provider, modelName string) {
+	switch {
+	case m.Ollama.Enabled:
+		return ProviderOllama, m.Ollama.ModelName
+	case m.OpenAI.Enabled:
+		return ProviderOpenAI, m.OpenAI.ModelName
+	case m.Anthropic.Enabled:
+		return ProviderAnthropic, m.Anthropic.ModelName
+	default:
+		return ProviderGemini, m.ModelName
+	}
+}
+
+// WithProviderOverride は provider と modelName で使用するLLMを上書きした ModelConfig のコピーを返します。
+// provider が空の場合は modelName のみを現在のプロバイダに適用します。
+func (m *ModelConfig) WithProviderOverride(provider, modelName string) (*ModelConfig, error) {
+	overridden := *m
+	if provider == "" {
+		switch {
+		case m.Ollama.Enabled:
+			provider = ProviderOllama
+		case m.OpenAI.Enabled:
+			provider = ProviderOpenAI
+		case m.Anthropic.Enabled:
+			provider = ProviderAnthropic
+		default:
+			provider = ProviderGemini
+		}
+	}
+
+	overridden.Ollama.Enabled = false
+	overridden.OpenAI.Enabled = false
+	overridden.Anthropic.Enabled = false
+	switch provider {
+	case ProviderGemini:
+		if modelName != "" {
+			overridden.ModelName = modelName
+		}
+	case ProviderOllama:
+		overridden.Ollama.Enabled = true
+		if modelName != "" {
+			overridden.Ollama.ModelName = modelName
+		}
+	case ProviderOpenAI:
+		overridden.OpenAI.Enabled = true
+		if modelName != "" {
+			overridden.OpenAI.ModelName = modelName
+		}
+	case ProviderAnthropic:
+		overridden.Anthropic.Enabled = true
+		if modelName != "" {
+			overridden.Anthropic.ModelName = modelName
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+	return &overridden, nil
+}
+
 func LoadModelConfig(filepath string) (*ModelConfig, error) {
 	file, err := os.ReadFile(filepath)
 	if err != nil {
@@ -64,5 +582,73 @@ func LoadModelConfig(filepath string) (*ModelConfig, error) {
 		return nil, errors.New("default prompt not defined")
 	}
 
+	cfg.promptTemplates = make(map[string]*PromptTemplate, len(cfg.Prompts))
+	for _, key := range slices.Sorted(maps.Keys(cfg.Prompts)) {
+		tmpl, err := ParsePromptTemplate(key, cfg.Prompts[key])
+		if err != nil {
+			return nil, err
+		}
+		cfg.promptTemplates[key] = tmpl
+	}
+
+	cfg.personaTemplates = make(map[string]*PromptTemplate, len(cfg.Personas))
+	for _, id := range cfg.PersonaIDs() {
+		persona := cfg.Personas[id]
+		if id == "" || utf8.RuneCountInString(id) > MaxPersonaIDLength {
+			return nil, fmt.Errorf("personas: id %q must be 1 to %d characters", id, MaxPersonaIDLength)
+		}
+		if persona.Prompt == "" {
+			return nil, fmt.Errorf("personas.%s: prompt not defined", id)
+		}
+		tmpl, err := parsePromptTemplate("personas", id, persona.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		cfg.personaTemplates[id] = tmpl
+		if persona.Provider != "" || persona.ModelName != "" {
+			if _, err := cfg.WithProviderOverride(persona.Provider, persona.ModelName); err != nil {
+				return nil, fmt.Errorf("personas.%s: %w", id, err)
+			}
+		}
+	}
+
+	timeZone := cfg.TimeZone
+	if timeZone == "" {
+		timeZone = DefaultTimeZone
+	}
+	if cfg.location, err = time.LoadLocation(timeZone); err != nil {
+		return nil, fmt.Errorf("time_zone: %w", err)
+	}
+
+	switch cfg.Reasoning.Display {
+	case "", ReasoningDisplayHidden, ReasoningDisplaySpoiler, ReasoningDisplayEmbed:
+	default:
+		return nil, fmt.Errorf("reasoning.display: unknown value %q", cfg.Reasoning.Display)
+	}
+
+	if cfg.BotPolicy.Provider != "" {
+		if _, err := cfg.WithProviderOverride(cfg.BotPolicy.Provider, cfg.BotPolicy.ModelName); err != nil {
+			return nil, fmt.Errorf("bot_policy: %w", err)
+		}
+	}
+	if cfg.Summary.Provider != "" {
+		if _, err := cfg.WithProviderOverride(cfg.Summary.Provider, cfg.Summary.ModelName); err != nil {
+			return nil, fmt.Errorf("summary: %w", err)
+		}
+	}
+	if cfg.Memory.Provider != "" {
+		if _, err := cfg.WithProviderOverride(cfg.Memory.Provider, cfg.Memory.ModelName); err != nil {
+			return nil, fmt.Errorf("memory: %w", err)
+		}
+	}
+	if cfg.CustomPrompts.Provider != "" {
+		if _, err := cfg.WithProviderOverride(cfg.CustomPrompts.Provider, cfg.CustomPrompts.ModelName); err != nil {
+			return nil, fmt.Errorf("custom_prompts: %w", err)
+		}
+	}
+	if err := cfg.PromptInjection.compile(); err != nil {
+		return nil, fmt.Errorf("prompt_injection: %w", err)
+	}
+
 	return &cfg, nil
 }
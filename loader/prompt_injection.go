@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// プロンプトインジェクションを検出した場合の動作 (prompt_injection.action)。
+const (
+	InjectionActionLog    = "log"    // ログに記録したうえで応答する (既定)
+	InjectionActionRefuse = "refuse" // ログに記録し、応答を断る
+	InjectionActionOff    = "off"    // 検出しない
+)
+
+// DefaultInjectionRefusal は prompt_injection.message が未設定の場合に、応答を断る際に返すメッセージです。
+const DefaultInjectionRefusal = "このメッセージには指示を書き換えようとする内容が含まれているため、応答できません。"
+
+// defaultInjectionPatterns はよくあるプロンプトインジェクションの書き方です。prompt_injection.patterns はこれに追加されます。
+var defaultInjectionPatterns = []*regexp.Regexp{
+	// これまでの指示の無視・上書き
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|all|system)\b.{0,20}\b(instructions?|prompts?|rules|messages)\b`),
+	regexp.MustCompile(`(これまで|以前|前|上|先)の(指示|命令|プロンプト|設定|ルール)を?(全て|すべて)?(無視|忘れ|破棄|リセット)`),
+	// システムプロンプトの開示の要求
+	regexp.MustCompile(`(?i)\b(reveal|show|print|repeat|output)\b.{0,20}\b(system prompt|initial instructions|hidden instructions)\b`),
+	regexp.MustCompile(`(システムプロンプト|初期設定の指示|隠された指示)を?(教えて|表示|出力|見せ|繰り返)`),
+	// 会話の記録やロールの偽装 ("Chat history:" や行頭の "assistant:" など)
+	regexp.MustCompile(`(?im)^\s*(chat history|user message|system|assistant)\s*:`),
+	regexp.MustCompile(`(?i)<\s*/?\s*(system|message|chat_history|channel_context|memories|summary)\b`),
+}
+
+// compile は Patterns を検証して解析します。
+func (p *PromptInjectionConfig) compile() error {
+	switch p.Action {
+	case "", InjectionActionLog, InjectionActionRefuse, InjectionActionOff:
+	default:
+		return fmt.Errorf("action %q is not one of %q, %q, %q", p.Action, InjectionActionLog, InjectionActionRefuse, InjectionActionOff)
+	}
+	p.compiled = make([]*regexp.Regexp, 0, len(p.Patterns))
+	for i, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("patterns[%d]: %w", i, err)
+		}
+		p.compiled = append(p.compiled, re)
+	}
+	return nil
+}
+
+// Detect は text がプロンプトインジェクションのパターンに一致するかを判定し、一致したパターンを返します。
+// action が "off" の場合は常に一致しません。Patterns は LoadModelConfig で解析したものだけが使われます。
+func (p PromptInjectionConfig) Detect(text string) (pattern string, ok bool) {
+	if p.Action == InjectionActionOff {
+		return "", false
+	}
+	for _, re := range slices.Concat(defaultInjectionPatterns, p.compiled) {
+		if re.MatchString(text) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// Refuses は検出した場合に応答を断る設定かを返します。
+func (p PromptInjectionConfig) Refuses() bool {
+	return p.Action == InjectionActionRefuse
+}
+
+// RefusalMessage は応答を断る際に返すメッセージを返します。
+func (p PromptInjectionConfig) RefusalMessage() string {
+	if p.Message != "" {
+		return p.Message
+	}
+	return DefaultInjectionRefusal
+}
@@ -0,0 +1,220 @@
+package loader
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	_ "time/tzdata" // タイムゾーンのデータベースが無い環境でも time_zone を解決できるように埋め込む
+)
+
+// DefaultTimeZone は time_zone が未設定の場合に、プロンプトの日付に使うタイムゾーンです。
+const DefaultTimeZone = "Asia/Tokyo"
+
+// PromptVars はシステムプロンプトのテンプレートで使える変数です。
+// DM の場合など、取得できない項目は空文字列になります。
+type PromptVars struct {
+	Username    string    // Discord のユーザー名
+	DisplayName string    // サーバーでの表示名 (未設定の場合はユーザー名)
+	GuildName   string    // サーバー名
+	ChannelName string    // チャンネル名 (スレッドの場合は親チャンネルの名前)
+	ThreadTitle string    // スレッドのタイトル (スレッド以外では空)
+	BotName     string    // model.json の name
+	ModelName   string    // 応答に使うモデル名
+	Now         time.Time // 現在時刻 (time_zone のタイムゾーン)
+}
+
+// promptFuncs はテンプレートで使えるヘルパーです。
+var promptFuncs = template.FuncMap{
+	// date は時刻を layout (Go の書式) で整形します。例: {{date .Now "2006年1月2日"}}
+	"date": func(t time.Time, layout string) string { return t.Format(layout) },
+	// default は value が空の場合に fallback を返します。例: {{default "DM" .GuildName}}
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// PromptTemplate は解析済みのシステムプロンプトです。
+type PromptTemplate struct {
+	section  string // プロンプトの出どころ ("prompts" / "personas" / "custom_prompts")。エラーメッセージに使う
+	name     string
+	text     string
+	tmpl     *template.Template
+	usesDate bool
+}
+
+// PromptTemplateError はテンプレートの誤りを、プロンプト内の行と列 (1始まり、文字単位) で表します。
+type PromptTemplateError struct {
+	Section string // "prompts" / "personas" / "custom_prompts"
+	Name    string // Section でのキー
+	Line    int
+	Column  int
+	Msg     string
+}
+
+func (e *PromptTemplateError) Error() string {
+	return fmt.Sprintf("%s.%s: %d行%d列: %s", e.Section, e.Name, e.Line, e.Column, e.Msg)
+}
+
+var (
+	parseErrorPattern = regexp.MustCompile(`^(\d+): (.*)$`)
+	execErrorPattern  = regexp.MustCompile(`^(\d+):(\d+): (.*)$`)
+)
+
+// ParsePromptTemplate は name のプロンプト text を text/template として解析します。
+func ParsePromptTemplate(name, text string) (*PromptTemplate, error) {
+	return parsePromptTemplate("prompts", name, text)
+}
+
+// ParseCustomPromptTemplate はユーザーが /edit で設定したプロンプト text を解析します。
+// エラーは section が "custom_prompts"、Name が userID の PromptTemplateError になります。
+func ParseCustomPromptTemplate(userID, text string) (*PromptTemplate, error) {
+	return parsePromptTemplate("custom_prompts", userID, text)
+}
+
+// parsePromptTemplate は section の name にあるプロンプト text を解析します。
+func parsePromptTemplate(section, name, text string) (*PromptTemplate, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, promptParseError(section, name, text, err)
+	}
+	p := &PromptTemplate{section: section, name: name, text: text, tmpl: tmpl, usesDate: referencesDate(tmpl.Tree.Root)}
+	// 存在しない変数の参照は実行するまで分からないため、仮の値で一度実行しておく
+	if _, err := p.Execute(PromptVars{Now: time.Now()}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Execute は vars を埋め込んだシステムプロンプトを返します。
+func (p *PromptTemplate) Execute(vars PromptVars) (string, error) {
+	var b strings.Builder
+	if err := p.tmpl.Execute(&b, vars); err != nil {
+		return "", promptExecError(p.section, p.name, p.text, err)
+	}
+	return b.String(), nil
+}
+
+// UsesDate はテンプレートが日時 (.Now) を参照しているかを返します。
+// 参照していない場合、呼び出し側はプロンプトの末尾に今日の日付を補います。
+func (p *PromptTemplate) UsesDate() bool {
+	return p.usesDate
+}
+
+// promptParseError は text/template の解析エラーを PromptTemplateError に変換します。
+// 解析エラーには行番号しか含まれないため、その行のアクションを順に解析し直して列を特定します。
+func promptParseError(section, name, text string, err error) error {
+	rest := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	m := parseErrorPattern.FindStringSubmatch(rest)
+	if m == nil {
+		return fmt.Errorf("%s.%s: %w", section, name, err)
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &PromptTemplateError{Section: section, Name: name, Line: line, Column: parseErrorColumn(name, text, line, err.Error()), Msg: m[2]}
+}
+
+// parseErrorColumn は line 行目のアクション ({{ ... }}) のうち、そこまでを解析すると want と同じエラーになる最初のものの列を返します。
+// 見つからない場合は 1 です。
+func parseErrorColumn(name, text string, line int, want string) int {
+	lineStart := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(text[lineStart:], '\n')
+		if next < 0 {
+			return 1
+		}
+		lineStart += next + 1
+	}
+	lineEnd := len(text)
+	if next := strings.IndexByte(text[lineStart:], '\n'); next >= 0 {
+		lineEnd = lineStart + next
+	}
+
+	for offset := lineStart; offset < lineEnd; {
+		open := strings.Index(text[offset:lineEnd], "{{")
+		if open < 0 {
+			break
+		}
+		open += offset
+		end := len(text)
+		if closing := strings.Index(text[open:], "}}"); closing >= 0 {
+			end = open + closing + 2
+		}
+		_, err := template.New(name).Funcs(promptFuncs).Parse(text[:end])
+		if err != nil && err.Error() == want {
+			return utf8.RuneCountInString(text[lineStart:open]) + 1
+		}
+		offset = open + 2
+	}
+	return 1
+}
+
+// promptExecError は text/template の実行エラーを PromptTemplateError に変換します。
+// 実行エラーの列はバイト単位 (0始まり) のため、文字単位に直します。
+func promptExecError(section, name, text string, err error) error {
+	rest := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	m := execErrorPattern.FindStringSubmatch(rest)
+	if m == nil {
+		return fmt.Errorf("%s.%s: %w", section, name, err)
+	}
+	line, _ := strconv.Atoi(m[1])
+	byteColumn, _ := strconv.Atoi(m[2])
+	column := byteColumn + 1
+	if lines := strings.Split(text, "\n"); line >= 1 && line <= len(lines) && byteColumn <= len(lines[line-1]) {
+		column = utf8.RuneCountInString(lines[line-1][:byteColumn]) + 1
+	}
+	return &PromptTemplateError{Section: section, Name: name, Line: line, Column: column, Msg: m[3]}
+}
+
+// referencesDate は node 以下で .Now を参照しているかを返します。
+func referencesDate(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if referencesDate(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return referencesDate(n.Pipe)
+	case *parse.IfNode:
+		return referencesDate(n.Pipe) || referencesDate(n.List) || referencesDate(n.ElseList)
+	case *parse.RangeNode:
+		return referencesDate(n.Pipe) || referencesDate(n.List) || referencesDate(n.ElseList)
+	case *parse.WithNode:
+		return referencesDate(n.Pipe) || referencesDate(n.List) || referencesDate(n.ElseList)
+	case *parse.TemplateNode:
+		return referencesDate(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if referencesDate(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if referencesDate(arg) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == "Now"
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package loader
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptTemplateExecute(t *testing.T) {
+	tmpl, err := ParsePromptTemplate("default", `あなたは{{.BotName}} ({{.ModelName}}) です。
+{{.DisplayName}} さん ({{.Username}}) と {{default "DM" .GuildName}} の #{{.ChannelName}}{{with .ThreadTitle}} (スレッド「{{.}}」){{end}} で話しています。
+今日は {{date .Now "2006年1月2日"}} です。`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !tmpl.UsesDate() {
+		t.Error("template referencing .Now should report UsesDate")
+	}
+
+	jst, _ := time.LoadLocation(DefaultTimeZone)
+	got, err := tmpl.Execute(PromptVars{
+		Username:    "taro",
+		DisplayName: "たろう",
+		ChannelName: "general",
+		ThreadTitle: "雑談",
+		BotName:     "ずんだもん",
+		ModelName:   "gemini-test",
+		Now:         time.Date(2026, 10, 17, 23, 30, 0, 0, time.UTC).In(jst),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `あなたはずんだもん (gemini-test) です。
+たろう さん (taro) と DM の #general (スレッド「雑談」) で話しています。
+今日は 2026年10月18日 です。`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	static, err := ParsePromptTemplate("default", "You are a helpful assistant.")
+	if err != nil || static.UsesDate() {
+		t.Errorf("static prompt should parse without using the date, err=%v", err)
+	}
+}
+
+func TestPromptTemplateErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		line     int
+		column   int
+		contains string
+	}{
+		{"undefined function", "1行目\nこんにちは {{.Username}} さん {{oops .Username}}", 2, 24, `function "oops" not defined`},
+		{"unclosed action", "あいう\n\n  {{.Username", 3, 3, "unclosed action"},
+		{"unknown field", "ようこそ\n{{.GuildName}}の{{.Guild}}へ", 2, 18, "can't evaluate field Guild"},
+		{"missing end", "{{if .GuildName}}サーバー", 1, 1, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePromptTemplate("user1", tt.text)
+			var tmplErr *PromptTemplateError
+			if !errors.As(err, &tmplErr) {
+				t.Fatalf("expected PromptTemplateError, got %v", err)
+			}
+			if tmplErr.Name != "user1" || tmplErr.Line != tt.line || tmplErr.Column != tt.column || !strings.Contains(tmplErr.Msg, tt.contains) {
+				t.Errorf("got %+v, want line %d column %d containing %q", tmplErr, tt.line, tt.column, tt.contains)
+			}
+		})
+	}
+}
+
+func TestLoadModelConfigPromptTemplates(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("bad template reports position", func(t *testing.T) {
+		path := createTestConfigFile(t, dir, "bad.json", `{"prompts": {"default": "ok", "user1": "line1\nhello {{.Nickname}}"}}`)
+		_, err := LoadModelConfig(path)
+		if err == nil || err.Error() != "prompts.user1: 2行9列: executing \"user1\" at <.Nickname>: can't evaluate field Nickname in type loader.PromptVars" {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("bad time zone", func(t *testing.T) {
+		path := createTestConfigFile(t, dir, "tz.json", `{"prompts": {"default": "ok"}, "time_zone": "Mars/Olympus"}`)
+		if _, err := LoadModelConfig(path); err == nil || !strings.HasPrefix(err.Error(), "time_zone:") {
+			t.Errorf("expected time_zone error, got %v", err)
+		}
+	})
+
+	t.Run("templates and location are prepared", func(t *testing.T) {
+		path := createTestConfigFile(t, dir, "ok.json", `{"prompts": {"default": "{{.BotName}}", "user1": "{{upper .Username}}"}, "time_zone": "UTC"}`)
+		cfg, err := LoadModelConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Location() != time.UTC {
+			t.Errorf("expected UTC, got %v", cfg.Location())
+		}
+		tmpl, err := cfg.PromptTemplate("user1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := tmpl.Execute(PromptVars{Username: "taro"}); got != "TARO" {
+			t.Errorf("expected user1's template, got %q", got)
+		}
+		tmpl, _ = cfg.PromptTemplate("someone")
+		if got, _ := tmpl.Execute(PromptVars{BotName: "bot"}); got != "bot" {
+			t.Errorf("expected default template, got %q", got)
+		}
+	})
+}